@@ -1,12 +1,16 @@
 package auth
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"net/http"
+	"os"
+	"strings"
 
 	"github.com/auth0/go-jwt-middleware"
 	"github.com/dgrijalva/jwt-go"
+	"github.com/johnamadeo/intouchgo/utils"
 )
 
 const (
@@ -14,6 +18,8 @@ const (
 	Issuer             = "https://intouch-android.auth0.com/"
 	Audience           = "https://intouch-android-backend.herokuapp.com/"
 	JSONWebKeySet      = "https://intouch-android.auth0.com/.well-known/jwks.json"
+	UserProperty       = "user"
+	AdminAPIKey        = "INTOUCH_ADMIN_API_KEY"
 )
 
 type Jwks struct {
@@ -111,11 +117,47 @@ func GetAuthHandler(handler http.HandlerFunc) http.Handler {
 		// If the signing method is not constant the ValidationKeyGetter callback can be used to implement additional checks
 		// Important to avoid security issues described here: https://auth0.com/blog/2015/03/31/critical-vulnerabilities-in-json-web-token-libraries/
 		SigningMethod: jwt.SigningMethodRS256,
+		UserProperty:  UserProperty,
 	})
 
 	return jwtMiddleware.Handler(handler)
 }
 
+// GetTokenSubject returns the Auth0 user id ("sub" claim) of the access token
+// that the JWT middleware validated for this request.
+func GetTokenSubject(r *http.Request) (string, bool) {
+	token, ok := r.Context().Value(UserProperty).(*jwt.Token)
+	if !ok || token == nil {
+		return "", false
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return "", false
+	}
+
+	subject, ok := claims["sub"].(string)
+	return subject, ok && subject != ""
+}
+
+// GetAdminHandler only lets through requests that carry the admin API key
+// from the environment as a bearer token. If no key is configured, every
+// request is rejected.
+func GetAdminHandler(handler http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, ok := os.LookupEnv(AdminAPIKey)
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		if !ok || key == "" || subtle.ConstantTimeCompare([]byte(token), []byte(key)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write(utils.MessageToBytes(InvalidAccessToken))
+			return
+		}
+
+		handler(w, r)
+	})
+}
+
 func GetFakeAuthHandler(handler http.HandlerFunc) http.Handler {
 	return handler
 }
@@ -0,0 +1,65 @@
+// Package blob stores files such as letter previews outside the database,
+// either on the local filesystem or in an S3-compatible bucket.
+package blob
+
+import (
+	"errors"
+	"os"
+	"strings"
+)
+
+const (
+	StoreEnv      = "BLOB_STORE"
+	FileStoreName = "filesystem"
+	S3StoreName   = "s3"
+)
+
+var (
+	ErrNotFound   = errors.New("No blob with requested key found")
+	ErrInvalidKey = errors.New("Blob keys must be relative slash-separated paths")
+)
+
+// Store saves blobs under slash-separated keys such as
+// "letters/<id>/preview/pdf". Putting a key that already exists replaces it.
+type Store interface {
+	Put(key string, contentType string, data []byte) error
+	Get(key string) (Blob, error)
+	Delete(key string) error
+}
+
+type Blob struct {
+	Key         string
+	ContentType string
+	Data        []byte
+}
+
+// NewStoreFromEnv returns the store named by BLOB_STORE, which defaults to
+// the filesystem. Heroku's filesystem doesn't outlive a dyno restart, so
+// deployments there should use s3.
+func NewStoreFromEnv() (Store, error) {
+	name := FileStoreName
+	if value, ok := os.LookupEnv(StoreEnv); ok && value != "" {
+		name = value
+	}
+
+	switch name {
+	case FileStoreName:
+		return NewFileStoreFromEnv(), nil
+	case S3StoreName:
+		return NewS3StoreFromEnv()
+	}
+	return nil, errors.New("BLOB_STORE must be either filesystem or s3")
+}
+
+func checkKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return ErrInvalidKey
+	}
+
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return ErrInvalidKey
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package blob
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const (
+	FileStoreDirEnv     = "BLOB_DIR"
+	DefaultFileStoreDir = "./blobs"
+
+	// Content types are kept next to each blob in a file with this suffix
+	contentTypeSuffix = ".content-type"
+)
+
+// FileStore keeps blobs as files under Dir
+type FileStore struct {
+	Dir string
+}
+
+func NewFileStoreFromEnv() *FileStore {
+	dir := DefaultFileStoreDir
+	if value, ok := os.LookupEnv(FileStoreDirEnv); ok && value != "" {
+		dir = value
+	}
+
+	return &FileStore{Dir: dir}
+}
+
+func (s *FileStore) Put(key string, contentType string, data []byte) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+
+	err = writeFileAtomic(path+contentTypeSuffix, []byte(contentType))
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, data)
+}
+
+func (s *FileStore) Get(key string) (Blob, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return Blob{}, err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return Blob{}, ErrNotFound
+	} else if err != nil {
+		return Blob{}, err
+	}
+
+	contentType, err := ioutil.ReadFile(path + contentTypeSuffix)
+	if err != nil && !os.IsNotExist(err) {
+		return Blob{}, err
+	}
+
+	return Blob{Key: key, ContentType: string(contentType), Data: data}, nil
+}
+
+func (s *FileStore) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	err = os.Remove(path + contentTypeSuffix)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+func (s *FileStore) path(key string) (string, error) {
+	if err := checkKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.Dir, filepath.FromSlash(key)), nil
+}
+
+// writeFileAtomic writes to a temporary file first so that a reader never
+// sees a half-written blob
+func writeFileAtomic(path string, data []byte) error {
+	file, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	err = os.Rename(file.Name(), path)
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	return nil
+}
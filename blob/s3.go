@@ -0,0 +1,265 @@
+package blob
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/johnamadeo/intouchgo/resilient"
+)
+
+const (
+	S3EndpointEnv        = "S3_ENDPOINT"
+	S3RegionEnv          = "S3_REGION"
+	S3BucketEnv          = "S3_BUCKET"
+	S3AccessKeyIdEnv     = "S3_ACCESS_KEY_ID"
+	S3SecretAccessKeyEnv = "S3_SECRET_ACCESS_KEY"
+
+	DefaultS3Region = "us-east-1"
+	S3Timeout       = 60 * time.Second
+
+	amzDateLayout  = "20060102T150405Z"
+	amzDayLayout   = "20060102"
+	signingService = "s3"
+)
+
+// S3Store keeps blobs in a bucket of any S3-compatible service, addressed
+// path-style (Endpoint/Bucket/key) so that it works with services that don't
+// support virtual-hosted buckets. Requests are signed with AWS Signature
+// Version 4.
+type S3Store struct {
+	HTTPClient      *http.Client
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyId     string
+	SecretAccessKey string
+}
+
+// S3Error is returned when the service responds with an unexpected status
+type S3Error struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *S3Error) Error() string {
+	return "S3 request failed with status " + strconv.Itoa(e.StatusCode) + ": " + e.Body
+}
+
+func NewS3StoreFromEnv() (*S3Store, error) {
+	region := os.Getenv(S3RegionEnv)
+	if region == "" {
+		region = DefaultS3Region
+	}
+
+	endpoint := os.Getenv(S3EndpointEnv)
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+
+	store := &S3Store{
+		HTTPClient:      resilient.NewClient("s3", S3Timeout),
+		Endpoint:        strings.TrimRight(endpoint, "/"),
+		Region:          region,
+		Bucket:          os.Getenv(S3BucketEnv),
+		AccessKeyId:     os.Getenv(S3AccessKeyIdEnv),
+		SecretAccessKey: os.Getenv(S3SecretAccessKeyEnv),
+	}
+
+	if store.Bucket == "" || store.AccessKeyId == "" || store.SecretAccessKey == "" {
+		return nil, errors.New(S3BucketEnv + ", " + S3AccessKeyIdEnv + " and " + S3SecretAccessKeyEnv + " must be set to use the s3 blob store")
+	}
+	return store, nil
+}
+
+func (s *S3Store) Put(key string, contentType string, data []byte) error {
+	resp, err := s.do("PUT", key, contentType, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return readS3Error(resp)
+	}
+	return nil
+}
+
+func (s *S3Store) Get(key string) (Blob, error) {
+	resp, err := s.do("GET", key, "", nil)
+	if err != nil {
+		return Blob{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return Blob{}, ErrNotFound
+	} else if resp.StatusCode != http.StatusOK {
+		return Blob{}, readS3Error(resp)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return Blob{}, err
+	}
+
+	return Blob{Key: key, ContentType: resp.Header.Get("Content-Type"), Data: data}, nil
+}
+
+func (s *S3Store) Delete(key string) error {
+	resp, err := s.do("DELETE", key, "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return readS3Error(resp)
+	}
+	return nil
+}
+
+func (s *S3Store) do(method string, key string, contentType string, data []byte) (*http.Response, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+
+	path := "/" + escapePath(s.Bucket) + "/" + escapePath(key)
+	req, err := http.NewRequest(method, s.Endpoint+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	s.sign(req, data, time.Now().UTC())
+
+	return s.HTTPClient.Do(req)
+}
+
+// sign adds the headers of AWS Signature Version 4.
+// https://docs.aws.amazon.com/general/latest/gr/sigv4_signing.html
+func (s *S3Store) sign(req *http.Request, payload []byte, now time.Time) {
+	payloadHash := sha256Hex(payload)
+	amzDate := now.Format(amzDateLayout)
+	scope := now.Format(amzDayLayout) + "/" + s.Region + "/" + signingService + "/aws4_request"
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "content-type" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(req.Header.Get(name))
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	signingKey := hmacSHA256([]byte("AWS4"+s.SecretAccessKey), now.Format(amzDayLayout))
+	signingKey = hmacSHA256(signingKey, s.Region)
+	signingKey = hmacSHA256(signingKey, signingService)
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set(
+		"Authorization",
+		"AWS4-HMAC-SHA256 Credential="+s.AccessKeyId+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature,
+	)
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := []string{}
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, escapeSegment(key)+"="+escapeSegment(value))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+func escapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = escapeSegment(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// escapeSegment percent-encodes everything but the characters SigV4 leaves
+// unreserved
+func escapeSegment(segment string) string {
+	var escaped strings.Builder
+	for i := 0; i < len(segment); i++ {
+		c := segment[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			escaped.WriteByte(c)
+		} else {
+			escaped.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{c})))
+		}
+	}
+	return escaped.String()
+}
+
+func readS3Error(resp *http.Response) error {
+	body, _ := ioutil.ReadAll(resp.Body)
+	return &S3Error{StatusCode: resp.StatusCode, Body: string(body)}
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,252 @@
+// Package form encodes structs as application/x-www-form-urlencoded values
+// using the bracket notation Lob expects for nested objects, e.g.
+// to[address_line1]=... and metadata[letter_id]=...
+//
+// Fields are named with a `form` struct tag. Fields without a tag are
+// skipped, as are fields tagged with "-". The tag may be followed by
+// comma-separated options:
+//
+//	omitempty    skip the field if it holds its type's zero value
+//	layout=...   the time.Format layout used for a time.Time (RFC 3339 by default)
+//	date         shorthand for layout=2006-01-02
+//	precision=N  the number of decimals used for a float
+package form
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DateLayout = "2006-01-02"
+)
+
+// Marshaler is implemented by types that encode themselves, e.g. a field that
+// is sent either as an ID or as an inline object. key is the fully bracketed
+// name of the field being encoded.
+type Marshaler interface {
+	MarshalForm(key string, values url.Values) error
+}
+
+type UnsupportedTypeError struct {
+	Key  string
+	Type reflect.Type
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	return "form: unsupported type " + e.Type.String() + " for field " + e.Key
+}
+
+type options struct {
+	omitEmpty bool
+	layout    string
+	precision int
+}
+
+var (
+	marshalerType = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	timeType      = reflect.TypeOf(time.Time{})
+)
+
+// Encode returns the form values for v, which must be a struct or a pointer
+// to one.
+func Encode(v interface{}) (url.Values, error) {
+	values := url.Values{}
+
+	value := reflect.ValueOf(v)
+	for value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return values, nil
+		}
+		value = value.Elem()
+	}
+
+	if value.Kind() != reflect.Struct {
+		return nil, errors.New("form: Encode expects a struct, got " + value.Kind().String())
+	}
+
+	err := encodeStruct("", value, values)
+	if err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+// EncodeInto encodes v under key into values, e.g. the fields of an address
+// struct as to[address_line1], to[address_city] and so on. It is meant for
+// Marshaler implementations that encode a nested object.
+func EncodeInto(key string, v interface{}, values url.Values) error {
+	return encodeValue(key, reflect.ValueOf(v), options{precision: -1}, values)
+}
+
+func encodeStruct(prefix string, value reflect.Value, values url.Values) error {
+	t := value.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fieldValue := value.Field(i)
+
+		// Promote the fields of untagged embedded structs, like encoding/json
+		tag, hasTag := field.Tag.Lookup("form")
+		if field.Anonymous && !hasTag {
+			for fieldValue.Kind() == reflect.Ptr {
+				if fieldValue.IsNil() {
+					break
+				}
+				fieldValue = fieldValue.Elem()
+			}
+			if fieldValue.Kind() == reflect.Struct {
+				if err := encodeStruct(prefix, fieldValue, values); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if !hasTag || tag == "-" || field.PkgPath != "" {
+			continue
+		}
+
+		name, opts, err := parseTag(tag)
+		if err != nil {
+			return fmt.Errorf("form: field %s: %s", field.Name, err.Error())
+		}
+
+		if opts.omitEmpty && isEmptyValue(fieldValue) {
+			continue
+		}
+
+		err = encodeValue(joinKey(prefix, name), fieldValue, opts, values)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func encodeValue(key string, value reflect.Value, opts options, values url.Values) error {
+	if value.Type().Implements(marshalerType) {
+		if value.Kind() == reflect.Ptr && value.IsNil() {
+			return nil
+		}
+		return value.Interface().(Marshaler).MarshalForm(key, values)
+	}
+
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if value.IsNil() {
+			return nil
+		}
+		return encodeValue(key, value.Elem(), opts, values)
+	case reflect.String:
+		values.Set(key, value.String())
+	case reflect.Bool:
+		values.Set(key, strconv.FormatBool(value.Bool()))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		values.Set(key, strconv.FormatInt(value.Int(), 10))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		values.Set(key, strconv.FormatUint(value.Uint(), 10))
+	case reflect.Float32, reflect.Float64:
+		values.Set(key, strconv.FormatFloat(value.Float(), 'f', opts.precision, value.Type().Bits()))
+	case reflect.Struct:
+		if value.Type() == timeType {
+			layout := opts.layout
+			if layout == "" {
+				layout = time.RFC3339
+			}
+			values.Set(key, value.Interface().(time.Time).Format(layout))
+			return nil
+		}
+		return encodeStruct(key, value, values)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			err := encodeValue(key+"["+strconv.Itoa(i)+"]", value.Index(i), opts, values)
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if value.Type().Key().Kind() != reflect.String {
+			return &UnsupportedTypeError{key, value.Type()}
+		}
+
+		// Sort so that the encoded body is deterministic
+		keys := value.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+		for _, mapKey := range keys {
+			err := encodeValue(key+"["+mapKey.String()+"]", value.MapIndex(mapKey), opts, values)
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		return &UnsupportedTypeError{key, value.Type()}
+	}
+
+	return nil
+}
+
+func joinKey(prefix string, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "[" + name + "]"
+}
+
+func parseTag(tag string) (string, options, error) {
+	parts := strings.Split(tag, ",")
+	opts := options{precision: -1}
+
+	for _, option := range parts[1:] {
+		switch {
+		case option == "omitempty":
+			opts.omitEmpty = true
+		case option == "date":
+			opts.layout = DateLayout
+		case strings.HasPrefix(option, "layout="):
+			opts.layout = strings.TrimPrefix(option, "layout=")
+		case strings.HasPrefix(option, "precision="):
+			precision, err := strconv.Atoi(strings.TrimPrefix(option, "precision="))
+			if err != nil || precision < 0 {
+				return "", opts, errors.New("invalid precision option " + option)
+			}
+			opts.precision = precision
+		default:
+			return "", opts, errors.New("unknown tag option " + option)
+		}
+	}
+
+	return parts[0], opts, nil
+}
+
+func isEmptyValue(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return value.Len() == 0
+	case reflect.Bool:
+		return !value.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return value.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return value.Float() == 0
+	case reflect.Interface, reflect.Ptr, reflect.Chan, reflect.Func:
+		return value.IsNil()
+	case reflect.Struct:
+		if value.Type() == timeType {
+			return value.Interface().(time.Time).IsZero()
+		}
+	}
+	return false
+}
@@ -0,0 +1,139 @@
+package form
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testAddress struct {
+	Line1 string `form:"address_line1"`
+	City  string `form:"address_city,omitempty"`
+}
+
+type testAddressId string
+
+func (id testAddressId) MarshalForm(key string, values url.Values) error {
+	values.Set(key, "adr_"+string(id))
+	return nil
+}
+
+type testEmbedded struct {
+	Description string `form:"description,omitempty"`
+}
+
+type testRequest struct {
+	testEmbedded
+	To       testAddress       `form:"to"`
+	From     testAddressId     `form:"from"`
+	Color    bool              `form:"color"`
+	Pages    int               `form:"pages,omitempty"`
+	Price    float64           `form:"price,precision=2"`
+	SendDate time.Time         `form:"send_date,omitempty,date"`
+	Sent     time.Time         `form:"sent,omitempty"`
+	Tags     []string          `form:"tags"`
+	Metadata map[string]string `form:"metadata"`
+	Extra    *testAddress      `form:"extra"`
+	Skipped  string            `form:"-"`
+	Untagged string
+}
+
+func TestEncode(t *testing.T) {
+	sent := time.Date(2018, 10, 8, 9, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		request interface{}
+		want    url.Values
+	}{
+		{
+			name: "nested fields and options",
+			request: testRequest{
+				testEmbedded: testEmbedded{Description: "InTouch letter"},
+				To:           testAddress{Line1: "1 Main St"},
+				From:         "123",
+				Color:        true,
+				Price:        1.5,
+				SendDate:     sent,
+				Sent:         sent,
+				Tags:         []string{"a", "b"},
+				Metadata:     map[string]string{"letter_id": "eiwo-19da-p2gv", "app": "intouch"},
+				Skipped:      "skipped",
+				Untagged:     "untagged",
+			},
+			want: url.Values{
+				"description":         {"InTouch letter"},
+				"to[address_line1]":   {"1 Main St"},
+				"from":                {"adr_123"},
+				"color":               {"true"},
+				"price":               {"1.50"},
+				"send_date":           {"2018-10-08"},
+				"sent":                {"2018-10-08T09:30:00Z"},
+				"tags[0]":             {"a"},
+				"tags[1]":             {"b"},
+				"metadata[app]":       {"intouch"},
+				"metadata[letter_id]": {"eiwo-19da-p2gv"},
+			},
+		},
+		{
+			name:    "empty fields",
+			request: &testRequest{Extra: &testAddress{City: "Hartford"}},
+			want: url.Values{
+				"to[address_line1]":    {""},
+				"from":                 {"adr_"},
+				"color":                {"false"},
+				"price":                {"0.00"},
+				"extra[address_line1]": {""},
+				"extra[address_city]":  {"Hartford"},
+			},
+		},
+		{
+			name:    "nil pointer",
+			request: (*testRequest)(nil),
+			want:    url.Values{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Encode(test.request)
+			if err != nil {
+				t.Fatalf("Encode() = %v", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Encode() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		request interface{}
+	}{
+		{"not a struct", "letter"},
+		{"unknown option", struct {
+			Name string `form:"name,upper"`
+		}{}},
+		{"invalid precision", struct {
+			Price float64 `form:"price,precision=-1"`
+		}{}},
+		{"unsupported type", struct {
+			Done chan bool `form:"done"`
+		}{make(chan bool)}},
+		{"map without string keys", struct {
+			Counts map[int]string `form:"counts"`
+		}{map[int]string{1: "one"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Encode(test.request)
+			if err == nil {
+				t.Errorf("Encode(%#v) = nil error, want an error", test.request)
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package i18n
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	LocalesDir    = "./locales"
+	DefaultLocale = "en"
+)
+
+var (
+	ErrCatalogsNotLoaded = errors.New("Message catalogs have not been loaded")
+
+	catalogsMutex sync.RWMutex
+	catalogs      map[string]map[string]string
+)
+
+// LoadCatalogs reads one catalog per locale from the <locale>.json files in
+// dir. Every catalog must have exactly the keys of the English one, with the
+// same number of formatting verbs, so that a key added in one language and
+// forgotten in another stops the server at startup rather than showing
+// users a key. It can be called again to reload.
+func LoadCatalogs(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+
+	loaded := make(map[string]map[string]string)
+	for _, path := range paths {
+		bytes, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		catalog := make(map[string]string)
+		err = json.Unmarshal(bytes, &catalog)
+		if err != nil {
+			return errors.New(filepath.Base(path) + ": " + err.Error())
+		}
+
+		loaded[strings.TrimSuffix(filepath.Base(path), ".json")] = catalog
+	}
+
+	err = CheckCatalogs(loaded)
+	if err != nil {
+		return err
+	}
+
+	catalogsMutex.Lock()
+	catalogs = loaded
+	catalogsMutex.Unlock()
+	return nil
+}
+
+// CheckCatalogs compares every catalog against the English one and lists
+// each missing, unknown or mismatched key
+func CheckCatalogs(loaded map[string]map[string]string) error {
+	reference, ok := loaded[DefaultLocale]
+	if !ok {
+		return errors.New("the " + DefaultLocale + " message catalog is missing")
+	}
+
+	problems := []string{}
+	for locale, catalog := range loaded {
+		for key, message := range reference {
+			translation, ok := catalog[key]
+			if !ok {
+				problems = append(problems, locale+": missing key "+key)
+			} else if strings.TrimSpace(translation) == "" {
+				problems = append(problems, locale+": empty message for "+key)
+			} else if countVerbs(translation) != countVerbs(message) {
+				problems = append(problems, locale+": "+key+" should have "+strconv.Itoa(countVerbs(message))+" formatting verbs")
+			}
+		}
+
+		for key := range catalog {
+			if _, ok := reference[key]; !ok {
+				problems = append(problems, locale+": unknown key "+key)
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return errors.New("Invalid message catalogs:\n" + strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+// Locales returns every locale with a catalog
+func Locales() []string {
+	catalogsMutex.RLock()
+	defer catalogsMutex.RUnlock()
+
+	locales := []string{}
+	for locale := range catalogs {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+func IsSupported(locale string) bool {
+	catalogsMutex.RLock()
+	defer catalogsMutex.RUnlock()
+
+	_, ok := catalogs[locale]
+	return ok
+}
+
+// Lookup returns the message for key in the locale, if the locale has it
+func Lookup(locale string, key string) (string, bool) {
+	catalogsMutex.RLock()
+	defer catalogsMutex.RUnlock()
+
+	message, ok := catalogs[locale][key]
+	return message, ok
+}
+
+// T returns the message for key in the locale, formatted with args like
+// fmt.Sprintf. Unsupported locales get English, and a key that isn't in
+// any catalog is returned as is.
+func T(locale string, key string, args ...interface{}) string {
+	catalogsMutex.RLock()
+	message, ok := catalogs[locale][key]
+	if !ok {
+		message, ok = catalogs[DefaultLocale][key]
+	}
+	catalogsMutex.RUnlock()
+
+	if !ok {
+		return key
+	}
+
+	if len(args) == 0 {
+		return message
+	}
+	return fmt.Sprintf(message, args...)
+}
+
+// MatchAcceptLanguage picks the supported locale the client prefers most
+// from an Accept-Language header, e.g. "es-MX,es;q=0.9,en;q=0.8". Regional
+// variants match their language. It returns "" if none is supported.
+func MatchAcceptLanguage(header string) string {
+	type preference struct {
+		locale string
+		q      float64
+	}
+
+	preferences := []preference{}
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				value, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+				if err == nil {
+					q = value
+				}
+			}
+		}
+
+		if q > 0 {
+			preferences = append(preferences, preference{locale: strings.SplitN(tag, "-", 2)[0], q: q})
+		}
+	}
+
+	sort.SliceStable(preferences, func(i, j int) bool {
+		return preferences[i].q > preferences[j].q
+	})
+
+	for _, preference := range preferences {
+		if IsSupported(preference.locale) {
+			return preference.locale
+		}
+	}
+	return ""
+}
+
+// countVerbs counts the formatting verbs in a message, not counting "%%"
+func countVerbs(message string) int {
+	return strings.Count(strings.Replace(message, "%%", "", -1), "%")
+}
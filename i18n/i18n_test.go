@@ -0,0 +1,178 @@
+package i18n
+
+import (
+	"strings"
+	"testing"
+)
+
+const testLocalesDir = "../locales"
+
+func TestLoadCatalogs(t *testing.T) {
+	err := LoadCatalogs(testLocalesDir)
+	if err != nil {
+		t.Fatalf("LoadCatalogs(%q) = %v", testLocalesDir, err)
+	}
+
+	for _, locale := range []string{"en", "es"} {
+		if !IsSupported(locale) {
+			t.Errorf("IsSupported(%q) = false after loading %s", locale, testLocalesDir)
+		}
+	}
+}
+
+func TestCheckCatalogs(t *testing.T) {
+	tests := []struct {
+		name    string
+		loaded  map[string]map[string]string
+		problem string // "" if the catalogs are valid
+	}{
+		{
+			name: "valid",
+			loaded: map[string]map[string]string{
+				"en": {"greeting": "Hello %s", "bye": "Bye"},
+				"es": {"greeting": "Hola %s", "bye": "Adiós"},
+			},
+		},
+		{
+			name: "percent sign isn't a verb",
+			loaded: map[string]map[string]string{
+				"en": {"discount": "10%% off"},
+				"es": {"discount": "10%% de descuento"},
+			},
+		},
+		{
+			name: "missing key",
+			loaded: map[string]map[string]string{
+				"en": {"greeting": "Hello %s", "bye": "Bye"},
+				"es": {"greeting": "Hola %s"},
+			},
+			problem: "es: missing key bye",
+		},
+		{
+			name: "unknown key",
+			loaded: map[string]map[string]string{
+				"en": {"greeting": "Hello"},
+				"es": {"greeting": "Hola", "extra": "Extra"},
+			},
+			problem: "es: unknown key extra",
+		},
+		{
+			name: "empty message",
+			loaded: map[string]map[string]string{
+				"en": {"greeting": "Hello"},
+				"es": {"greeting": "  "},
+			},
+			problem: "es: empty message for greeting",
+		},
+		{
+			name: "verb count",
+			loaded: map[string]map[string]string{
+				"en": {"greeting": "Hello %s"},
+				"es": {"greeting": "Hola"},
+			},
+			problem: "es: greeting should have 1 formatting verbs",
+		},
+		{
+			name: "no english catalog",
+			loaded: map[string]map[string]string{
+				"es": {"greeting": "Hola"},
+			},
+			problem: "the en message catalog is missing",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := CheckCatalogs(test.loaded)
+			if test.problem == "" && err != nil {
+				t.Errorf("CheckCatalogs() = %v, want nil", err)
+			} else if test.problem != "" && (err == nil || !strings.Contains(err.Error(), test.problem)) {
+				t.Errorf("CheckCatalogs() = %v, want an error reporting %q", err, test.problem)
+			}
+		})
+	}
+}
+
+// A key removed from one of the real catalogs must be reported
+func TestCheckCatalogsReportsMissingKey(t *testing.T) {
+	err := LoadCatalogs(testLocalesDir)
+	if err != nil {
+		t.Fatalf("LoadCatalogs(%q) = %v", testLocalesDir, err)
+	}
+
+	loaded := make(map[string]map[string]string)
+	for _, locale := range Locales() {
+		loaded[locale] = make(map[string]string)
+		for key, message := range catalogs[locale] {
+			loaded[locale][key] = message
+		}
+	}
+
+	for _, locale := range Locales() {
+		if locale == DefaultLocale {
+			continue
+		}
+
+		delete(loaded[locale], "error.letterNotFound")
+		err := CheckCatalogs(loaded)
+		want := locale + ": missing key error.letterNotFound"
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("CheckCatalogs() = %v, want an error reporting %q", err, want)
+		}
+		loaded[locale]["error.letterNotFound"] = catalogs[locale]["error.letterNotFound"]
+	}
+}
+
+func TestMatchAcceptLanguage(t *testing.T) {
+	err := LoadCatalogs(testLocalesDir)
+	if err != nil {
+		t.Fatalf("LoadCatalogs(%q) = %v", testLocalesDir, err)
+	}
+
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"es", "es"},
+		{"es-MX,es;q=0.9,en;q=0.8", "es"},
+		{"EN-us", "en"},
+		{"fr-FR,fr;q=0.9,es;q=0.5,en;q=0.4", "es"},
+		{"en;q=0.5, es;q=0.8", "es"},
+		{"es;q=0, en", "en"},
+		{"fr, de", ""},
+		{"*", ""},
+		{"es;q=abc", "es"},
+	}
+
+	for _, test := range tests {
+		if got := MatchAcceptLanguage(test.header); got != test.want {
+			t.Errorf("MatchAcceptLanguage(%q) = %q, want %q", test.header, got, test.want)
+		}
+	}
+}
+
+func TestT(t *testing.T) {
+	err := LoadCatalogs(testLocalesDir)
+	if err != nil {
+		t.Fatalf("LoadCatalogs(%q) = %v", testLocalesDir, err)
+	}
+
+	tests := []struct {
+		locale string
+		key    string
+		args   []interface{}
+		want   string
+	}{
+		{"en", "error.letterNotFound", nil, "No letter with requested id found"},
+		{"fr", "error.letterNotFound", nil, "No letter with requested id found"},
+		{"es", "no.such.key", nil, "no.such.key"},
+		{"en", "field.nameTooLong", []interface{}{40}, "Name must be at most 40 characters"},
+	}
+
+	for _, test := range tests {
+		if got := T(test.locale, test.key, test.args...); got != test.want {
+			t.Errorf("T(%q, %q) = %q, want %q", test.locale, test.key, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package lob
+
+import (
+	"net/url"
+
+	"github.com/johnamadeo/intouchgo/form"
+)
+
+// https://lob.com/docs#addresses_object
+type LobAddress struct {
+	Id             string            `json:"id"`
+	Description    string            `json:"description"`
+	Name           string            `json:"name"`
+	Company        string            `json:"company"`
+	Phone          string            `json:"phone"`
+	Email          string            `json:"email"`
+	AddressLine1   string            `json:"address_line1"`
+	AddressLine2   string            `json:"address_line2"`
+	AddressCity    string            `json:"address_city"`
+	AddressState   string            `json:"address_state"`
+	AddressZip     string            `json:"address_zip"`
+	AddressCountry string            `json:"address_country"`
+	Metadata       map[string]string `json:"metadata"`
+	DateCreated    string            `json:"date_created"`
+	DateModified   string            `json:"date_modified"`
+	Object         string            `json:"object"`
+}
+
+// https://lob.com/docs#addresses_create
+type LobCreateAddressRequest struct {
+	Description    string            `form:"description,omitempty"`
+	Name           string            `form:"name,omitempty"`
+	Company        string            `form:"company,omitempty"`
+	AddressLine1   string            `form:"address_line1"`
+	AddressLine2   string            `form:"address_line2,omitempty"`
+	AddressCity    string            `form:"address_city,omitempty"`
+	AddressState   string            `form:"address_state,omitempty"`
+	AddressZip     string            `form:"address_zip,omitempty"`
+	AddressCountry string            `form:"address_country,omitempty"`
+	Metadata       map[string]string `form:"metadata,omitempty"`
+}
+
+// LobAddressParam is the to or from of a letter or postcard, sent either as
+// the id of an existing Lob address or as an inline address
+type LobAddressParam struct {
+	Id      string
+	Address *LobCreateAddressRequest
+}
+
+func AddressId(id string) LobAddressParam {
+	return LobAddressParam{Id: id}
+}
+
+func InlineAddress(address LobCreateAddressRequest) LobAddressParam {
+	return LobAddressParam{Address: &address}
+}
+
+func (p LobAddressParam) MarshalForm(key string, values url.Values) error {
+	if p.Address != nil {
+		return form.EncodeInto(key, p.Address, values)
+	}
+
+	if p.Id != "" {
+		values.Set(key, p.Id)
+	}
+	return nil
+}
+
+type LobAddressList struct {
+	LobList
+	Data []LobAddress `json:"data"`
+}
+
+func (c *Client) CreateAddress(request LobCreateAddressRequest) (LobAddress, error) {
+	var address LobAddress
+	err := c.post("addresses", request, "", &address)
+	return address, err
+}
+
+func (c *Client) GetAddress(id string) (LobAddress, error) {
+	var address LobAddress
+	err := c.get("addresses/"+url.PathEscape(id), nil, &address)
+	return address, err
+}
+
+func (c *Client) ListAddresses(params ListParams) (LobAddressList, error) {
+	var list LobAddressList
+
+	values, err := params.values()
+	if err != nil {
+		return list, err
+	}
+
+	err = c.get("addresses", values, &list)
+	return list, err
+}
+
+func (c *Client) DeleteAddress(id string) (LobDeleteResponse, error) {
+	var response LobDeleteResponse
+	err := c.delete("addresses/"+url.PathEscape(id), &response)
+	return response, err
+}
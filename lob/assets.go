@@ -0,0 +1,65 @@
+package lob
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/johnamadeo/intouchgo/resilient"
+)
+
+const (
+	// Rendered letters are a few MB at most; anything bigger isn't one
+	MaxAssetSize = 25 << 20
+)
+
+var (
+	ErrAssetTooLarge = errors.New("Lob asset is larger than the maximum size")
+
+	assetClient = resilient.NewClient("lob-assets", resilient.DefaultTimeout)
+)
+
+// DownloadAsset fetches a rendered PDF or thumbnail. Lob hands these out as
+// signed URLs that expire after a while, so they need no API key but must be
+// downloaded soon after the letter or its webhook event was received. Lob
+// answers 404 (or 403) until the asset has finished rendering.
+func DownloadAsset(assetURL string) ([]byte, string, error) {
+	parsed, err := url.Parse(assetURL)
+	if err != nil {
+		return nil, "", err
+	} else if parsed.Scheme != "https" {
+		return nil, "", errors.New("Lob asset URLs must use https")
+	}
+
+	req, err := http.NewRequest("GET", assetURL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Add("User-Agent", LobUserAgent)
+
+	resp, err := assetClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, MaxAssetSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, "", newLobError(resp.StatusCode, parsed.Scheme+"://"+parsed.Host+parsed.Path, data)
+	} else if len(data) > MaxAssetSize {
+		return nil, "", ErrAssetTooLarge
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" || strings.HasPrefix(contentType, "binary/") || contentType == "application/octet-stream" {
+		contentType = http.DetectContentType(data)
+	}
+	return data, contentType, nil
+}
@@ -0,0 +1,205 @@
+package lob
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/google/go-querystring/query"
+	"github.com/johnamadeo/intouchgo/form"
+	"github.com/johnamadeo/intouchgo/resilient"
+)
+
+const (
+	// Pin the API version so that a change to the account's default version
+	// in the Lob dashboard can't silently change the shape of responses
+	LobAPIVersion = "2018-06-05"
+)
+
+// Client talks to the Lob API using a single API key. Use NewClient to get a
+// client for the test or live environment; the fields are exported so that
+// the HTTP client and base URL can be swapped out, e.g. to point at a stub.
+type Client struct {
+	HTTPClient  *http.Client
+	BaseURL     string
+	APIKey      string
+	Version     string
+	Environment string
+}
+
+// ListParams are the cursor pagination and filtering options accepted by
+// Lob's list endpoints. Before and After are the cursors from the previous
+// page; only one of them should be set.
+type ListParams struct {
+	Limit    int               `url:"limit,omitempty"`
+	Before   string            `url:"before,omitempty"`
+	After    string            `url:"after,omitempty"`
+	Metadata map[string]string `url:"-"`
+}
+
+// LobList holds the pagination fields common to every Lob list response
+type LobList struct {
+	Object      string `json:"object"`
+	NextURL     string `json:"next_url"`
+	PreviousURL string `json:"previous_url"`
+	Count       int    `json:"count"`
+	TotalCount  int    `json:"total_count"`
+}
+
+// LobDeleteResponse is returned when a resource is deleted or cancelled
+type LobDeleteResponse struct {
+	Id      string `json:"id"`
+	Deleted bool   `json:"deleted"`
+}
+
+func NewClient(environment string) (*Client, error) {
+	key, err := getAPIKey(environment)
+	if err != nil {
+		return nil, err
+	}
+
+	// Lob API keys start with the environment they belong to, so catch a live
+	// key configured as the test key (or the other way around) before it is
+	// used to mail anything
+	if !strings.HasPrefix(key, environment+"_") {
+		return nil, errors.New("The Lob API key configured for " + environment + " is not a " + environment + " key")
+	}
+
+	return NewClientWithKey(key, environment), nil
+}
+
+func NewClientWithKey(apiKey string, environment string) *Client {
+	return &Client{
+		HTTPClient:  resilient.NewClient("lob", resilient.DefaultTimeout),
+		BaseURL:     LobBaseAPI,
+		APIKey:      apiKey,
+		Version:     LobAPIVersion,
+		Environment: environment,
+	}
+}
+
+// HasMore reports whether there is another page after this one
+func (l LobList) HasMore() bool {
+	return l.NextURL != ""
+}
+
+// NextCursor returns the value to pass as ListParams.After to fetch the next
+// page, or "" if this is the last page
+func (l LobList) NextCursor() string {
+	return getCursor(l.NextURL, "after")
+}
+
+// PreviousCursor returns the value to pass as ListParams.Before to fetch the
+// previous page, or "" if this is the first page
+func (l LobList) PreviousCursor() string {
+	return getCursor(l.PreviousURL, "before")
+}
+
+func getCursor(pageURL string, param string) string {
+	if pageURL == "" {
+		return ""
+	}
+
+	parsed, err := url.Parse(pageURL)
+	if err != nil {
+		return ""
+	}
+
+	return parsed.Query().Get(param)
+}
+
+func (params ListParams) values() (url.Values, error) {
+	values, err := query.Values(params)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range params.Metadata {
+		values.Set("metadata["+k+"]", v)
+	}
+
+	return values, nil
+}
+
+func (c *Client) get(endpoint string, params url.Values, returnValue interface{}) error {
+	if len(params) > 0 {
+		endpoint += "?" + params.Encode()
+	}
+
+	return c.do("GET", endpoint, nil, "", returnValue)
+}
+
+// post sends a create request. If idempotencyKey is set, Lob returns the
+// object created by the first request with that key instead of creating a
+// second one, which also lets the request be retried safely.
+// https://lob.com/docs#idempotent-requests
+func (c *Client) post(endpoint string, request interface{}, idempotencyKey string, returnValue interface{}) error {
+	params, err := form.Encode(request)
+	if err != nil {
+		return err
+	}
+
+	return c.do("POST", endpoint, params, idempotencyKey, returnValue)
+}
+
+func (c *Client) delete(endpoint string, returnValue interface{}) error {
+	return c.do("DELETE", endpoint, nil, "", returnValue)
+}
+
+func (c *Client) do(method string, endpoint string, params url.Values, idempotencyKey string, returnValue interface{}) error {
+	fullURL := strings.TrimSuffix(c.BaseURL, "/") + "/" + endpoint
+	fmt.Println("Lob", method, fullURL)
+
+	var body io.Reader
+	if params != nil {
+		body = bytes.NewBufferString(params.Encode())
+	}
+
+	req, err := http.NewRequest(method, fullURL, body)
+	if err != nil {
+		return err
+	}
+
+	if body != nil {
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	req.SetBasicAuth(c.APIKey, "")
+	req.Header.Add("Lob-Version", c.Version)
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("User-Agent", LobUserAgent)
+	if idempotencyKey != "" {
+		req.Header.Add(resilient.IdempotencyKeyHeader, idempotencyKey)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return newLobError(resp.StatusCode, fullURL, data)
+	}
+
+	if returnValue == nil {
+		return nil
+	}
+	return json.Unmarshal(data, returnValue)
+}
@@ -0,0 +1,48 @@
+package lob
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// LobError is returned for every non-2xx response from the Lob API.
+// https://lob.com/docs#errors
+type LobError struct {
+	StatusCode int    `json:"status_code"`
+	Message    string `json:"message"`
+	Code       string `json:"code"`
+	URL        string `json:"-"`
+}
+
+type lobErrorBody struct {
+	Error LobError `json:"error"`
+}
+
+func (e *LobError) Error() string {
+	message := "Lob returned status code " + strconv.Itoa(e.StatusCode) + " from " + e.URL
+	if e.Message != "" {
+		message += ": " + e.Message
+	}
+	return message
+}
+
+// IsNotFound reports whether the requested resource doesn't exist
+func (e *LobError) IsNotFound() bool {
+	return e.StatusCode == 404
+}
+
+// IsRetryable reports whether the same request might succeed if sent again
+func (e *LobError) IsRetryable() bool {
+	return e.StatusCode == 429 || e.StatusCode >= 500
+}
+
+func newLobError(statusCode int, url string, data []byte) *LobError {
+	body := lobErrorBody{}
+	if err := json.Unmarshal(data, &body); err != nil || body.Error.Message == "" {
+		body.Error.Message = string(data)
+	}
+
+	body.Error.StatusCode = statusCode
+	body.Error.URL = url
+	return &body.Error
+}
@@ -0,0 +1,46 @@
+package lob
+
+import (
+	"encoding/json"
+	"net/url"
+)
+
+// https://lob.com/docs#events_object
+type LobEvent struct {
+	Id          string          `json:"id"`
+	Body        json.RawMessage `json:"body"`
+	ReferenceId string          `json:"reference_id"`
+	EventType   LobEventType    `json:"event_type"`
+	DateCreated string          `json:"date_created"`
+	Object      string          `json:"object"`
+}
+
+type LobEventType struct {
+	Id             string `json:"id"`
+	EnabledForTest bool   `json:"enabled_for_test"`
+	Resource       string `json:"resource"`
+	Object         string `json:"object"`
+}
+
+type LobEventList struct {
+	LobList
+	Data []LobEvent `json:"data"`
+}
+
+func (c *Client) GetEvent(id string) (LobEvent, error) {
+	var event LobEvent
+	err := c.get("events/"+url.PathEscape(id), nil, &event)
+	return event, err
+}
+
+func (c *Client) ListEvents(params ListParams) (LobEventList, error) {
+	var list LobEventList
+
+	values, err := params.values()
+	if err != nil {
+		return list, err
+	}
+
+	err = c.get("events", values, &list)
+	return list, err
+}
@@ -0,0 +1,83 @@
+package lob
+
+import (
+	"net/url"
+)
+
+// https://lob.com/docs#letters_object
+type LobLetter struct {
+	Id                   string               `json:"id"`
+	Description          string               `json:"description"`
+	Metadata             map[string]string    `json:"metadata"`
+	To                   LobAddress           `json:"to"`
+	From                 LobAddress           `json:"from"`
+	Color                bool                 `json:"color"`
+	DoubleSided          bool                 `json:"double_sided"`
+	AddressPlacement     string               `json:"address_placement"`
+	MailType             string               `json:"mail_type"`
+	ExtraService         string               `json:"extra_service"`
+	Price                string               `json:"price"`
+	URL                  string               `json:"url"`
+	Carrier              string               `json:"carrier"`
+	TrackingNumber       string               `json:"tracking_number"`
+	TrackingEvents       []LobTrackingEvent   `json:"tracking_events"`
+	Thumbnails           []LobLetterThumbnail `json:"thumbnails"`
+	ExpectedDeliveryDate string               `json:"expected_delivery_date"`
+	SendDate             string               `json:"send_date"`
+	DateCreated          string               `json:"date_created"`
+	DateModified         string               `json:"date_modified"`
+	Deleted              bool                 `json:"deleted"`
+	Object               string               `json:"object"`
+}
+
+type LobTrackingEvent struct {
+	Id          string `json:"id"`
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Location    string `json:"location"`
+	Time        string `json:"time"`
+	DateCreated string `json:"date_created"`
+	Object      string `json:"object"`
+}
+
+type LobLetterList struct {
+	LobList
+	Data []LobLetter `json:"data"`
+}
+
+// CreateLetter sends a letter. Pass our own letter ID as the idempotency key
+// so that retrying after a timeout can't mail the same letter twice.
+// https://lob.com/docs#letters_create
+func (c *Client) CreateLetter(request LobSendLetterRequest, idempotencyKey string) (LobLetter, error) {
+	var letter LobLetter
+	err := c.post("letters", request, idempotencyKey, &letter)
+	return letter, err
+}
+
+// https://lob.com/docs#letters_retrieve
+func (c *Client) GetLetter(id string) (LobLetter, error) {
+	var letter LobLetter
+	err := c.get("letters/"+url.PathEscape(id), nil, &letter)
+	return letter, err
+}
+
+// https://lob.com/docs#letters_list
+func (c *Client) ListLetters(params ListParams) (LobLetterList, error) {
+	var list LobLetterList
+
+	values, err := params.values()
+	if err != nil {
+		return list, err
+	}
+
+	err = c.get("letters", values, &list)
+	return list, err
+}
+
+// CancelLetter cancels a letter that hasn't been sent to the printer yet.
+// https://lob.com/docs#letters_delete
+func (c *Client) CancelLetter(id string) (LobDeleteResponse, error) {
+	var response LobDeleteResponse
+	err := c.delete("letters/"+url.PathEscape(id), &response)
+	return response, err
+}
@@ -1,26 +1,26 @@
 package lob
 
 import (
-	"bytes"
-	"encoding/json"
 	"errors"
-	"fmt"
-	"io"
-	"io/ioutil"
-	"net/http"
-	"net/url"
 	"os"
 	"strings"
+	"time"
 )
 
 // https://lob.com/docs#letters_create
 type LobSendLetterRequest struct {
-	Color          bool              `json:"color"`
-	MailType       string            `json:"mail_type"`
-	From           string            `json:"from"` // Temporarily set to my address
-	To             string            `json:"to"`   // Lob Address object ID
-	File           string            `json:"file"` // HTML string of letter's layout
-	MergeVariables map[string]string `json:"merge_variables"`
+	Description      string            `form:"description,omitempty"`
+	Color            bool              `form:"color"`
+	DoubleSided      bool              `form:"double_sided"`
+	MailType         string            `form:"mail_type"`
+	ExtraService     string            `form:"extra_service,omitempty"`
+	AddressPlacement string            `form:"address_placement,omitempty"`
+	From             LobAddressParam   `form:"from"`
+	To               LobAddressParam   `form:"to"`
+	File             string            `form:"file"` // HTML string of letter's layout
+	SendDate         time.Time         `form:"send_date,omitempty"`
+	MergeVariables   map[string]string `form:"merge_variables,omitempty"`
+	Metadata         map[string]string `form:"metadata,omitempty"`
 }
 
 type LobLetterThumbnail struct {
@@ -29,20 +29,22 @@ type LobLetterThumbnail struct {
 	Large  string `json:"large"`
 }
 
-// https://lob.com/docs#letters_object
-// Selectively chose whoch fields from the letter object we care about
-type LobSendLetterResponse struct {
-	Id                   string               `json:"id"`
-	Thumbnails           []LobLetterThumbnail `json:"thumbnails"`
-	ExpectedDeliveryDate string               `json:"expected_delivery_date"`
-}
-
 const (
-	USPSStandard               = "usps_standard"
+	USPSStandard   = "usps_standard"
+	USPSFirstClass = "usps_first_class"
+
+	// Certified and registered mail can only be sent first class
+	ExtraServiceCertified              = "certified"
+	ExtraServiceCertifiedReturnReceipt = "certified_return_receipt"
+	ExtraServiceRegistered             = "registered"
+
+	AddressPlacementTopFirstPage    = "top_first_page"
+	AddressPlacementInsertBlankPage = "insert_blank_page"
+
 	LobTestEnvironment         = "test"
 	LobLiveEnvironment         = "live"
+	LobEnvironmentEnv          = "LOB_ENVIRONMENT"
 	LobInvalidEnvironmentError = "Lob API environment must either be specified as test or live"
-	LobCreateLetterRoute       = "https://api.lob.com/v1/letters"
 	LobTestAPIKey              = "LOB_TEST_API_KEY"
 	LobLiveAPIKey              = "LOB_LIVE_API_KEY"
 	LobInTouchTestAddressId    = "adr_4cd2f1452346231d"
@@ -51,6 +53,38 @@ const (
 	LobUserAgent               = "intouch/1.0"
 )
 
+// GetDefaultEnvironment returns the Lob environment this deployment sends
+// letters in, from the LOB_ENVIRONMENT environment variable. It defaults to
+// test so that a misconfigured deployment never mails real letters.
+func GetDefaultEnvironment() (string, error) {
+	environment, ok := os.LookupEnv(LobEnvironmentEnv)
+	if !ok || environment == "" {
+		return LobTestEnvironment, nil
+	}
+
+	if !IsValidEnvironment(environment) {
+		return "", errors.New(LobInvalidEnvironmentError)
+	}
+	return environment, nil
+}
+
+func IsValidEnvironment(lobEnvironment string) bool {
+	return lobEnvironment == LobTestEnvironment || lobEnvironment == LobLiveEnvironment
+}
+
+// GetConfiguredEnvironments returns the environments we have an API key for
+func GetConfiguredEnvironments() []string {
+	environments := []string{}
+	for _, environment := range []string{LobTestEnvironment, LobLiveEnvironment} {
+		if _, err := getAPIKey(environment); err == nil {
+			environments = append(environments, environment)
+		}
+	}
+	return environments
+}
+
+// GetInTouchAddress returns InTouch's forwarding address, which letters are
+// sent from when the author hasn't chosen a return address of their own
 func GetInTouchAddress(lobEnvironment string) (string, error) {
 	switch lobEnvironment {
 	case LobTestEnvironment:
@@ -62,22 +96,6 @@ func GetInTouchAddress(lobEnvironment string) (string, error) {
 	}
 }
 
-func GetLetterHTMLTemplate(letterText string) (string, error) {
-	bytes, err := ioutil.ReadFile("./templates/letter.html") // just pass the file name
-	if err != nil {
-		return "", err
-	}
-
-	htmlString := string(bytes) // convert content to a 'string'
-
-	// NOTE: THIS IS A HACK to get around Lob's restriction on merge variables
-	// needing to be <500 characters long
-	parts := strings.Split(htmlString, "{{text}}")
-	htmlString = parts[0] + letterText + parts[1]
-
-	return htmlString, nil
-}
-
 // Converts YYYY-MM-DD to MM-DD-YY
 func LobDateToDBDate(date string) (string, error) {
 	parts := strings.Split(date, "-")
@@ -91,60 +109,6 @@ func LobDateToDBDate(date string) (string, error) {
 	), nil
 }
 
-// Post performs a POST request to the Lob API.
-func Post(endpoint string, params map[string]string, returnValue interface{}, environment string) error {
-	fullURL := LobBaseAPI + endpoint
-	fmt.Println("Lob POST ", fullURL)
-
-	var body io.Reader
-	if params != nil {
-		form := url.Values(make(map[string][]string))
-		for k, v := range params {
-			form.Add(k, v)
-		}
-		bodyString := form.Encode()
-		body = bytes.NewBuffer([]byte(bodyString))
-	}
-
-	req, err := http.NewRequest("POST", fullURL, body)
-	if err != nil {
-		return err
-	}
-
-	if body != nil {
-		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	}
-
-	key, err := getAPIKey(environment)
-	if err != nil {
-		return err
-	}
-
-	req.SetBasicAuth(key, "")
-	// req.Header.Add("Lob-Version", APIVersion)
-	req.Header.Add("Accept", "application/json")
-	req.Header.Add("User-Agent", LobUserAgent)
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	data, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return err
-	}
-
-	if resp.StatusCode != 200 {
-		err = fmt.Errorf("Non-200 status code %d returned from %s with body %s", resp.StatusCode, fullURL, data)
-		json.Unmarshal(data, returnValue) // try, anyway -- in case the caller wants error info
-		return err
-	}
-
-	return json.Unmarshal(data, returnValue)
-}
-
 func getAPIKey(lobEnvironment string) (string, error) {
 	switch lobEnvironment {
 	case LobTestEnvironment:
@@ -0,0 +1,82 @@
+package lob
+
+import (
+	"net/url"
+	"time"
+)
+
+const (
+	PostcardSize4x6  = "4x6"
+	PostcardSize6x9  = "6x9"
+	PostcardSize6x11 = "6x11"
+)
+
+// https://lob.com/docs#postcards_object
+type LobPostcard struct {
+	Id                   string               `json:"id"`
+	Description          string               `json:"description"`
+	Metadata             map[string]string    `json:"metadata"`
+	To                   LobAddress           `json:"to"`
+	From                 LobAddress           `json:"from"`
+	Size                 string               `json:"size"`
+	MailType             string               `json:"mail_type"`
+	URL                  string               `json:"url"`
+	Carrier              string               `json:"carrier"`
+	TrackingEvents       []LobTrackingEvent   `json:"tracking_events"`
+	Thumbnails           []LobLetterThumbnail `json:"thumbnails"`
+	ExpectedDeliveryDate string               `json:"expected_delivery_date"`
+	SendDate             string               `json:"send_date"`
+	DateCreated          string               `json:"date_created"`
+	DateModified         string               `json:"date_modified"`
+	Deleted              bool                 `json:"deleted"`
+	Object               string               `json:"object"`
+}
+
+// https://lob.com/docs#postcards_create
+type LobCreatePostcardRequest struct {
+	Description    string            `form:"description,omitempty"`
+	To             LobAddressParam   `form:"to"`
+	From           LobAddressParam   `form:"from,omitempty"`
+	Front          string            `form:"front"`
+	Back           string            `form:"back"`
+	Size           string            `form:"size,omitempty"`
+	MailType       string            `form:"mail_type,omitempty"`
+	SendDate       time.Time         `form:"send_date,omitempty"`
+	MergeVariables map[string]string `form:"merge_variables,omitempty"`
+	Metadata       map[string]string `form:"metadata,omitempty"`
+}
+
+type LobPostcardList struct {
+	LobList
+	Data []LobPostcard `json:"data"`
+}
+
+func (c *Client) CreatePostcard(request LobCreatePostcardRequest, idempotencyKey string) (LobPostcard, error) {
+	var postcard LobPostcard
+	err := c.post("postcards", request, idempotencyKey, &postcard)
+	return postcard, err
+}
+
+func (c *Client) GetPostcard(id string) (LobPostcard, error) {
+	var postcard LobPostcard
+	err := c.get("postcards/"+url.PathEscape(id), nil, &postcard)
+	return postcard, err
+}
+
+func (c *Client) ListPostcards(params ListParams) (LobPostcardList, error) {
+	var list LobPostcardList
+
+	values, err := params.values()
+	if err != nil {
+		return list, err
+	}
+
+	err = c.get("postcards", values, &list)
+	return list, err
+}
+
+func (c *Client) CancelPostcard(id string) (LobDeleteResponse, error) {
+	var response LobDeleteResponse
+	err := c.delete("postcards/"+url.PathEscape(id), &response)
+	return response, err
+}
@@ -0,0 +1,57 @@
+package lob
+
+const (
+	Deliverable                = "deliverable"
+	DeliverableUnnecessaryUnit = "deliverable_unnecessary_unit"
+	DeliverableIncorrectUnit   = "deliverable_incorrect_unit"
+	DeliverableMissingUnit     = "deliverable_missing_unit"
+	Undeliverable              = "undeliverable"
+)
+
+// https://lob.com/docs#us_verifications_create
+type LobUSVerificationRequest struct {
+	Recipient     string `form:"recipient,omitempty"`
+	PrimaryLine   string `form:"primary_line"`
+	SecondaryLine string `form:"secondary_line,omitempty"`
+	City          string `form:"city,omitempty"`
+	State         string `form:"state,omitempty"`
+	ZipCode       string `form:"zip_code,omitempty"`
+}
+
+// https://lob.com/docs#us_verifications_object
+type LobUSVerification struct {
+	Id             string                  `json:"id"`
+	Recipient      string                  `json:"recipient"`
+	PrimaryLine    string                  `json:"primary_line"`
+	SecondaryLine  string                  `json:"secondary_line"`
+	Urbanization   string                  `json:"urbanization"`
+	LastLine       string                  `json:"last_line"`
+	Deliverability string                  `json:"deliverability"`
+	Components     LobUSVerificationDetail `json:"components"`
+	Object         string                  `json:"object"`
+}
+
+type LobUSVerificationDetail struct {
+	PrimaryNumber   string `json:"primary_number"`
+	StreetPredir    string `json:"street_predirection"`
+	StreetName      string `json:"street_name"`
+	StreetSuffix    string `json:"street_suffix"`
+	SecondaryNumber string `json:"secondary_number"`
+	City            string `json:"city"`
+	State           string `json:"state"`
+	ZipCode         string `json:"zip_code"`
+	ZipCodePlus4    string `json:"zip_code_plus_4"`
+	RecordType      string `json:"record_type"`
+}
+
+// IsDeliverable reports whether USPS will deliver to the address, possibly
+// after correcting the unit number
+func (v LobUSVerification) IsDeliverable() bool {
+	return v.Deliverability != "" && v.Deliverability != Undeliverable
+}
+
+func (c *Client) VerifyUSAddress(request LobUSVerificationRequest) (LobUSVerification, error) {
+	var verification LobUSVerification
+	err := c.post("us_verifications", request, "", &verification)
+	return verification, err
+}
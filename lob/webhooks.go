@@ -0,0 +1,71 @@
+package lob
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"math"
+	"strconv"
+	"time"
+)
+
+const (
+	LobSignatureHeader          = "Lob-Signature"
+	LobSignatureTimestampHeader = "Lob-Signature-Timestamp"
+	LobTestWebhookSecret        = "LOB_TEST_WEBHOOK_SECRET"
+	LobLiveWebhookSecret        = "LOB_LIVE_WEBHOOK_SECRET"
+
+	// Webhooks signed longer ago than this are rejected so that a captured
+	// request can't be replayed later
+	WebhookTolerance = 5 * time.Minute
+)
+
+var (
+	ErrInvalidSignature = errors.New("Lob webhook signature is invalid")
+	ErrStaleSignature   = errors.New("Lob webhook signature timestamp is too old or in the future")
+)
+
+// VerifyWebhookSignature checks that a webhook was sent by Lob. The signature
+// is the hex encoded HMAC-SHA256, keyed with the webhook secret, of the
+// timestamp header, a period and the raw request body.
+// https://lob.com/docs#webhooks_security
+func VerifyWebhookSignature(secret string, timestamp string, signature string, body []byte, now time.Time) error {
+	if secret == "" || timestamp == "" || signature == "" {
+		return ErrInvalidSignature
+	}
+
+	signedAt, err := parseSignatureTimestamp(timestamp)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	if math.Abs(float64(now.Sub(signedAt))) > float64(WebhookTolerance) {
+		return ErrStaleSignature
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	expected := mac.Sum(nil)
+
+	actual, err := hex.DecodeString(signature)
+	if err != nil || !hmac.Equal(expected, actual) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// Lob sends the timestamp in milliseconds, but accept seconds as well
+func parseSignatureTimestamp(timestamp string) (time.Time, error) {
+	value, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if value > 1e12 {
+		return time.Unix(0, value*int64(time.Millisecond)), nil
+	}
+	return time.Unix(value, 0), nil
+}
@@ -0,0 +1,57 @@
+package lob
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func signWebhook(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	secret := "whsec_test"
+	body := []byte(`{"id":"evt_123","event_type":{"id":"letter.mailed"}}`)
+	now := time.Date(2018, 10, 8, 9, 30, 0, 0, time.UTC)
+	millis := strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10)
+	seconds := strconv.FormatInt(now.Unix(), 10)
+	stale := strconv.FormatInt(now.Add(-WebhookTolerance-time.Second).Unix(), 10)
+	future := strconv.FormatInt(now.Add(WebhookTolerance+time.Second).Unix(), 10)
+
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		signature string
+		body      []byte
+		want      error
+	}{
+		{"milliseconds", secret, millis, signWebhook(secret, millis, body), body, nil},
+		{"seconds", secret, seconds, signWebhook(secret, seconds, body), body, nil},
+		{"wrong secret", secret, millis, signWebhook("whsec_other", millis, body), body, ErrInvalidSignature},
+		{"changed body", secret, millis, signWebhook(secret, millis, body), []byte(`{"id":"evt_456"}`), ErrInvalidSignature},
+		{"changed timestamp", secret, seconds, signWebhook(secret, millis, body), body, ErrInvalidSignature},
+		{"not hex", secret, millis, "not-a-signature", body, ErrInvalidSignature},
+		{"no signature", secret, millis, "", body, ErrInvalidSignature},
+		{"no secret", "", millis, signWebhook("", millis, body), body, ErrInvalidSignature},
+		{"bad timestamp", secret, "yesterday", signWebhook(secret, "yesterday", body), body, ErrInvalidSignature},
+		{"too old", secret, stale, signWebhook(secret, stale, body), body, ErrStaleSignature},
+		{"in the future", secret, future, signWebhook(secret, future, body), body, ErrStaleSignature},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := VerifyWebhookSignature(test.secret, test.timestamp, test.signature, test.body, now)
+			if err != test.want {
+				t.Errorf("VerifyWebhookSignature() = %v, want %v", err, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package mailer
+
+import (
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server is a minimal SMTP server meant as a local stand-in for a real mail
+// provider. It accepts every message, keeps it in memory and prints it, which
+// lets us point the Auth0 custom email provider (or our own mail) at a
+// developer machine and read verification and password reset emails without
+// sending anything to a real inbox.
+type Server struct {
+	Addr     string
+	Hostname string
+
+	mutex    sync.Mutex
+	messages []Message
+	listener net.Listener
+}
+
+type Message struct {
+	From       string
+	Recipients []string
+	Data       string
+	Received   time.Time
+}
+
+func NewServer(addr string) *Server {
+	return &Server{
+		Addr:     addr,
+		Hostname: "localhost",
+	}
+}
+
+// Messages returns a copy of every message received so far
+func (s *Server) Messages() []Message {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	messages := make([]Message, len(s.messages))
+	copy(messages, s.messages)
+	return messages
+}
+
+func (s *Server) ListenAndServe() error {
+	listener, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	s.listener = listener
+	s.mutex.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Close()
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+
+	text := textproto.NewConn(conn)
+	reply := func(code int, message string) {
+		text.PrintfLine("%d %s", code, message)
+	}
+
+	reply(220, s.Hostname+" ESMTP intouch stand-in")
+
+	message := Message{}
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+
+		verb, arg := splitCommand(line)
+		switch verb {
+		case "HELO":
+			reply(250, s.Hostname)
+		case "EHLO":
+			text.PrintfLine("250-%s", s.Hostname)
+			reply(250, "8BITMIME")
+		case "MAIL":
+			message = Message{From: trimAddress(arg, "FROM:")}
+			reply(250, "OK")
+		case "RCPT":
+			message.Recipients = append(message.Recipients, trimAddress(arg, "TO:"))
+			reply(250, "OK")
+		case "DATA":
+			if len(message.Recipients) == 0 {
+				reply(503, "Need RCPT before DATA")
+				continue
+			}
+
+			reply(354, "End data with <CR><LF>.<CR><LF>")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+
+			message.Data = string(data)
+			message.Received = time.Now()
+			s.store(message)
+			message = Message{}
+			reply(250, "OK: queued")
+		case "RSET":
+			message = Message{}
+			reply(250, "OK")
+		case "NOOP":
+			reply(250, "OK")
+		case "QUIT":
+			reply(221, "Bye")
+			return
+		default:
+			reply(502, "Command not implemented")
+		}
+	}
+}
+
+func (s *Server) store(message Message) {
+	s.mutex.Lock()
+	s.messages = append(s.messages, message)
+	s.mutex.Unlock()
+
+	fmt.Println("SMTP message from " + message.From + " to " + strings.Join(message.Recipients, ", "))
+	fmt.Println(message.Data)
+}
+
+func splitCommand(line string) (string, string) {
+	parts := strings.SplitN(line, " ", 2)
+	verb := strings.ToUpper(parts[0])
+	if len(parts) == 1 {
+		return verb, ""
+	}
+	return verb, strings.TrimSpace(parts[1])
+}
+
+// Converts "FROM:<jane@example.com> SIZE=100" to "jane@example.com"
+func trimAddress(arg string, prefix string) string {
+	if len(arg) >= len(prefix) && strings.EqualFold(arg[:len(prefix)], prefix) {
+		arg = arg[len(prefix):]
+	}
+
+	arg = strings.TrimSpace(arg)
+	if i := strings.Index(arg, " "); i >= 0 {
+		arg = arg[:i]
+	}
+
+	return strings.Trim(arg, "<>")
+}
@@ -0,0 +1,385 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DeletionPending   = "pending"
+	DeletionCancelled = "cancelled"
+	DeletionCompleted = "completed"
+
+	DefaultDeletionGraceDays = 14
+	DeletionGraceDaysEnv     = "ACCOUNT_DELETION_GRACE_DAYS"
+
+	// Letters that were already mailed are kept so we can account for what was
+	// sent, but everything that identifies the author or says what they wrote
+	// is removed
+	AnonymizedLetterText = "[deleted]"
+	AnonymizedAuthor     = "deleted-user-"
+)
+
+var (
+	ErrNoPendingDeletion = errors.New("No pending account deletion found")
+
+	errMailInFlight = errors.New("The account still has mail being sent to Lob")
+)
+
+type AccountDeletion struct {
+	Id                string `json:"id"`
+	Username          string `json:"username"`
+	Status            string `json:"status"`
+	TimeRequested     string `json:"timeRequested"`
+	TimeScheduled     string `json:"timeScheduled"`
+	TimeCompleted     string `json:"timeCompleted"`
+	LettersDeleted    int    `json:"lettersDeleted"`
+	LettersAnonymized int    `json:"lettersAnonymized"`
+
+	auth0UserId string
+}
+
+func getDeletionGracePeriod() time.Duration {
+	days := DefaultDeletionGraceDays
+	if value, ok := os.LookupEnv(DeletionGraceDaysEnv); ok {
+		if parsed, err := strconv.Atoi(value); err == nil && parsed >= 0 {
+			days = parsed
+		}
+	}
+
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// ScheduleAccountDeletion records that the user wants their account deleted.
+// Nothing is removed until the grace period passes, so the user can change
+// their mind with CancelAccountDeletion. Scheduling twice returns the deletion
+// that is already pending.
+func ScheduleAccountDeletion(username string) (AccountDeletion, error) {
+	deletion, err := GetPendingAccountDeletion(username)
+	if err == nil {
+		return deletion, nil
+	} else if err != ErrNoPendingDeletion {
+		return AccountDeletion{}, err
+	}
+
+	user, err := GetAuth0User(username)
+	if err != nil {
+		return AccountDeletion{}, err
+	}
+
+	db, err := getDBConnection()
+	if err != nil {
+		return AccountDeletion{}, err
+	}
+	defer db.Close()
+
+	now := time.Now().UTC()
+	_, err = db.Exec(
+		"INSERT INTO account_deletions (id, username, auth0UserId, status, timeRequested, timeScheduled) "+
+			"VALUES ($1, $2, $3, $4, $5, $6)",
+		uuid.New().String(),
+		username,
+		user.UserId,
+		DeletionPending,
+		now,
+		now.Add(getDeletionGracePeriod()),
+	)
+	if err != nil {
+		return AccountDeletion{}, err
+	}
+
+	return GetPendingAccountDeletion(username)
+}
+
+func CancelAccountDeletion(username string) error {
+	db, err := getDBConnection()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	result, err := db.Exec(
+		"UPDATE account_deletions SET status = $1 WHERE username = $2 AND status = $3",
+		DeletionCancelled,
+		username,
+		DeletionPending,
+	)
+	if err != nil {
+		return err
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return ErrNoPendingDeletion
+	}
+	return nil
+}
+
+func GetPendingAccountDeletion(username string) (AccountDeletion, error) {
+	db, err := getDBConnection()
+	if err != nil {
+		return AccountDeletion{}, err
+	}
+	defer db.Close()
+
+	deletions, err := getAccountDeletions(
+		db,
+		"WHERE username = $1 AND status = $2",
+		username,
+		DeletionPending,
+	)
+	if err != nil {
+		return AccountDeletion{}, err
+	}
+
+	if len(deletions) == 0 {
+		return AccountDeletion{}, ErrNoPendingDeletion
+	}
+	return deletions[0], nil
+}
+
+// ProcessDueAccountDeletions carries out every pending deletion whose grace
+// period has passed. It is meant to be run periodically by the scheduler;
+// a deletion that fails is left pending and retried on the next run.
+func ProcessDueAccountDeletions() error {
+	db, err := getDBConnection()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	deletions, err := getAccountDeletions(
+		db,
+		"WHERE status = $1 AND timeScheduled <= $2",
+		DeletionPending,
+		time.Now().UTC(),
+	)
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	for _, deletion := range deletions {
+		err := processAccountDeletion(db, deletion)
+		if err != nil {
+			lastErr = err
+			db.Exec("UPDATE account_deletions SET error = $1 WHERE id = $2", err.Error(), deletion.Id)
+		}
+	}
+
+	return lastErr
+}
+
+func processAccountDeletion(db *sql.DB, deletion AccountDeletion) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	// Letters that are still queued or that failed were never mailed, so
+	// there is nothing to keep a record of
+	result, err := tx.Exec(
+		"DELETE FROM letters WHERE author = $1 AND status IN ($2, $3)",
+		deletion.Username,
+		LetterQueued,
+		LetterFailed,
+	)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	deleted, _ := result.RowsAffected()
+
+	// Mail being sent may still have to be sent again by recovery, which
+	// needs what the author wrote, so the deletion waits for the next run.
+	// This is checked after the delete above so that a letter claimed by the
+	// dispatcher in the meantime is seen.
+	var inFlight bool
+	err = tx.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM letters WHERE author = $1 AND status = $2) "+
+			"OR EXISTS (SELECT 1 FROM postcards WHERE author = $1 AND status = $2)",
+		deletion.Username,
+		LetterSending,
+	).Scan(&inFlight)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if inFlight {
+		tx.Rollback()
+		return errMailInFlight
+	}
+
+	result, err = tx.Exec(
+		"UPDATE letters SET author = $1, subject = '', text = $2, returnAddressId = NULL, photoIds = '{}' WHERE author = $3",
+		AnonymizedAuthor+deletion.Id,
+		AnonymizedLetterText,
+		deletion.Username,
+	)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	anonymized, _ := result.RowsAffected()
+
+	// Postcards are handled the same way. They lose their front photo too,
+	// since the photos themselves are deleted below.
+	_, err = tx.Exec("DELETE FROM postcards WHERE author = $1 AND status = $2", deletion.Username, LetterFailed)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec(
+		"UPDATE postcards SET author = $1, message = $2, frontPhotoId = NULL, returnAddressId = NULL WHERE author = $3",
+		AnonymizedAuthor+deletion.Id,
+		AnonymizedLetterText,
+		deletion.Username,
+	)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Previews show what the author wrote, so they go too
+	previewKeys := []string{}
+	rows, err := tx.Query(
+		"DELETE FROM letter_previews WHERE letterId IN (SELECT id FROM letters WHERE author = $1) RETURNING blobKey",
+		AnonymizedAuthor+deletion.Id,
+	)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			tx.Rollback()
+			return err
+		}
+		previewKeys = append(previewKeys, key)
+	}
+	rows.Close()
+
+	_, err = tx.Exec("DELETE FROM return_addresses WHERE username = $1", deletion.Username)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM user_preferences WHERE username = $1", deletion.Username)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM recipient_defaults WHERE username = $1", deletion.Username)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM user_plans WHERE username = $1", deletion.Username)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	photoKeys := []string{}
+	rows, err = tx.Query("DELETE FROM photos WHERE username = $1 RETURNING originalKey, printKey", deletion.Username)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	for rows.Next() {
+		var originalKey, printKey string
+		if err := rows.Scan(&originalKey, &printKey); err != nil {
+			rows.Close()
+			tx.Rollback()
+			return err
+		}
+		photoKeys = append(photoKeys, originalKey, printKey)
+	}
+	rows.Close()
+
+	// Deleting the Auth0 user can't be rolled back, so it happens last before
+	// the commit. If the commit then fails, the next run finds the Auth0 user
+	// already gone and simply finishes the job.
+	err = deleteAuth0User(deletion.auth0UserId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec(
+		"UPDATE account_deletions "+
+			"SET status = $1, timeCompleted = $2, lettersDeleted = $3, lettersAnonymized = $4, error = NULL "+
+			"WHERE id = $5",
+		DeletionCompleted,
+		time.Now().UTC(),
+		deleted,
+		anonymized,
+		deletion.Id,
+	)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	deletePreviewBlobs(previewKeys)
+	deletePhotoBlobs(photoKeys)
+	return nil
+}
+
+func getAccountDeletions(db *sql.DB, where string, args ...interface{}) ([]AccountDeletion, error) {
+	deletions := []AccountDeletion{}
+
+	fields := "id, username, auth0UserId, status, " +
+		"TO_CHAR(timeRequested, 'MM/dd/yy'), " +
+		"TO_CHAR(timeScheduled, 'MM/dd/yy'), " +
+		"COALESCE(TO_CHAR(timeCompleted, 'MM/dd/yy'), ''), " +
+		"lettersDeleted, lettersAnonymized"
+
+	rows, err := db.Query("SELECT "+fields+" FROM account_deletions "+where+" ORDER BY timeRequested", args...)
+	if err != nil {
+		return deletions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		deletion := AccountDeletion{}
+		err := rows.Scan(
+			&deletion.Id,
+			&deletion.Username,
+			&deletion.auth0UserId,
+			&deletion.Status,
+			&deletion.TimeRequested,
+			&deletion.TimeScheduled,
+			&deletion.TimeCompleted,
+			&deletion.LettersDeleted,
+			&deletion.LettersAnonymized,
+		)
+		if err != nil {
+			return deletions, err
+		}
+
+		deletions = append(deletions, deletion)
+	}
+
+	return deletions, rows.Err()
+}
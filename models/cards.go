@@ -0,0 +1,102 @@
+package models
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/johnamadeo/intouchgo/lob"
+	"github.com/johnamadeo/intouchgo/render"
+	"github.com/johnamadeo/intouchgo/utils"
+)
+
+// GetCardsForRecipient returns the cards for an occasion, or every card if
+// occasion is "", that the recipient's facility accepts
+func GetCardsForRecipient(recipientId string, occasion string) ([]render.Card, error) {
+	facility, err := getInmateFacility(recipientId)
+	if err != nil {
+		return []render.Card{}, err
+	}
+
+	accepted := []render.Card{}
+	for _, card := range render.GetCards(occasion) {
+		if len(checkCard(card, facility)) == 0 {
+			accepted = append(accepted, card)
+		}
+	}
+	return accepted, nil
+}
+
+// checkCard applies the facility's rules on stationery to a card
+func checkCard(card render.Card, facility Facility) []utils.FieldError {
+	errs := []utils.FieldError{}
+
+	if !facility.AllowsDoubleSided {
+		errs = append(errs, newFieldError("cardId", "field.cardsNotAccepted", facility.Name))
+	}
+	if card.UsesColor && !facility.AllowsColor {
+		errs = append(errs, newFieldError("cardId", "field.colorCardNotAccepted", facility.Name, card.Name))
+	}
+	if card.Glitter && !facility.AllowsGlitter {
+		errs = append(errs, newFieldError("cardId", "field.glitterCardNotAccepted", facility.Name, card.Name))
+	}
+	if card.Stickers && !facility.AllowsStickers {
+		errs = append(errs, newFieldError("cardId", "field.stickerCardNotAccepted", facility.Name, card.Name))
+	}
+	if card.PaperColor != render.PaperWhite && !facility.AllowsColoredPaper {
+		errs = append(errs, newFieldError("cardId", "field.coloredPaperNotAccepted", facility.Name, card.Name))
+	}
+
+	return errs
+}
+
+// applyCardOptions sets the mail options a card is always printed with and
+// checks the letter against the card and the facility. A card is one sheet
+// printed on both sides, with the address on an inserted blank page so that
+// the fold isn't covered by it, in color only if its artwork is.
+func applyCardOptions(letter Letter, facility Facility) (Letter, []utils.FieldError, error) {
+	errs := []utils.FieldError{}
+
+	card, err := render.GetCard(letter.CardId)
+	if err == render.ErrCardNotFound {
+		return letter, []utils.FieldError{newFieldError("cardId", "field.unknownCard", letter.CardId)}, nil
+	} else if err != nil {
+		return letter, errs, err
+	}
+
+	if letter.AddressPlacement != "" && letter.AddressPlacement != lob.AddressPlacementInsertBlankPage {
+		errs = append(errs, newFieldError("addressPlacement", "field.cardAddressPlacement"))
+	}
+	if letter.DoubleSided != nil && !*letter.DoubleSided {
+		errs = append(errs, newFieldError("doubleSided", "field.cardDoubleSided"))
+	}
+	if len(letter.PhotoIds) > 0 {
+		errs = append(errs, newFieldError("photoIds", "field.cardPhotos"))
+	}
+
+	if strings.TrimSpace(letter.Text) == "" {
+		errs = append(errs, newFieldError("text", "field.cardMessageRequired"))
+	} else if utf8.RuneCountInString(letter.Text) > card.MaxMessage {
+		errs = append(errs, newFieldError("text", "field.cardMessageTooLong", card.Name, card.MaxMessage))
+	}
+
+	errs = append(errs, checkCard(card, facility)...)
+
+	// The facility's rules on color and double-sided letters are checked
+	// against the card above, so the options are set for it only if it passes
+	doubleSided := facility.AllowsDoubleSided
+	letter.DoubleSided = &doubleSided
+	letter.Color = card.UsesColor && facility.AllowsColor
+	letter.AddressPlacement = lob.AddressPlacementInsertBlankPage
+	letter.Split = false
+
+	return letter, errs, nil
+}
+
+// estimateLetterPages estimates how many pages the text of a letter prints
+// on. Cards are always the same length.
+func estimateLetterPages(letter Letter, text string) (int, error) {
+	if letter.CardId != "" {
+		return render.CardPages, nil
+	}
+	return render.EstimatePages(letter.ThemeId, letter.Format, text, *letter.LargePrint)
+}
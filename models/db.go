@@ -4,12 +4,15 @@ import (
 	"database/sql"
 	"os"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 const (
 	Driver            = "postgres"
 	LocalDBConnection = "user=johnamadeodaniswara dbname=intouch_android sslmode=disable"
+
+	// https://www.postgresql.org/docs/current/errcodes-appendix.html
+	UniqueViolation = "23505"
 )
 
 func getDBConnection() (*sql.DB, error) {
@@ -31,3 +34,8 @@ func getDBConnection() (*sql.DB, error) {
 
 	return db, nil
 }
+
+func isUniqueViolation(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == UniqueViolation
+}
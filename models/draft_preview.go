@@ -0,0 +1,119 @@
+package models
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/johnamadeo/intouchgo/lob"
+	"github.com/johnamadeo/intouchgo/render"
+)
+
+const (
+	// Lob fits at most this many sheets in the #10 envelope letters are
+	// mailed in
+	MaxLobLetterSheets = 6
+)
+
+// DraftPreview is a letter laid out the way Lob would print it. The PDF and
+// the PNG of each page are base64 encoded in JSON.
+type DraftPreview struct {
+	PageCount     int      `json:"pageCount"`
+	MaxPages      int      `json:"maxPages"`
+	Sheets        int      `json:"sheets"`
+	EstimatedCost string   `json:"estimatedCost"`
+	PDF           []byte   `json:"pdf"`
+	Pages         [][]byte `json:"pages"`
+	Warnings      []string `json:"warnings"`
+}
+
+// PreviewLetter renders a letter locally with its real author, recipient and
+// subject, without sending anything to Lob or saving the letter
+func PreviewLetter(letter Letter) (DraftPreview, error) {
+	db, err := getDBConnection()
+	if err != nil {
+		return DraftPreview{}, err
+	}
+	recipient, err := getInmateRecipient(db, letter.RecipientId)
+	db.Close()
+	if err != nil {
+		return DraftPreview{}, err
+	}
+
+	letter, err = applyRecipientDefaults(letter)
+	if err != nil {
+		return DraftPreview{}, err
+	}
+
+	letter, err = applyMailOptions(letter, recipient.Facility)
+	if err != nil {
+		return DraftPreview{}, err
+	}
+
+	letterPhotos, err := getLetterPhotos(letter.Author, letter.PhotoIds)
+	if err != nil {
+		return DraftPreview{}, err
+	}
+
+	err = checkLetterPhotos(letterPhotos, recipient.Facility)
+	if err != nil {
+		return DraftPreview{}, err
+	}
+
+	authorName, err := GetUserRealName(letter.Author)
+	if err != nil {
+		return DraftPreview{}, err
+	}
+
+	letter.Recipient = recipient.Inmate.FirstName + " " + recipient.Inmate.LastName
+	if letter.TimeSent == "" {
+		letter.TimeSent = time.Now().Format("01/02/06")
+	}
+
+	document, err := getLetterHTML(letter, authorName)
+	if err != nil {
+		return DraftPreview{}, err
+	}
+
+	result, err := render.RenderLetter(document)
+	if err != nil {
+		return DraftPreview{}, err
+	}
+
+	maxPages, err := getLetterPageLimit(letter, recipient.Facility)
+	if err != nil {
+		return DraftPreview{}, err
+	}
+
+	preview := DraftPreview{
+		PageCount:     result.PageCount,
+		MaxPages:      maxPages,
+		Sheets:        getLetterSheets(letter, result.PageCount),
+		EstimatedCost: estimateLetterCost(letter, result.PageCount),
+		PDF:           result.PDF,
+		Pages:         result.Pages,
+		Warnings:      []string{},
+	}
+
+	if preview.PageCount > preview.MaxPages {
+		preview.Warnings = append(preview.Warnings,
+			"The letter is "+strconv.Itoa(preview.PageCount)+" pages long but at most "+
+				strconv.Itoa(preview.MaxPages)+" pages can be mailed to "+recipient.Facility.Name+
+				". Shorten it or have it split into several letters.")
+	}
+
+	return preview, nil
+}
+
+// getMaxLetterPages returns how many pages of the letter fit in the
+// envelope. A separate address page takes up one side of the first sheet.
+func getMaxLetterPages(letter Letter) int {
+	pages := MaxLobLetterSheets
+	if letter.DoubleSided != nil && *letter.DoubleSided {
+		pages *= 2
+	}
+
+	if letter.AddressPlacement == lob.AddressPlacementInsertBlankPage {
+		pages--
+	}
+	return pages
+}
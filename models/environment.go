@@ -0,0 +1,157 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/johnamadeo/intouchgo/lob"
+)
+
+var (
+	ErrMixedEnvironments = errors.New("Lob test and live addresses can't be used in the same letter")
+)
+
+type SandboxUser struct {
+	Username  string `json:"username"`
+	Note      string `json:"note"`
+	TimeAdded string `json:"timeAdded"`
+}
+
+// GetLobEnvironmentForUser returns the Lob environment the user's letters are
+// sent in: the deployment's environment, unless the user is a QA account
+// listed in sandbox_users, whose letters are always sent in test.
+func GetLobEnvironmentForUser(username string) (string, error) {
+	environment, err := lob.GetDefaultEnvironment()
+	if err != nil {
+		return "", err
+	}
+
+	if environment == lob.LobTestEnvironment {
+		return environment, nil
+	}
+
+	db, err := getDBConnection()
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	var found string
+	err = db.QueryRow("SELECT username FROM sandbox_users WHERE username = $1", username).Scan(&found)
+	if err == sql.ErrNoRows {
+		return environment, nil
+	} else if err != nil {
+		return "", err
+	}
+
+	return lob.LobTestEnvironment, nil
+}
+
+func GetSandboxUsers() ([]SandboxUser, error) {
+	users := []SandboxUser{}
+
+	db, err := getDBConnection()
+	if err != nil {
+		return users, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(
+		"SELECT username, COALESCE(note, ''), TO_CHAR(timeAdded, 'MM/dd/yy') FROM sandbox_users ORDER BY username",
+	)
+	if err != nil {
+		return users, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		user := SandboxUser{}
+		if err := rows.Scan(&user.Username, &user.Note, &user.TimeAdded); err != nil {
+			return users, err
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+func AddSandboxUser(username string, note string) error {
+	db, err := getDBConnection()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec(
+		"INSERT INTO sandbox_users (username, note) VALUES ($1, $2) "+
+			"ON CONFLICT (username) DO UPDATE SET note = EXCLUDED.note",
+		username,
+		note,
+	)
+	return err
+}
+
+func RemoveSandboxUser(username string) error {
+	db, err := getDBConnection()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec("DELETE FROM sandbox_users WHERE username = $1", username)
+	return err
+}
+
+// checkAddressEnvironment makes sure an address id we're about to send to Lob
+// isn't one we know to belong to the other environment. Lob would reject it
+// anyway, but only after the letter has been queued.
+func checkAddressEnvironment(db *sql.DB, addressId string, lobEnvironment string) error {
+	otherColumn := ""
+	switch lobEnvironment {
+	case lob.LobTestEnvironment:
+		if addressId == lob.LobInTouchLiveAddressId {
+			return ErrMixedEnvironments
+		}
+		otherColumn = "lobLiveAddressId"
+	case lob.LobLiveEnvironment:
+		if addressId == lob.LobInTouchTestAddressId {
+			return ErrMixedEnvironments
+		}
+		otherColumn = "lobTestAddressId"
+	default:
+		return errors.New(lob.LobInvalidEnvironmentError)
+	}
+
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM facilities WHERE "+otherColumn+" = $1", addressId).Scan(&count)
+	if err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return ErrMixedEnvironments
+	}
+
+	err = db.QueryRow("SELECT COUNT(*) FROM return_addresses WHERE "+otherColumn+" = $1", addressId).Scan(&count)
+	if err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return ErrMixedEnvironments
+	}
+
+	err = db.QueryRow(
+		"SELECT COUNT(*) FROM inmate_addresses WHERE lobAddressId = $1 AND lobEnvironment <> $2",
+		addressId,
+		lobEnvironment,
+	).Scan(&count)
+	if err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return ErrMixedEnvironments
+	}
+	return nil
+}
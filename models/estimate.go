@@ -0,0 +1,132 @@
+package models
+
+import (
+	"github.com/johnamadeo/intouchgo/render"
+	"github.com/johnamadeo/intouchgo/utils"
+)
+
+const (
+	// Long letters are split into at most this many letters
+	MaxLetterParts = 5
+)
+
+// LetterEstimate predicts the length and price of a letter before it's
+// sent. Parts is how many letters it would be mailed as if it were split;
+// the cost is for all of them. Cards are never split.
+type LetterEstimate struct {
+	Pages         int    `json:"pages"`
+	MaxPages      int    `json:"maxPages"`
+	Parts         int    `json:"parts"`
+	Sheets        int    `json:"sheets"`
+	EstimatedCost string `json:"estimatedCost"`
+}
+
+// EstimateLetter estimates a letter with the options it would be sent with,
+// without rendering it
+func EstimateLetter(letter Letter) (LetterEstimate, error) {
+	facility, err := getInmateFacility(letter.RecipientId)
+	if err != nil {
+		return LetterEstimate{}, err
+	}
+
+	letter, err = applyRecipientDefaults(letter)
+	if err != nil {
+		return LetterEstimate{}, err
+	}
+
+	letter, err = applyMailOptions(letter, facility)
+	if err != nil {
+		return LetterEstimate{}, err
+	}
+
+	letterPhotos, err := getLetterPhotos(letter.Author, letter.PhotoIds)
+	if err != nil {
+		return LetterEstimate{}, err
+	}
+
+	err = checkLetterPhotos(letterPhotos, facility)
+	if err != nil {
+		return LetterEstimate{}, err
+	}
+
+	maxPages, err := getLetterPageLimit(letter, facility)
+	if err != nil {
+		return LetterEstimate{}, err
+	}
+
+	pages, err := estimateLetterPages(letter, letter.Text)
+	if err != nil {
+		return LetterEstimate{}, err
+	}
+	pages += len(letterPhotos)
+
+	estimate := LetterEstimate{Pages: pages, MaxPages: maxPages, Parts: 1}
+	texts := []string{letter.Text}
+	if pages > maxPages && letter.CardId == "" {
+		texts, err = splitLetterText(letter, len(letterPhotos), maxPages)
+		if err != nil {
+			return LetterEstimate{}, err
+		}
+		estimate.Parts = len(texts)
+	}
+
+	cents := 0
+	for i, text := range texts {
+		partPages, err := estimateLetterPages(letter, text)
+		if err != nil {
+			return LetterEstimate{}, err
+		}
+		if i == len(texts)-1 {
+			partPages += len(letterPhotos)
+		}
+
+		estimate.Sheets += getLetterSheets(letter, partPages)
+		cents += estimateLetterCents(letter, partPages)
+	}
+	estimate.EstimatedCost = formatCents(cents)
+
+	return estimate, nil
+}
+
+// splitLetterText splits the text of a letter that's too long into parts of
+// at most maxPages, leaving room in the last part for the photos
+func splitLetterText(letter Letter, photoPages int, maxPages int) ([]string, error) {
+	texts, err := render.SplitText(letter.ThemeId, letter.Format, letter.Text, *letter.LargePrint, maxPages)
+	if err != nil || photoPages == 0 {
+		return texts, err
+	}
+
+	lastPages, err := render.EstimatePages(letter.ThemeId, letter.Format, texts[len(texts)-1], *letter.LargePrint)
+	if err != nil {
+		return []string{}, err
+	}
+
+	if lastPages+photoPages <= maxPages {
+		return texts, nil
+	}
+	return render.SplitText(letter.ThemeId, letter.Format, letter.Text, *letter.LargePrint, maxPages-photoPages)
+}
+
+// getLetterPageLimit returns the most pages the letter can have: what fits
+// in the envelope, the facility allows and the author's plan includes
+func getLetterPageLimit(letter Letter, facility Facility) (int, error) {
+	plan, err := GetUserPlan(letter.Author)
+	if err != nil {
+		return 0, err
+	}
+
+	limit := getMaxLetterPages(letter)
+	if facility.MaxLetterPages > 0 && facility.MaxLetterPages < limit {
+		limit = facility.MaxLetterPages
+	}
+	if planPages := getPlanMaxPages(plan); planPages < limit {
+		limit = planPages
+	}
+	return limit, nil
+}
+
+func newLetterTooLongError(facility Facility, pages int, maxPages int) error {
+	return &MailOptionsError{Errors: []utils.FieldError{
+		newFieldError("text", "field.letterTooLong", pages, maxPages, facility.Name),
+	}}
+}
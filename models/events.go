@@ -0,0 +1,233 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/johnamadeo/intouchgo/lob"
+	"github.com/lib/pq"
+)
+
+const (
+	LetterRendered             = "rendered"
+	LetterMailed               = "mailed"
+	LetterInTransit            = "in_transit"
+	LetterInLocalArea          = "in_local_area"
+	LetterProcessedForDelivery = "processed_for_delivery"
+	LetterReRouted             = "re-routed"
+	LetterDelivered            = "delivered"
+	LetterReturnedToSender     = "returned_to_sender"
+	LetterCancelled            = "cancelled"
+)
+
+// Maps Lob event types to the status they put the letter in.
+// https://lob.com/docs#events_types
+var lobEventStatuses = map[string]string{
+	"letter.rendered_pdf":            LetterRendered,
+	"letter.rendered_thumbnails":     LetterRendered,
+	"letter.deleted":                 LetterCancelled,
+	"letter.mailed":                  LetterMailed,
+	"letter.in_transit":              LetterInTransit,
+	"letter.in_local_area":           LetterInLocalArea,
+	"letter.processed_for_delivery":  LetterProcessedForDelivery,
+	"letter.re-routed":               LetterReRouted,
+	"letter.returned_to_sender":      LetterReturnedToSender,
+	"letter.certified.delivered":     LetterDelivered,
+	"letter.certified.mailed":        LetterMailed,
+	"letter.certified.in_transit":    LetterInTransit,
+	"letter.certified.in_local_area": LetterInLocalArea,
+}
+
+// Webhooks can arrive out of order, so a letter's status only ever moves
+// forward through this ordering
+var letterStatusRanks = map[string]int{
+	LetterQueued:               0,
+	LetterSending:              1,
+	LetterFailed:               2,
+	LetterSent:                 2,
+	LetterRendered:             3,
+	LetterMailed:               4,
+	LetterInTransit:            5,
+	LetterInLocalArea:          6,
+	LetterReRouted:             6,
+	LetterProcessedForDelivery: 7,
+	LetterDelivered:            8,
+	LetterReturnedToSender:     9,
+	LetterCancelled:            9,
+}
+
+type LetterEvent struct {
+	Id           string `json:"id"`
+	EventType    string `json:"eventType"`
+	Status       string `json:"status"`
+	Location     string `json:"location"`
+	TimeOccurred string `json:"timeOccurred"`
+}
+
+// RecordLobEvent saves a letter event from a Lob webhook and moves the letter
+// to the status the event implies. Events for resources other than letters,
+// for letters we don't know about and events we have already recorded are
+// ignored.
+func RecordLobEvent(event lob.LobEvent) error {
+	if strings.HasPrefix(event.EventType.Id, "postcard.") {
+		return recordPostcardEvent(event)
+	}
+
+	status, ok := lobEventStatuses[event.EventType.Id]
+	if !ok {
+		return nil
+	}
+
+	var lobLetter lob.LobLetter
+	err := json.Unmarshal(event.Body, &lobLetter)
+	if err != nil {
+		return err
+	}
+
+	db, err := getDBConnection()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	letterId, err := findLetterIdForLobLetter(db, lobLetter)
+	if err == ErrLetterNotFound {
+		fmt.Println("Ignoring " + event.EventType.Id + " event for unknown Lob letter " + lobLetter.Id)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	occurred, err := time.Parse(time.RFC3339, event.DateCreated)
+	if err != nil {
+		occurred = time.Now().UTC()
+	}
+
+	location := ""
+	if count := len(lobLetter.TrackingEvents); count > 0 {
+		location = lobLetter.TrackingEvents[count-1].Location
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(
+		"INSERT INTO letter_events (id, letterId, eventType, status, location, timeOccurred) "+
+			"VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (id) DO NOTHING",
+		event.Id,
+		letterId,
+		event.EventType.Id,
+		status,
+		location,
+		occurred,
+	)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if inserted, _ := result.RowsAffected(); inserted == 0 {
+		tx.Rollback()
+		return nil
+	}
+
+	var currentStatus string
+	err = tx.QueryRow("SELECT status FROM letters WHERE id = $1 FOR UPDATE", letterId).Scan(&currentStatus)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if letterStatusRanks[status] > letterStatusRanks[currentStatus] {
+		_, err = tx.Exec(
+			"UPDATE letters SET status = $1, lobLetterId = COALESCE(lobLetterId, $2) WHERE id = $3",
+			status,
+			lobLetter.Id,
+			letterId,
+		)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	// The event's letter carries fresh signed URLs of the rendered assets
+	if status == LetterRendered {
+		storeLetterPreviewsInBackground(letterId, lobLetter)
+	}
+	return nil
+}
+
+// Letters are tagged with our letter id when they're created, but fall back
+// to the Lob id for letters created before we started doing that
+func findLetterIdForLobLetter(db *sql.DB, lobLetter lob.LobLetter) (string, error) {
+	var letterId string
+	err := db.QueryRow(
+		"SELECT id FROM letters WHERE id = $1 OR lobLetterId = $2 LIMIT 1",
+		lobLetter.Metadata[LetterIdMetadataKey],
+		lobLetter.Id,
+	).Scan(&letterId)
+
+	if err == sql.ErrNoRows {
+		return "", ErrLetterNotFound
+	}
+	return letterId, err
+}
+
+// getLetterTimelines returns the events of each of the given letters, oldest
+// first, keyed by letter id
+func getLetterTimelines(db *sql.DB, letterIds []string) (map[string][]LetterEvent, error) {
+	timelines := make(map[string][]LetterEvent)
+	if len(letterIds) == 0 {
+		return timelines, nil
+	}
+
+	fields := []string{
+		"id",
+		"letterId",
+		"eventType",
+		"status",
+		"COALESCE(location, '')",
+		"TO_CHAR(timeOccurred, 'YYYY-MM-DD\"T\"HH24:MI:SS\"Z\"')",
+	}
+
+	rows, err := db.Query(
+		"SELECT "+strings.Join(fields, ", ")+" FROM letter_events "+
+			"WHERE letterId = ANY($1) ORDER BY timeOccurred",
+		pq.Array(letterIds),
+	)
+	if err != nil {
+		return timelines, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var letterId string
+		event := LetterEvent{}
+		err := rows.Scan(
+			&event.Id,
+			&letterId,
+			&event.EventType,
+			&event.Status,
+			&event.Location,
+			&event.TimeOccurred,
+		)
+		if err != nil {
+			return timelines, err
+		}
+
+		timelines[letterId] = append(timelines[letterId], event)
+	}
+
+	return timelines, rows.Err()
+}
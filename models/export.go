@@ -0,0 +1,195 @@
+package models
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/johnamadeo/intouchgo/render"
+)
+
+type ExportProfile struct {
+	Username      string `json:"username"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"emailVerified"`
+	Name          string `json:"name"`
+	Locale        string `json:"locale"`
+	Plan          string `json:"plan"`
+	TimeExported  string `json:"timeExported"`
+}
+
+type ExportRecipient struct {
+	Id           string `json:"id"`
+	State        string `json:"state"`
+	InmateNumber string `json:"inmateNumber"`
+	FirstName    string `json:"firstName"`
+	LastName     string `json:"lastName"`
+	Facility     string `json:"facility"`
+	LettersSent  int    `json:"lettersSent"`
+}
+
+// ExportUserData writes a ZIP archive with everything we store about a user:
+// their profile, their letters as JSON and as the HTML that was mailed, and
+// the postcards they sent, the people they write to, the return addresses
+// they registered and the photos they uploaded.
+func ExportUserData(username string, w io.Writer) error {
+	user, err := GetAuth0User(username)
+	if err != nil {
+		return err
+	}
+
+	letters, err := GetLettersFromDB(username)
+	if err != nil {
+		return err
+	}
+
+	recipients, err := getRecipientsForAuthor(username)
+	if err != nil {
+		return err
+	}
+
+	returnAddresses, err := GetReturnAddresses(username)
+	if err != nil {
+		return err
+	}
+
+	preferences, err := GetPreferences(username)
+	if err != nil {
+		return err
+	}
+
+	plan, err := GetUserPlan(username)
+	if err != nil {
+		return err
+	}
+
+	recipientDefaults, err := GetRecipientDefaults(username)
+	if err != nil {
+		return err
+	}
+
+	postcards, err := GetPostcards(username)
+	if err != nil {
+		return err
+	}
+
+	uploadedPhotos, err := GetPhotos(username)
+	if err != nil {
+		return err
+	}
+
+	archive := zip.NewWriter(w)
+
+	err = writeJSONToZip(archive, "profile.json", ExportProfile{
+		Username:      user.Username,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		Name:          user.UserMetadata.Name,
+		Locale:        preferences.Locale,
+		Plan:          plan,
+		TimeExported:  time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return err
+	}
+
+	err = writeJSONToZip(archive, "letters.json", letters)
+	if err != nil {
+		return err
+	}
+
+	for _, letter := range letters {
+		htmlString, err := getLetterHTML(letter, user.UserMetadata.Name)
+		if err != nil {
+			return err
+		}
+
+		file, err := archive.Create("letters/" + letter.Id + ".html")
+		if err != nil {
+			return err
+		}
+
+		_, err = io.WriteString(file, htmlString)
+		if err != nil {
+			return err
+		}
+
+		// For reading the letter without a browser
+		file, err = archive.Create("letters/" + letter.Id + ".txt")
+		if err != nil {
+			return err
+		}
+
+		_, err = io.WriteString(file, render.PlainText(letter.Format, letter.Text))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = writeJSONToZip(archive, "postcards.json", postcards)
+	if err != nil {
+		return err
+	}
+
+	err = writeJSONToZip(archive, "recipients.json", recipients)
+	if err != nil {
+		return err
+	}
+
+	err = writeJSONToZip(archive, "return-addresses.json", returnAddresses)
+	if err != nil {
+		return err
+	}
+
+	err = writeJSONToZip(archive, "recipient-defaults.json", recipientDefaults)
+	if err != nil {
+		return err
+	}
+
+	err = writeJSONToZip(archive, "photos.json", uploadedPhotos)
+	if err != nil {
+		return err
+	}
+
+	if len(uploadedPhotos) > 0 {
+		store, err := getBlobStore()
+		if err != nil {
+			return err
+		}
+
+		for _, photo := range uploadedPhotos {
+			original, err := store.Get(photo.originalKey)
+			if err != nil {
+				return err
+			}
+
+			file, err := archive.Create("photos/" + photo.Id + ".jpg")
+			if err != nil {
+				return err
+			}
+
+			_, err = file.Write(original.Data)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return archive.Close()
+}
+
+func writeJSONToZip(archive *zip.Writer, name string, v interface{}) error {
+	file, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+
+	bytes, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(bytes)
+	return err
+}
@@ -0,0 +1,299 @@
+package models
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/johnamadeo/intouchgo/lob"
+)
+
+const (
+	// Lob rejects address names longer than this
+	MaxLobNameLength = 40
+)
+
+var (
+	nonAlphanumeric = regexp.MustCompile(`[^A-Z0-9 ]+`)
+	extraSpaces     = regexp.MustCompile(`\s+`)
+
+	// USPS standard abbreviations, so that "1106 North Avenue" matches the
+	// verified "1106 N AVE"
+	uspsAbbreviations = strings.NewReplacer(
+		" NORTH ", " N ",
+		" SOUTH ", " S ",
+		" EAST ", " E ",
+		" WEST ", " W ",
+		" AVENUE ", " AVE ",
+		" STREET ", " ST ",
+		" ROAD ", " RD ",
+		" TURNPIKE ", " TPKE ",
+		" BOULEVARD ", " BLVD ",
+		" DRIVE ", " DR ",
+		" LANE ", " LN ",
+		" HIGHWAY ", " HWY ",
+		" POB ", " PO BOX ",
+	)
+)
+
+type FacilityProvisionResult struct {
+	Facility           string            `json:"facility"`
+	Deliverability     string            `json:"deliverability"`
+	VerifiedAddress    string            `json:"verifiedAddress"`
+	AddressNeedsReview bool              `json:"addressNeedsReview"`
+	Differences        []string          `json:"differences"`
+	AddressIds         map[string]string `json:"addressIds"`
+	Created            []string          `json:"created"`
+	Error              string            `json:"error,omitempty"`
+}
+
+// ProvisionFacilityAddresses runs every facility's address through Lob's US
+// verification API and makes sure each facility has a Lob address object in
+// every environment we have an API key for. Facilities whose verified address
+// doesn't match our record are flagged for review and get no new Lob
+// addresses until someone has fixed the record; with dryRun set nothing is
+// created or saved.
+func ProvisionFacilityAddresses(dryRun bool) ([]FacilityProvisionResult, error) {
+	results := []FacilityProvisionResult{}
+
+	facilities, err := GetFacilitiesFromDB()
+	if err != nil {
+		return results, err
+	}
+
+	verifier, err := getVerificationClient()
+	if err != nil {
+		return results, err
+	}
+
+	clients := make(map[string]*lob.Client)
+	for _, environment := range lob.GetConfiguredEnvironments() {
+		client, err := lob.NewClient(environment)
+		if err != nil {
+			return results, err
+		}
+		clients[environment] = client
+	}
+
+	for _, facility := range facilities {
+		result, err := provisionFacility(facility, verifier, clients, dryRun)
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// Lob only verifies addresses for real with a live key; test keys return
+// canned responses. Fall back to test so that the command can still be tried
+// out on a development machine.
+func getVerificationClient() (*lob.Client, error) {
+	client, err := lob.NewClient(lob.LobLiveEnvironment)
+	if err == nil {
+		return client, nil
+	}
+
+	return lob.NewClient(lob.LobTestEnvironment)
+}
+
+func provisionFacility(
+	facility Facility,
+	verifier *lob.Client,
+	clients map[string]*lob.Client,
+	dryRun bool,
+) (FacilityProvisionResult, error) {
+	result := FacilityProvisionResult{
+		Facility:    facility.Name,
+		Differences: []string{},
+		AddressIds: map[string]string{
+			lob.LobTestEnvironment: facility.LobTestAddressId,
+			lob.LobLiveEnvironment: facility.LobLiveAddressId,
+		},
+		Created: []string{},
+	}
+
+	primaryLine, secondaryLine := splitAddressLine(facility.AddressLine)
+	verification, err := verifier.VerifyUSAddress(lob.LobUSVerificationRequest{
+		PrimaryLine:   primaryLine,
+		SecondaryLine: secondaryLine,
+		City:          facility.City,
+		State:         facility.State,
+		ZipCode:       facility.Zip,
+	})
+	if err != nil {
+		return result, err
+	}
+
+	result.Deliverability = verification.Deliverability
+	result.VerifiedAddress = strings.TrimSpace(
+		verification.PrimaryLine + " " + verification.SecondaryLine + ", " + verification.LastLine,
+	)
+	result.Differences = compareVerifiedAddress(facility, verification)
+	result.AddressNeedsReview = !verification.IsDeliverable() || len(result.Differences) > 0
+
+	if !result.AddressNeedsReview {
+		for environment, client := range clients {
+			addressId, created, err := ensureFacilityLobAddress(facility, environment, client, dryRun)
+			if err != nil {
+				return result, err
+			}
+
+			result.AddressIds[environment] = addressId
+			if created {
+				result.Created = append(result.Created, environment)
+			}
+		}
+	}
+
+	if dryRun {
+		return result, nil
+	}
+
+	return result, saveFacilityProvisioning(facility, verification, result)
+}
+
+// Our records put PO boxes after the street, e.g. "245 Whalley Avenue, POB 8000"
+func splitAddressLine(addressLine string) (string, string) {
+	parts := strings.SplitN(addressLine, ",", 2)
+	if len(parts) == 1 {
+		return strings.TrimSpace(parts[0]), ""
+	}
+	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+}
+
+func compareVerifiedAddress(facility Facility, verification lob.LobUSVerification) []string {
+	differences := []string{}
+
+	primaryLine, secondaryLine := splitAddressLine(facility.AddressLine)
+	ours := normalizeAddressPart(primaryLine + " " + secondaryLine)
+	theirs := normalizeAddressPart(verification.PrimaryLine + " " + verification.SecondaryLine)
+	if ours != theirs {
+		differences = append(differences, "address line: "+facility.AddressLine+" != "+
+			strings.TrimSpace(verification.PrimaryLine+" "+verification.SecondaryLine))
+	}
+
+	if normalizeAddressPart(facility.City) != normalizeAddressPart(verification.Components.City) {
+		differences = append(differences, "city: "+facility.City+" != "+verification.Components.City)
+	}
+
+	if normalizeAddressPart(facility.State) != normalizeAddressPart(verification.Components.State) {
+		differences = append(differences, "state: "+facility.State+" != "+verification.Components.State)
+	}
+
+	if facility.Zip != verification.Components.ZipCode {
+		differences = append(differences, "zip: "+facility.Zip+" != "+verification.Components.ZipCode)
+	}
+
+	return differences
+}
+
+func normalizeAddressPart(part string) string {
+	part = nonAlphanumeric.ReplaceAllString(strings.ToUpper(part), " ")
+	part = " " + extraSpaces.ReplaceAllString(part, " ") + " "
+	// Run twice since adjacent words share the space between them
+	part = uspsAbbreviations.Replace(uspsAbbreviations.Replace(part))
+	return strings.TrimSpace(part)
+}
+
+// ensureFacilityLobAddress returns the facility's Lob address id in the
+// given environment, creating a new Lob address if there is none or if the
+// existing one no longer matches our record. Lob addresses can't be edited,
+// and old ones are kept because sent letters still refer to them.
+func ensureFacilityLobAddress(
+	facility Facility,
+	environment string,
+	client *lob.Client,
+	dryRun bool,
+) (string, bool, error) {
+	request := getFacilityAddressRequest(facility)
+
+	existingId := facility.LobTestAddressId
+	if environment == lob.LobLiveEnvironment {
+		existingId = facility.LobLiveAddressId
+	}
+
+	if existingId != "" {
+		existing, err := client.GetAddress(existingId)
+		if lobErr, ok := err.(*lob.LobError); ok && lobErr.IsNotFound() {
+			// Fall through and create a new address
+		} else if err != nil {
+			return existingId, false, err
+		} else if lobAddressMatches(existing, request) {
+			return existingId, false, nil
+		}
+	}
+
+	if dryRun {
+		return existingId, true, nil
+	}
+
+	address, err := client.CreateAddress(request)
+	if err != nil {
+		return existingId, false, err
+	}
+
+	return address.Id, true, nil
+}
+
+func getFacilityAddressRequest(facility Facility) lob.LobCreateAddressRequest {
+	primaryLine, secondaryLine := splitAddressLine(facility.AddressLine)
+
+	name := facility.Name
+	if len(name) > MaxLobNameLength {
+		name = facility.ShortName
+	}
+
+	return lob.LobCreateAddressRequest{
+		Description:    "Facility: " + facility.Name,
+		Name:           name,
+		AddressLine1:   primaryLine,
+		AddressLine2:   secondaryLine,
+		AddressCity:    facility.City,
+		AddressState:   facility.State,
+		AddressZip:     facility.Zip,
+		AddressCountry: "US",
+		Metadata: map[string]string{
+			AppMetadataKey: AppMetadataValue,
+			"facility":     facility.ShortName,
+		},
+	}
+}
+
+func lobAddressMatches(address lob.LobAddress, request lob.LobCreateAddressRequest) bool {
+	return strings.EqualFold(address.Name, request.Name) &&
+		normalizeAddressPart(address.AddressLine1+" "+address.AddressLine2) ==
+			normalizeAddressPart(request.AddressLine1+" "+request.AddressLine2) &&
+		normalizeAddressPart(address.AddressCity) == normalizeAddressPart(request.AddressCity) &&
+		strings.EqualFold(address.AddressState, request.AddressState) &&
+		strings.HasPrefix(address.AddressZip, request.AddressZip)
+}
+
+func saveFacilityProvisioning(facility Facility, verification lob.LobUSVerification, result FacilityProvisionResult) error {
+	db, err := getDBConnection()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec(
+		"UPDATE facilities SET "+
+			"lobTestAddressId = $1, lobLiveAddressId = $2, "+
+			"verifiedAddressLine = $3, verifiedCity = $4, verifiedState = $5, verifiedZip = $6, "+
+			"deliverability = $7, addressNeedsReview = $8, timeAddressVerified = $9 "+
+			"WHERE name = $10",
+		result.AddressIds[lob.LobTestEnvironment],
+		result.AddressIds[lob.LobLiveEnvironment],
+		strings.TrimSpace(verification.PrimaryLine+" "+verification.SecondaryLine),
+		verification.Components.City,
+		verification.Components.State,
+		verification.Components.ZipCode,
+		verification.Deliverability,
+		result.AddressNeedsReview,
+		time.Now().UTC(),
+		facility.Name,
+	)
+
+	return err
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	HistoryLetter   = "letter"
+	HistoryPostcard = "postcard"
+)
+
+// HistoryItem is one piece of mail the user sent. Exactly one of Letter and
+// Postcard is set, depending on Type.
+type HistoryItem struct {
+	Type     string    `json:"type"`
+	Letter   *Letter   `json:"letter,omitempty"`
+	Postcard *Postcard `json:"postcard,omitempty"`
+
+	timeSent time.Time
+}
+
+// GetHistory returns the letters and postcards the user sent, newest first
+func GetHistory(username string) ([]HistoryItem, error) {
+	letters, err := GetLettersFromDB(username)
+	if err != nil {
+		return []HistoryItem{}, err
+	}
+
+	postcards, err := GetPostcards(username)
+	if err != nil {
+		return []HistoryItem{}, err
+	}
+
+	history := []HistoryItem{}
+	for i := range letters {
+		history = append(history, HistoryItem{
+			Type:     HistoryLetter,
+			Letter:   &letters[i],
+			timeSent: letters[i].timeQueued,
+		})
+	}
+
+	for i := range postcards {
+		history = append(history, HistoryItem{
+			Type:     HistoryPostcard,
+			Postcard: &postcards[i],
+			timeSent: postcards[i].timeSent,
+		})
+	}
+
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].timeSent.After(history[j].timeSent)
+	})
+
+	return history, nil
+}
@@ -0,0 +1,309 @@
+package models
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"text/template"
+	"unicode/utf8"
+
+	"github.com/johnamadeo/intouchgo/lob"
+	"github.com/lib/pq"
+)
+
+const (
+	DefaultRecipientFormat = "{{.FirstName}} {{.LastName}} #{{.InmateNumber}}"
+
+	// Used when a facility's format produces a name too long for Lob
+	ShortRecipientFormat = "{{.FirstInitial}} {{.LastName}} #{{.InmateNumber}}"
+)
+
+var ErrInvalidRecipientFormat = errors.New("The facility's recipient format is invalid.")
+var ErrRecipientNameTooLong = errors.New("The recipient's name and inmate number don't fit on the envelope.")
+
+// RecipientNameFields are the values a facility's recipient format can use
+type RecipientNameFields struct {
+	FirstName    string
+	LastName     string
+	FirstInitial string
+	InmateNumber string
+}
+
+type inmateRecipient struct {
+	Inmate   Inmate
+	Facility Facility
+}
+
+// getInmateLobAddress returns the address a letter to the inmate should be
+// sent to: the inmate's name and DOC number at their facility's address. If
+// we already have a Lob address object for exactly that address it is sent by
+// id, otherwise the address is sent inline and Lob creates a new one, which
+// saveInmateLobAddress then caches.
+func getInmateLobAddress(inmateId string, lobEnvironment string) (lob.LobAddressParam, string, error) {
+	db, err := getDBConnection()
+	if err != nil {
+		return lob.LobAddressParam{}, "", err
+	}
+	defer db.Close()
+
+	recipient, err := getInmateRecipient(db, inmateId)
+	if err != nil {
+		return lob.LobAddressParam{}, "", err
+	}
+
+	request, err := getInmateAddressRequest(recipient)
+	if err != nil {
+		return lob.LobAddressParam{}, "", err
+	}
+	addressHash := hashAddressRequest(request)
+
+	var lobAddressId, cachedHash string
+	err = db.QueryRow(
+		"SELECT lobAddressId, addressHash FROM inmate_addresses WHERE inmateId = $1 AND lobEnvironment = $2",
+		inmateId,
+		lobEnvironment,
+	).Scan(&lobAddressId, &cachedHash)
+
+	if err == sql.ErrNoRows {
+		return lob.InlineAddress(request), addressHash, nil
+	} else if err != nil {
+		return lob.LobAddressParam{}, "", err
+	}
+
+	if cachedHash != addressHash {
+		return lob.InlineAddress(request), addressHash, nil
+	}
+	return lob.AddressId(lobAddressId), addressHash, nil
+}
+
+// saveInmateLobAddress caches the Lob address object created for the inmate
+// so later letters can refer to it by id
+func saveInmateLobAddress(inmateId string, lobEnvironment string, lobAddressId string, addressHash string) error {
+	if lobAddressId == "" {
+		return nil
+	}
+
+	db, err := getDBConnection()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec(
+		"INSERT INTO inmate_addresses (inmateId, lobEnvironment, lobAddressId, addressHash) "+
+			"VALUES ($1, $2, $3, $4) "+
+			"ON CONFLICT (inmateId, lobEnvironment) DO UPDATE "+
+			"SET lobAddressId = EXCLUDED.lobAddressId, addressHash = EXCLUDED.addressHash, timeCreated = now()",
+		inmateId,
+		lobEnvironment,
+		lobAddressId,
+		addressHash,
+	)
+
+	return err
+}
+
+// getInmateFacility returns the facility the inmate is held at
+func getInmateFacility(inmateId string) (Facility, error) {
+	db, err := getDBConnection()
+	if err != nil {
+		return Facility{}, err
+	}
+	defer db.Close()
+
+	recipient, err := getInmateRecipient(db, inmateId)
+	if err != nil {
+		return Facility{}, err
+	}
+	return recipient.Facility, nil
+}
+
+func getInmateRecipient(db *sql.DB, inmateId string) (inmateRecipient, error) {
+	recipient := inmateRecipient{}
+
+	fields := []string{
+		"inmates.id",
+		"inmates.state",
+		"inmates.inmateNumber",
+		"inmates.firstName",
+		"inmates.lastName",
+		"facilities.name",
+		"COALESCE(facilities.shortName, '')",
+		"facilities.addressLine",
+		"facilities.city",
+		"facilities.state",
+		"facilities.zip",
+		"COALESCE(facilities.verifiedAddressLine, '')",
+		"COALESCE(facilities.verifiedCity, '')",
+		"COALESCE(facilities.verifiedState, '')",
+		"COALESCE(facilities.verifiedZip, '')",
+		"facilities.recipientFormat",
+		"facilities.allowsColor",
+		"facilities.allowsDoubleSided",
+		"facilities.allowsImages",
+		"facilities.allowsPostcards",
+		"facilities.allowsGlitter",
+		"facilities.allowsStickers",
+		"facilities.allowsColoredPaper",
+		"COALESCE(facilities.maxLetterPages, 0)",
+		"facilities.allowedExtraServices",
+		"facilities.maxPhotos",
+		"facilities.allowedPhotoSizes",
+	}
+
+	err := db.QueryRow(
+		"SELECT "+strings.Join(fields, ", ")+" "+
+			"FROM inmates JOIN facilities ON inmates.facility = facilities.name "+
+			"WHERE inmates.id = $1",
+		inmateId,
+	).Scan(
+		&recipient.Inmate.Id,
+		&recipient.Inmate.State,
+		&recipient.Inmate.InmateNumber,
+		&recipient.Inmate.FirstName,
+		&recipient.Inmate.LastName,
+		&recipient.Facility.Name,
+		&recipient.Facility.ShortName,
+		&recipient.Facility.AddressLine,
+		&recipient.Facility.City,
+		&recipient.Facility.State,
+		&recipient.Facility.Zip,
+		&recipient.Facility.VerifiedAddressLine,
+		&recipient.Facility.VerifiedCity,
+		&recipient.Facility.VerifiedState,
+		&recipient.Facility.VerifiedZip,
+		&recipient.Facility.RecipientFormat,
+		&recipient.Facility.AllowsColor,
+		&recipient.Facility.AllowsDoubleSided,
+		&recipient.Facility.AllowsImages,
+		&recipient.Facility.AllowsPostcards,
+		&recipient.Facility.AllowsGlitter,
+		&recipient.Facility.AllowsStickers,
+		&recipient.Facility.AllowsColoredPaper,
+		&recipient.Facility.MaxLetterPages,
+		pq.Array(&recipient.Facility.AllowedExtraServices),
+		&recipient.Facility.MaxPhotos,
+		pq.Array(&recipient.Facility.AllowedPhotoSizes),
+	)
+
+	if err == sql.ErrNoRows {
+		return recipient, ErrInmateNotFound
+	} else if err != nil {
+		return recipient, err
+	}
+
+	recipient.Inmate.Facility = recipient.Facility.Name
+	return recipient, nil
+}
+
+// getInmateAddressRequest builds the inmate's envelope address: their name
+// and DOC number on the name line and the facility as the company, at the
+// facility's verified address when we have one
+func getInmateAddressRequest(recipient inmateRecipient) (lob.LobCreateAddressRequest, error) {
+	facility := recipient.Facility
+	if facility.VerifiedAddressLine != "" {
+		facility.AddressLine = facility.VerifiedAddressLine
+		facility.City = facility.VerifiedCity
+		facility.State = facility.VerifiedState
+		facility.Zip = facility.VerifiedZip
+	}
+
+	name, err := FormatRecipientName(recipient.Facility.RecipientFormat, recipient.Inmate)
+	if err != nil {
+		return lob.LobCreateAddressRequest{}, err
+	}
+
+	request := getFacilityAddressRequest(facility)
+	request.Description = "Inmate: " + recipient.Inmate.State + " " + recipient.Inmate.InmateNumber
+	request.Company = request.Name
+	request.Name = name
+	request.Metadata = map[string]string{
+		AppMetadataKey: AppMetadataValue,
+		"inmate_id":    recipient.Inmate.Id,
+	}
+
+	return request, nil
+}
+
+// FormatRecipientName renders a facility's recipient format for the inmate.
+// Lob rejects names over MaxLobNameLength characters, so a name that's too
+// long is rendered again with the first name shortened to an initial, and
+// the last name cut short as a last resort. The inmate number is never cut,
+// since facilities need it to deliver the mail.
+func FormatRecipientName(format string, inmate Inmate) (string, error) {
+	if strings.TrimSpace(format) == "" {
+		format = DefaultRecipientFormat
+	}
+
+	fields := RecipientNameFields{
+		FirstName:    strings.TrimSpace(inmate.FirstName),
+		LastName:     strings.TrimSpace(inmate.LastName),
+		InmateNumber: strings.TrimSpace(inmate.InmateNumber),
+	}
+	if fields.FirstName != "" {
+		fields.FirstInitial = string([]rune(fields.FirstName)[:1])
+	}
+
+	name, err := renderRecipientName(format, fields)
+	if err != nil {
+		return "", err
+	}
+
+	if utf8.RuneCountInString(name) > MaxLobNameLength {
+		name, err = renderRecipientName(ShortRecipientFormat, fields)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	for utf8.RuneCountInString(name) > MaxLobNameLength {
+		lastName := []rune(fields.LastName)
+		excess := utf8.RuneCountInString(name) - MaxLobNameLength
+		if excess >= len(lastName) {
+			return "", ErrRecipientNameTooLong
+		}
+
+		fields.LastName = strings.TrimSpace(string(lastName[:len(lastName)-excess]))
+		name, err = renderRecipientName(ShortRecipientFormat, fields)
+		if err != nil {
+			return "", err
+		}
+	}
+	return name, nil
+}
+
+func renderRecipientName(format string, fields RecipientNameFields) (string, error) {
+	tmpl, err := template.New("recipient").Option("missingkey=error").Parse(format)
+	if err != nil {
+		return "", ErrInvalidRecipientFormat
+	}
+
+	var name bytes.Buffer
+	err = tmpl.Execute(&name, fields)
+	if err != nil {
+		return "", ErrInvalidRecipientFormat
+	}
+
+	return strings.Join(strings.Fields(name.String()), " "), nil
+}
+
+// hashAddressRequest identifies the parts of an address that end up on the
+// envelope, so a cached Lob address is only reused if they are unchanged
+func hashAddressRequest(request lob.LobCreateAddressRequest) string {
+	parts := []string{
+		request.Name,
+		request.Company,
+		request.AddressLine1,
+		request.AddressLine2,
+		request.AddressCity,
+		request.AddressState,
+		request.AddressZip,
+		request.AddressCountry,
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\n")))
+	return hex.EncodeToString(sum[:])
+}
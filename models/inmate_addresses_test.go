@@ -0,0 +1,81 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestFormatRecipientName(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		inmate Inmate
+		want   string
+		err    error
+	}{
+		{
+			name:   "default format",
+			inmate: Inmate{FirstName: "John", LastName: "Grant", InmateNumber: "123456"},
+			want:   "John Grant #123456",
+		},
+		{
+			name:   "facility format",
+			format: "{{.LastName}}, {{.FirstName}} #{{.InmateNumber}}",
+			inmate: Inmate{FirstName: "John", LastName: "Grant", InmateNumber: "123456"},
+			want:   "Grant, John #123456",
+		},
+		{
+			name:   "extra spaces",
+			inmate: Inmate{FirstName: " John ", LastName: "Grant  ", InmateNumber: " 123456"},
+			want:   "John Grant #123456",
+		},
+		{
+			name:   "first initial when too long",
+			inmate: Inmate{FirstName: "Maximiliano Alejandro", LastName: "Fernández-Rodríguez", InmateNumber: "123456"},
+			want:   "M Fernández-Rodríguez #123456",
+		},
+		{
+			name:   "initial of a multibyte first name",
+			inmate: Inmate{FirstName: "Ángel Maximiliano Alejandro", LastName: "Fernández-Rodríguez", InmateNumber: "123456"},
+			want:   "Á Fernández-Rodríguez #123456",
+		},
+		{
+			name:   "last name cut, inmate number kept",
+			inmate: Inmate{FirstName: "John", LastName: "Wolfeschlegelsteinhausenbergerdorff", InmateNumber: "123456"},
+			want:   "J Wolfeschlegelsteinhausenberger #123456",
+		},
+		{
+			name:   "inmate number too long",
+			inmate: Inmate{FirstName: "John", LastName: "Grant", InmateNumber: strings.Repeat("9", MaxLobNameLength)},
+			err:    ErrRecipientNameTooLong,
+		},
+		{
+			name:   "invalid format",
+			format: "{{.FirstName",
+			inmate: Inmate{FirstName: "John", LastName: "Grant", InmateNumber: "123456"},
+			err:    ErrInvalidRecipientFormat,
+		},
+		{
+			name:   "unknown field",
+			format: "{{.MiddleName}} {{.LastName}}",
+			inmate: Inmate{FirstName: "John", LastName: "Grant", InmateNumber: "123456"},
+			err:    ErrInvalidRecipientFormat,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := FormatRecipientName(test.format, test.inmate)
+			if err != test.err {
+				t.Fatalf("FormatRecipientName() error = %v, want %v", err, test.err)
+			}
+			if got != test.want {
+				t.Errorf("FormatRecipientName() = %q, want %q", got, test.want)
+			}
+			if utf8.RuneCountInString(got) > MaxLobNameLength {
+				t.Errorf("FormatRecipientName() = %q, longer than %d characters", got, MaxLobNameLength)
+			}
+		})
+	}
+}
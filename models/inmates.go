@@ -3,8 +3,15 @@ package models
 import (
 	"errors"
 	"log"
+	"strings"
 
 	"github.com/johnamadeo/intouchgo/lob"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrInmateNotFound = errors.New("No inmate with requested id found.")
+	ErrNoLobAddress   = errors.New("The inmate's facility can't receive mail through InTouch yet.")
 )
 
 type Inmate struct {
@@ -23,14 +30,32 @@ type InmateKey struct {
 }
 
 type Facility struct {
-	Name             string
-	ShortName        string
-	AddressLine      string
-	City             string
-	State            string
-	Zip              string
-	LobTestAddressId string
-	LobLiveAddressId string
+	Name                 string
+	ShortName            string
+	AddressLine          string
+	City                 string
+	State                string
+	Zip                  string
+	LobTestAddressId     string
+	LobLiveAddressId     string
+	VerifiedAddressLine  string
+	VerifiedCity         string
+	VerifiedState        string
+	VerifiedZip          string
+	Deliverability       string
+	AddressNeedsReview   bool
+	RecipientFormat      string
+	AllowsColor          bool
+	AllowsDoubleSided    bool
+	AllowsImages         bool
+	AllowsPostcards      bool
+	AllowsGlitter        bool
+	AllowsStickers       bool
+	AllowsColoredPaper   bool
+	MaxLetterPages       int // 0 if only the envelope limits the length
+	AllowedExtraServices []string
+	MaxPhotos            int
+	AllowedPhotoSizes    []string
 }
 
 func getKey(inmate Inmate) InmateKey {
@@ -59,30 +84,77 @@ func GetFacilitiesFromDB() ([]Facility, error) {
 	}
 	defer db.Close()
 
-	rows, err := db.Query("SELECT * FROM facilities")
+	fields := []string{
+		"name",
+		"COALESCE(shortName, '')",
+		"addressLine",
+		"city",
+		"state",
+		"zip",
+		"lobTestAddressId",
+		"lobLiveAddressId",
+		"COALESCE(verifiedAddressLine, '')",
+		"COALESCE(verifiedCity, '')",
+		"COALESCE(verifiedState, '')",
+		"COALESCE(verifiedZip, '')",
+		"COALESCE(deliverability, '')",
+		"addressNeedsReview",
+		"recipientFormat",
+		"allowsColor",
+		"allowsDoubleSided",
+		"allowsImages",
+		"allowsPostcards",
+		"allowsGlitter",
+		"allowsStickers",
+		"allowsColoredPaper",
+		"COALESCE(maxLetterPages, 0)",
+		"allowedExtraServices",
+		"maxPhotos",
+		"allowedPhotoSizes",
+	}
+
+	rows, err := db.Query("SELECT " + strings.Join(fields, ", ") + " FROM facilities ORDER BY name")
 	if err != nil {
 		return facilities, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var name, shortName, addressLine, city, state, zip, lobTestAddressId, lobLiveAddressId string
-		err := rows.Scan(&name, &shortName, &addressLine, &city, &state, &zip, &lobTestAddressId, &lobLiveAddressId)
+		facility := Facility{}
+		err := rows.Scan(
+			&facility.Name,
+			&facility.ShortName,
+			&facility.AddressLine,
+			&facility.City,
+			&facility.State,
+			&facility.Zip,
+			&facility.LobTestAddressId,
+			&facility.LobLiveAddressId,
+			&facility.VerifiedAddressLine,
+			&facility.VerifiedCity,
+			&facility.VerifiedState,
+			&facility.VerifiedZip,
+			&facility.Deliverability,
+			&facility.AddressNeedsReview,
+			&facility.RecipientFormat,
+			&facility.AllowsColor,
+			&facility.AllowsDoubleSided,
+			&facility.AllowsImages,
+			&facility.AllowsPostcards,
+			&facility.AllowsGlitter,
+			&facility.AllowsStickers,
+			&facility.AllowsColoredPaper,
+			&facility.MaxLetterPages,
+			pq.Array(&facility.AllowedExtraServices),
+			&facility.MaxPhotos,
+			pq.Array(&facility.AllowedPhotoSizes),
+		)
 
 		if err != nil {
 			return facilities, err
 		}
 
-		facilities = append(facilities, Facility{
-			Name:             name,
-			ShortName:        shortName,
-			AddressLine:      addressLine,
-			City:             city,
-			State:            state,
-			Zip:              zip,
-			LobTestAddressId: lobTestAddressId,
-			LobLiveAddressId: lobLiveAddressId,
-		})
+		facilities = append(facilities, facility)
 	}
 
 	return facilities, nil
@@ -114,6 +186,7 @@ func GetInmateAddress(inmateId string, lobEnvironment string) (string, error) {
 	}
 	defer rows.Close()
 
+	found := false
 	addressId := ""
 	for rows.Next() {
 		err := rows.Scan(&addressId)
@@ -121,11 +194,14 @@ func GetInmateAddress(inmateId string, lobEnvironment string) (string, error) {
 			return "", err
 		}
 
+		found = true
 		break
 	}
 
-	if addressId == "" {
-		return "", errors.New("No inmate with requested id found.")
+	if !found {
+		return "", ErrInmateNotFound
+	} else if addressId == "" {
+		return "", ErrNoLobAddress
 	}
 	return addressId, nil
 }
@@ -263,3 +339,47 @@ func SaveInmatesFromScraper(scraperInmates []Inmate) error {
 
 	return nil
 }
+
+// getRecipientsForAuthor returns every inmate the user has written to along
+// with the number of letters they sent them
+func getRecipientsForAuthor(username string) ([]ExportRecipient, error) {
+	recipients := []ExportRecipient{}
+
+	db, err := getDBConnection()
+	if err != nil {
+		return recipients, err
+	}
+	defer db.Close()
+
+	query := "SELECT inmates.id, inmates.state, inmates.inmateNumber, inmates.firstName, inmates.lastName, " +
+		"COALESCE(inmates.facility, ''), COUNT(letters.id) " +
+		"FROM letters JOIN inmates ON letters.recipient = inmates.id " +
+		"WHERE letters.author = $1 " +
+		"GROUP BY inmates.id, inmates.state, inmates.inmateNumber, inmates.firstName, inmates.lastName, inmates.facility"
+
+	rows, err := db.Query(query, username)
+	if err != nil {
+		return recipients, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		recipient := ExportRecipient{}
+		err := rows.Scan(
+			&recipient.Id,
+			&recipient.State,
+			&recipient.InmateNumber,
+			&recipient.FirstName,
+			&recipient.LastName,
+			&recipient.Facility,
+			&recipient.LettersSent,
+		)
+		if err != nil {
+			return recipients, err
+		}
+
+		recipients = append(recipients, recipient)
+	}
+
+	return recipients, nil
+}
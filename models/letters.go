@@ -1,63 +1,180 @@
 package models
 
 import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/johnamadeo/intouchgo/i18n"
 	"github.com/johnamadeo/intouchgo/lob"
+	"github.com/johnamadeo/intouchgo/render"
 	"github.com/johnamadeo/intouchgo/utils"
+	"github.com/lib/pq"
+)
+
+const (
+	// How long after a letter is written Lob holds it before printing, which
+	// is how long the author has to cancel it. Set as a Go duration, e.g. 1h.
+	DefaultLetterSendDelay = time.Hour
+	LetterSendDelayEnv     = "LETTER_SEND_DELAY"
+
+	// Lob won't accept a send date that's about to pass, so letters whose
+	// undo window is nearly over are sent for printing straight away
+	MinLobSendDateLead = time.Minute
+
+	// The parts of a split letter after the first are given the letter's id
+	// followed by this and their number. Clients can't use it in their ids,
+	// so that part ids never clash with theirs.
+	PartIdSeparator = "~part"
+
+	LetterQueued  = "queued"
+	LetterSending = "sending"
+	LetterSent    = "sent"
+	LetterFailed  = "failed"
+
+	// Every letter we send to Lob is tagged with these metadata keys so that
+	// it can be matched back to our records
+	LetterIdMetadataKey   = "letter_id"
+	PostcardIdMetadataKey = "postcard_id"
+	AppMetadataKey        = "app"
+	AppMetadataValue      = "intouch"
+)
+
+var (
+	ErrLetterExists         = errors.New("A letter with that id already exists")
+	ErrLetterNotFound       = errors.New("No letter with requested id found")
+	ErrLetterBeingSent      = errors.New("The letter is being sent. Try again in a moment.")
+	ErrLetterNotCancellable = errors.New("The letter has already been mailed and can no longer be cancelled")
 )
 
 type Letter struct {
-	Id                    string `json:"id"`
-	Author                string `json:"author"`
-	Recipient             string `json:"recipient"`
-	RecipientId           string `json:"recipientId"`
-	Subject               string `json:"subject"`
-	Text                  string `json:"text"`
-	TimeSent              string `json:"timeSent"`
-	TimeLastEdited        string `json:"timeLastEdited"`
-	TimeDeliveredEstimate string `json:"timeDeliveredEstimate"`
-	IsDraft               bool   `json:"isDraft"`
-	LobLetterId           string `json:"lobLetterId"`
+	Id                    string        `json:"id"`
+	Author                string        `json:"author"`
+	Recipient             string        `json:"recipient"`
+	RecipientId           string        `json:"recipientId"`
+	Subject               string        `json:"subject"`
+	Text                  string        `json:"text"`
+	TimeSent              string        `json:"timeSent"`
+	TimeLastEdited        string        `json:"timeLastEdited"`
+	TimeDeliveredEstimate string        `json:"timeDeliveredEstimate"`
+	IsDraft               bool          `json:"isDraft"`
+	LobLetterId           string        `json:"lobLetterId"`
+	Status                string        `json:"status"`
+	LobEnvironment        string        `json:"lobEnvironment"`
+	ReturnAddressId       string        `json:"returnAddressId"`
+	SendDate              string        `json:"sendDate"`
+	Cancellable           bool          `json:"cancellable"`
+	MailType              string        `json:"mailType"`
+	Color                 bool          `json:"color"`
+	DoubleSided           *bool         `json:"doubleSided"`
+	ExtraService          string        `json:"extraService"`
+	AddressPlacement      string        `json:"addressPlacement"`
+	Price                 string        `json:"price"`
+	ThemeId               string        `json:"themeId"`
+	Format                string        `json:"format"` // plain or markdown
+	Locale                string        `json:"locale"`
+	LargePrint            *bool         `json:"largePrint"`
+	PhotoIds              []string      `json:"photoIds"` // Uploaded photos printed after the text
+	CardId                string        `json:"cardId"`   // Mail the text inside a folded card instead
+	Split                 bool          `json:"split"`    // Mail the letter in parts if it's too long for one
+	Parts                 []string      `json:"parts,omitempty"`
+	EstimatedPages        int           `json:"estimatedPages,omitempty"`
+	EstimatedCost         string        `json:"estimatedCost,omitempty"`
+	Timeline              []LetterEvent `json:"timeline"`
+
+	sendDate   time.Time
+	timeQueued time.Time
 }
 
 func GetLettersFromDB(username string) ([]Letter, error) {
-	letters := []Letter{}
+	db, err := getDBConnection()
+	if err != nil {
+		return []Letter{}, err
+	}
+	defer db.Close()
+
+	return getLetters(db, "WHERE letters.author = $1", username)
+}
 
+func GetLetterFromDB(id string) (Letter, error) {
 	db, err := getDBConnection()
 	if err != nil {
-		return letters, err
+		return Letter{}, err
 	}
 	defer db.Close()
 
+	return getLetter(db, id)
+}
+
+func getLetter(db *sql.DB, id string) (Letter, error) {
+	letters, err := getLetters(db, "WHERE letters.id = $1", id)
+	if err != nil {
+		return Letter{}, err
+	}
+
+	if len(letters) == 0 {
+		return Letter{}, ErrLetterNotFound
+	}
+	return letters[0], nil
+}
+
+func getLetters(db *sql.DB, where string, args ...interface{}) ([]Letter, error) {
+	letters := []Letter{}
+
 	fields := []string{
 		"letters.id",
 		"letters.author",
 		"CONCAT(inmates.firstName, ' ', inmates.lastName) AS recipient",
 		"letters.recipient AS recipientId",
-		"letters.subject",
+		"COALESCE(letters.subject, '')",
 		"letters.text",
 		"TO_CHAR(letters.timeSent, 'MM/dd/yy')",
 		"TO_CHAR(letters.timeLastEdited, 'MM/dd/yy')",
-		"TO_CHAR(letters.timeDeliveredEstimate, 'MM/dd/yy')",
+		"COALESCE(TO_CHAR(letters.timeDeliveredEstimate, 'MM/dd/yy'), '')",
 		"letters.isDraft",
-		"letters.lobLetterId",
+		"COALESCE(letters.lobLetterId, '')",
+		"letters.status",
+		"letters.lobEnvironment",
+		"COALESCE(letters.returnAddressId, '')",
+		"letters.sendDate",
+		"letters.heldByLob",
+		"letters.mailType",
+		"letters.color",
+		"letters.doubleSided",
+		"COALESCE(letters.extraService, '')",
+		"letters.addressPlacement",
+		"COALESCE(TO_CHAR(letters.cost, 'FM999990.00'), '')",
+		"letters.themeId",
+		"letters.format",
+		"letters.locale",
+		"letters.largePrint",
+		"letters.photoIds",
+		"COALESCE(letters.cardId, '')",
+		"letters.timeQueued",
 	}
 
 	query := "SELECT " + strings.Join(fields[:], ", ") + " " +
 		"FROM letters JOIN inmates " +
 		"ON letters.recipient = inmates.id " +
-		"WHERE letters.author = $1"
+		where + " " +
+		"ORDER BY letters.timeQueued"
 
-	rows, err := db.Query(query, username)
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return letters, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var id, author, recipient, recipientId, subject, text, timeSent, timeLastEdited, timeDeliveredEstimate, lobLetterId string
-		var isDraft bool
+		var id, author, recipient, recipientId, subject, text, timeSent, timeLastEdited, timeDeliveredEstimate, lobLetterId, status, lobEnvironment, returnAddressId string
+		var mailType, extraService, addressPlacement, price, themeId, format, locale, cardId string
+		var isDraft, heldByLob, color, doubleSided, largePrint bool
+		var photoIds []string
+		var sendDate, timeQueued time.Time
 		err := rows.Scan(
 			&id,
 			&author,
@@ -70,6 +187,24 @@ func GetLettersFromDB(username string) ([]Letter, error) {
 			&timeDeliveredEstimate,
 			&isDraft,
 			&lobLetterId,
+			&status,
+			&lobEnvironment,
+			&returnAddressId,
+			&sendDate,
+			&heldByLob,
+			&mailType,
+			&color,
+			&doubleSided,
+			&extraService,
+			&addressPlacement,
+			&price,
+			&themeId,
+			&format,
+			&locale,
+			&largePrint,
+			pq.Array(&photoIds),
+			&cardId,
+			&timeQueued,
 		)
 
 		if err != nil {
@@ -88,78 +223,352 @@ func GetLettersFromDB(username string) ([]Letter, error) {
 			TimeDeliveredEstimate: timeDeliveredEstimate,
 			IsDraft:               isDraft,
 			LobLetterId:           lobLetterId,
+			Status:                status,
+			LobEnvironment:        lobEnvironment,
+			ReturnAddressId:       returnAddressId,
+			SendDate:              sendDate.Format(time.RFC3339),
+			Cancellable:           isLetterCancellable(status, sendDate, heldByLob),
+			MailType:              mailType,
+			Color:                 color,
+			DoubleSided:           &doubleSided,
+			ExtraService:          extraService,
+			AddressPlacement:      addressPlacement,
+			Price:                 price,
+			ThemeId:               themeId,
+			Format:                format,
+			Locale:                locale,
+			LargePrint:            &largePrint,
+			PhotoIds:              photoIds,
+			CardId:                cardId,
+			sendDate:              sendDate,
+			timeQueued:            timeQueued,
 		}
 
 		letters = append(letters, letter)
 	}
+	rows.Close()
+
+	ids := make([]string, len(letters))
+	for i, letter := range letters {
+		ids[i] = letter.Id
+	}
+
+	timelines, err := getLetterTimelines(db, ids)
+	if err != nil {
+		return letters, err
+	}
+
+	for i := range letters {
+		letters[i].Timeline = timelines[letters[i].Id]
+		if letters[i].Timeline == nil {
+			letters[i].Timeline = []LetterEvent{}
+		}
+	}
 
 	return letters, nil
 }
 
+// SendLetter records the letter as queued. It is mailed afterwards by the
+// letter dispatcher, so a letter is never sent to Lob without first being
+// saved. Sending a letter whose id is already queued by the same author
+// returns the existing letter instead of queueing it twice.
+//
+// A letter longer than its page limit is refused unless it asks to be
+// split, in which case it is queued as several letters whose subjects are
+// numbered for the reader, with the photos in the last. The first is
+// returned with the ids of all parts.
 func SendLetter(letter Letter) (Letter, error) {
-	response, err := sendLetterToLob(letter, lob.LobTestEnvironment)
+	if strings.Contains(letter.Id, PartIdSeparator) {
+		return Letter{}, &MailOptionsError{Errors: []utils.FieldError{newFieldError("id", "field.letterId", PartIdSeparator)}}
+	}
+
+	lobEnvironment, err := GetLobEnvironmentForUser(letter.Author)
+	if err != nil {
+		return Letter{}, err
+	}
+
+	// Refuse letters to facilities we can't mail in this environment now,
+	// rather than queueing a letter that can only fail
+	inmateAddressId, err := GetInmateAddress(letter.RecipientId, lobEnvironment)
+	if err != nil {
+		return Letter{}, err
+	}
+
+	err = checkLetterAddresses(lobEnvironment, inmateAddressId)
+	if err != nil {
+		return Letter{}, err
+	}
+
+	if letter.ReturnAddressId != "" {
+		err = checkReturnAddress(letter.Author, letter.ReturnAddressId)
+		if err != nil {
+			return Letter{}, err
+		}
+	}
+
+	facility, err := getInmateFacility(letter.RecipientId)
+	if err != nil {
+		return Letter{}, err
+	}
+
+	letter, err = applyRecipientDefaults(letter)
 	if err != nil {
 		return Letter{}, err
 	}
 
-	date, err := lob.LobDateToDBDate(response.ExpectedDeliveryDate)
+	letter, err = applyMailOptions(letter, facility)
 	if err != nil {
 		return Letter{}, err
 	}
 
-	letter.TimeDeliveredEstimate = date
-	letter.LobLetterId = response.Id
+	letterPhotos, err := getLetterPhotos(letter.Author, letter.PhotoIds)
+	if err != nil {
+		return Letter{}, err
+	}
+
+	err = checkLetterPhotos(letterPhotos, facility)
+	if err != nil {
+		return Letter{}, err
+	}
+
+	maxPages, err := getLetterPageLimit(letter, facility)
+	if err != nil {
+		return Letter{}, err
+	}
 
-	err = createLetterInDB(letter)
+	pages, err := estimateLetterPages(letter, letter.Text)
 	if err != nil {
 		return Letter{}, err
 	}
+	pages += len(letterPhotos)
+
+	letter.LobEnvironment = lobEnvironment
+	letter.Status = LetterQueued
+	letter.LobLetterId = ""
+	letter.TimeDeliveredEstimate = ""
+	letter.Price = ""
+	letter.sendDate = time.Now().UTC().Add(getLetterSendDelay()).Truncate(time.Second)
+
+	if pages <= maxPages {
+		letter.EstimatedPages = pages
+		letter.EstimatedCost = estimateLetterCost(letter, pages)
+		queued, err := queueLetters([]Letter{letter})
+		if err != nil {
+			return Letter{}, err
+		}
+		return queued[0], nil
+	} else if !letter.Split {
+		return Letter{}, newLetterTooLongError(facility, pages, maxPages)
+	}
 
-	return letter, nil
+	texts, err := splitLetterText(letter, len(letterPhotos), maxPages)
+	if err == render.ErrTextTooLong {
+		return Letter{}, newLetterTooLongError(facility, pages, maxPages)
+	} else if err != nil {
+		return Letter{}, err
+	}
+
+	if len(texts) > MaxLetterParts {
+		return Letter{}, &MailOptionsError{Errors: []utils.FieldError{newFieldError("text", "field.tooManyParts", MaxLetterParts)}}
+	}
+
+	parts := []Letter{}
+	for i, text := range texts {
+		part := letter
+		part.Text = text
+		part.Subject = i18n.T(letter.Locale, "letter.partSubject", letter.Subject, i+1, len(texts))
+		if i > 0 {
+			part.Id = letter.Id + PartIdSeparator + strconv.Itoa(i+1)
+		}
+		if i < len(texts)-1 {
+			part.PhotoIds = []string{}
+		}
+
+		part.EstimatedPages, err = render.EstimatePages(part.ThemeId, part.Format, part.Text, *part.LargePrint)
+		if err != nil {
+			return Letter{}, err
+		}
+		part.EstimatedPages += len(part.PhotoIds)
+		part.EstimatedCost = estimateLetterCost(part, part.EstimatedPages)
+		parts = append(parts, part)
+	}
+
+	// All parts are queued or none are, so that the author never gets some
+	// of the letter without the rest
+	parts, err = queueLetters(parts)
+	if err != nil {
+		return Letter{}, err
+	}
+
+	first := parts[0]
+	for _, part := range parts {
+		first.Parts = append(first.Parts, part.Id)
+	}
+	return first, nil
+}
+
+// queueLetters saves checked letters for the dispatcher in one transaction.
+// If the first letter is already queued by the same author, the letters
+// queued with it are returned instead of queueing them twice.
+func queueLetters(letters []Letter) ([]Letter, error) {
+	err := createLettersInDB(letters)
+	if err == ErrLetterExists {
+		return getQueuedLetters(letters)
+	} else if err != nil {
+		return []Letter{}, err
+	}
+
+	notifyLetterDispatcher()
+
+	for i := range letters {
+		letters[i].SendDate = letters[i].sendDate.Format(time.RFC3339)
+		letters[i].Cancellable = isLetterCancellable(letters[i].Status, letters[i].sendDate, false)
+	}
+	return letters, nil
+}
+
+// getQueuedLetters returns what was queued when the same letters were sent
+// before, e.g. by a client retrying after a timeout
+func getQueuedLetters(letters []Letter) ([]Letter, error) {
+	queued := []Letter{}
+	for i, letter := range letters {
+		existing, err := GetLetterFromDB(letter.Id)
+		if err == ErrLetterNotFound && i > 0 {
+			// The letter was split into fewer parts last time
+			break
+		} else if err == ErrLetterNotFound {
+			return []Letter{}, ErrLetterExists
+		} else if err != nil {
+			return []Letter{}, err
+		}
+
+		if existing.Author != letter.Author {
+			return []Letter{}, ErrLetterExists
+		}
+		queued = append(queued, existing)
+	}
+	return queued, nil
 }
 
-func createLetterInDB(letter Letter) error {
+func createLettersInDB(letters []Letter) error {
 	db, err := getDBConnection()
 	if err != nil {
 		return err
 	}
 	defer db.Close()
 
-	_, err = db.Exec(
-		"INSERT INTO letters VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)",
-		letter.Id,
-		letter.Author,
-		letter.RecipientId,
-		letter.Subject,
-		letter.Text,
-		letter.TimeSent,
-		letter.TimeLastEdited,
-		letter.TimeDeliveredEstimate,
-		letter.IsDraft,
-		letter.LobLetterId,
-	)
-
+	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
 
-	return nil
+	for _, letter := range letters {
+		_, err = tx.Exec(
+			"INSERT INTO letters (id, author, recipient, subject, text, timeSent, timeLastEdited, isDraft, status, lobEnvironment, returnAddressId, sendDate, "+
+				"mailType, color, doubleSided, extraService, addressPlacement, themeId, format, locale, largePrint, photoIds, cardId) "+
+				"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NULLIF($11, ''), $12, $13, $14, $15, NULLIF($16, ''), $17, $18, $19, $20, $21, $22, NULLIF($23, ''))",
+			letter.Id,
+			letter.Author,
+			letter.RecipientId,
+			letter.Subject,
+			letter.Text,
+			letter.TimeSent,
+			letter.TimeLastEdited,
+			letter.IsDraft,
+			letter.Status,
+			letter.LobEnvironment,
+			letter.ReturnAddressId,
+			letter.sendDate,
+			letter.MailType,
+			letter.Color,
+			*letter.DoubleSided,
+			letter.ExtraService,
+			letter.AddressPlacement,
+			letter.ThemeId,
+			letter.Format,
+			letter.Locale,
+			*letter.LargePrint,
+			pq.Array(letter.PhotoIds),
+			letter.CardId,
+		)
+
+		if isUniqueViolation(err) {
+			tx.Rollback()
+			return ErrLetterExists
+		} else if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
-func sendLetterToLob(letter Letter, lobEnvironment string) (lob.LobSendLetterResponse, error) {
-	var response lob.LobSendLetterResponse
+// mailAddresses are the Lob addresses a piece of mail is sent to and from
+type mailAddresses struct {
+	to          lob.LobAddressParam
+	addressHash string // Of the inline recipient address, if to isn't an id
+	fromId      string
+}
 
-	inmateAddressId, err := GetInmateAddress(letter.RecipientId, lobEnvironment)
+// getMailAddresses looks up who mail to an inmate is addressed to and who
+// it comes from
+func getMailAddresses(recipientId string, returnAddressId string, lobEnvironment string) (mailAddresses, error) {
+	// The facility must have a Lob address in this environment before we
+	// mail anyone there
+	inmateAddressId, err := GetInmateAddress(recipientId, lobEnvironment)
 	if err != nil {
-		return response, err
+		return mailAddresses{}, err
 	}
 
-	inTouchAddressId, err := lob.GetInTouchAddress(lobEnvironment)
+	to, addressHash, err := getInmateLobAddress(recipientId, lobEnvironment)
 	if err != nil {
-		return response, err
+		return mailAddresses{}, err
+	}
+
+	// Inmates write back to the sender's own address if they chose one, and
+	// otherwise to InTouch, which forwards their replies
+	fromId := ""
+	if returnAddressId != "" {
+		fromId, err = getReturnLobAddressId(returnAddressId, lobEnvironment)
+	} else {
+		fromId, err = lob.GetInTouchAddress(lobEnvironment)
+	}
+	if err != nil {
+		return mailAddresses{}, err
+	}
+
+	addressIds := []string{inmateAddressId, fromId}
+	if to.Id != "" {
+		addressIds = append(addressIds, to.Id)
+	}
+
+	err = checkLetterAddresses(lobEnvironment, addressIds...)
+	if err != nil {
+		return mailAddresses{}, err
+	}
+
+	return mailAddresses{to: to, addressHash: addressHash, fromId: fromId}, nil
+}
+
+// saveMailAddress caches the Lob address that Lob created for an inline
+// recipient address. The mail is already sent, so failing to cache the
+// address only means the next letter sends it inline again.
+func saveMailAddress(recipientId string, lobEnvironment string, addresses mailAddresses, lobAddressId string) {
+	if addresses.to.Address == nil {
+		return
 	}
 
-	htmlString, err := lob.GetLetterHTMLTemplate(letter.Text)
+	err := saveInmateLobAddress(recipientId, lobEnvironment, lobAddressId, addresses.addressHash)
+	if err != nil {
+		fmt.Println("Error saving Lob address for inmate " + recipientId + ": " + err.Error())
+	}
+}
+
+func sendLetterToLob(letter Letter, lobEnvironment string) (lob.LobLetter, error) {
+	var response lob.LobLetter
+
+	addresses, err := getMailAddresses(letter.RecipientId, letter.ReturnAddressId, lobEnvironment)
 	if err != nil {
 		return response, err
 	}
@@ -169,29 +578,234 @@ func sendLetterToLob(letter Letter, lobEnvironment string) (lob.LobSendLetterRes
 		return response, err
 	}
 
+	htmlString, err := getLetterHTML(letter, authorName)
+	if err != nil {
+		return response, err
+	}
+
 	request := lob.LobSendLetterRequest{
-		Color:    false,
-		MailType: lob.USPSStandard,
-		From:     inTouchAddressId,
-		To:       inmateAddressId,
-		File:     htmlString,
-		MergeVariables: map[string]string{
-			"author":    authorName,
-			"recipient": letter.Recipient,
-			"subject":   letter.Subject,
-			"timeSent":  letter.TimeSent,
+		Description:      "InTouch letter " + letter.Id,
+		Color:            letter.Color,
+		DoubleSided:      *letter.DoubleSided,
+		MailType:         letter.MailType,
+		ExtraService:     letter.ExtraService,
+		AddressPlacement: letter.AddressPlacement,
+		From:             lob.AddressId(addresses.fromId),
+		To:               addresses.to,
+		File:             htmlString,
+		SendDate:         getLobSendDate(letter.sendDate),
+		Metadata: map[string]string{
+			LetterIdMetadataKey: letter.Id,
+			AppMetadataKey:      AppMetadataValue,
 		},
 	}
 
-	err = lob.Post(
-		"letters",
-		utils.JSONToForm(request),
-		&response,
-		lob.LobTestEnvironment,
-	)
+	client, err := lob.NewClient(lobEnvironment)
 	if err != nil {
 		return response, err
 	}
 
+	response, err = client.CreateLetter(request, letter.Id)
+	if err != nil {
+		return response, err
+	}
+
+	saveMailAddress(letter.RecipientId, lobEnvironment, addresses, response.To.Id)
 	return response, nil
 }
+
+// getLetterHTML returns the complete document Lob prints for the letter
+func getLetterHTML(letter Letter, authorName string) (string, error) {
+	data := render.NewLetterData(
+		letter.Locale,
+		authorName,
+		letter.Recipient,
+		letter.Subject,
+		letter.TimeSent,
+		letter.Format,
+		letter.Text,
+	)
+	if letter.LargePrint != nil {
+		data.LargePrint = *letter.LargePrint
+	}
+
+	if letter.CardId != "" {
+		return render.RenderCardHTML(letter.CardId, data)
+	}
+
+	letterPhotos, err := getLetterPhotos(letter.Author, letter.PhotoIds)
+	if err != nil {
+		return "", err
+	}
+	data.Photos, err = getPhotoPages(letterPhotos)
+	if err != nil {
+		return "", err
+	}
+
+	return render.RenderLetterHTML(letter.ThemeId, data)
+}
+
+func checkLetterAddresses(lobEnvironment string, addressIds ...string) error {
+	db, err := getDBConnection()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	for _, addressId := range addressIds {
+		err := checkAddressEnvironment(db, addressId, lobEnvironment)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func getLetterSendDelay() time.Duration {
+	delay := DefaultLetterSendDelay
+	if value, ok := os.LookupEnv(LetterSendDelayEnv); ok {
+		if parsed, err := time.ParseDuration(value); err == nil && parsed >= 0 {
+			delay = parsed
+		}
+	}
+
+	return delay
+}
+
+func getLobSendDate(sendDate time.Time) time.Time {
+	if time.Until(sendDate) < MinLobSendDateLead {
+		return time.Time{}
+	}
+	return sendDate
+}
+
+// isLetterCancellable reports whether the letter can still be stopped: it
+// hasn't been handed to Lob yet, or Lob is still holding it until its send
+// date. Lob only holds letters it was given the send date for, which it
+// isn't when the date is too close. Letters being sent right now are left
+// alone until the dispatcher is done with them.
+func isLetterCancellable(status string, sendDate time.Time, heldByLob bool) bool {
+	switch status {
+	case LetterQueued:
+		return true
+	case LetterSending:
+		return time.Until(sendDate) >= MinLobSendDateLead
+	case LetterSent, LetterRendered:
+		return heldByLob && time.Now().Before(sendDate)
+	}
+	return false
+}
+
+// CancelLetter stops a letter before it's printed. A letter that is still
+// queued is cancelled here without involving Lob; one that has been sent is
+// cancelled in Lob, which only allows it until the letter's send date. The
+// parts of a split letter are cancelled together, whichever one is given.
+func CancelLetter(username string, id string) (Letter, error) {
+	db, err := getDBConnection()
+	if err != nil {
+		return Letter{}, err
+	}
+	defer db.Close()
+
+	letter, err := getLetter(db, id)
+	if err != nil {
+		return Letter{}, err
+	}
+
+	if letter.Author != username {
+		return Letter{}, ErrLetterNotFound
+	}
+
+	// The first part keeps the letter's id and the others add a suffix to it
+	firstId := strings.SplitN(id, PartIdSeparator, 2)[0]
+	parts, err := getLetters(
+		db,
+		"WHERE letters.author = $1 AND (letters.id = $2 OR LEFT(letters.id, LENGTH($3)) = $3)",
+		username,
+		firstId,
+		firstId+PartIdSeparator,
+	)
+	if err != nil {
+		return Letter{}, err
+	}
+
+	// Every other part is tried even if one of them can't be cancelled any
+	// more, so that as little of the letter is mailed as possible
+	var partErr error
+	for _, part := range parts {
+		if part.Id == id || part.Status == LetterCancelled {
+			continue
+		}
+
+		_, err := cancelLetter(db, part)
+		if err != nil {
+			partErr = err
+		}
+	}
+
+	letter, err = cancelLetter(db, letter)
+	if err != nil {
+		return Letter{}, err
+	}
+	return letter, partErr
+}
+
+// cancelLetter cancels one letter, or one part of a split letter
+func cancelLetter(db *sql.DB, letter Letter) (Letter, error) {
+	id := letter.Id
+	if letter.Status == LetterQueued {
+		// The status check stops this racing with the dispatcher claiming the
+		// letter; if it lost, go on with the letter's new status
+		result, err := db.Exec(
+			"UPDATE letters SET status = $1, timeCancelled = $2 WHERE id = $3 AND status = $4",
+			LetterCancelled,
+			time.Now().UTC(),
+			id,
+			LetterQueued,
+		)
+		if err != nil {
+			return Letter{}, err
+		}
+
+		if cancelled, _ := result.RowsAffected(); cancelled > 0 {
+			return getLetter(db, id)
+		}
+
+		letter, err = getLetter(db, id)
+		if err != nil {
+			return Letter{}, err
+		}
+	}
+
+	if !letter.Cancellable {
+		return Letter{}, ErrLetterNotCancellable
+	} else if letter.Status == LetterSending {
+		return Letter{}, ErrLetterBeingSent
+	}
+
+	client, err := lob.NewClient(letter.LobEnvironment)
+	if err != nil {
+		return Letter{}, err
+	}
+
+	_, err = client.CancelLetter(letter.LobLetterId)
+	if lobErr, ok := err.(*lob.LobError); ok && !lobErr.IsRetryable() {
+		// Lob refuses once the letter has gone to print
+		return Letter{}, ErrLetterNotCancellable
+	} else if err != nil {
+		return Letter{}, err
+	}
+
+	_, err = db.Exec(
+		"UPDATE letters SET status = $1, timeCancelled = $2 WHERE id = $3",
+		LetterCancelled,
+		time.Now().UTC(),
+		id,
+	)
+	if err != nil {
+		return Letter{}, err
+	}
+
+	return getLetter(db, id)
+}
@@ -0,0 +1,143 @@
+package models
+
+import (
+	"strings"
+
+	"github.com/johnamadeo/intouchgo/i18n"
+	"github.com/johnamadeo/intouchgo/lob"
+	"github.com/johnamadeo/intouchgo/render"
+	"github.com/johnamadeo/intouchgo/utils"
+)
+
+// MailOptionsError lists every mail option of a letter that can't be used
+// for its recipient
+type MailOptionsError struct {
+	Errors []utils.FieldError
+}
+
+func (e *MailOptionsError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, fieldError := range e.Errors {
+		messages[i] = fieldError.Message
+	}
+	return "Invalid mail options: " + strings.Join(messages, "; ")
+}
+
+// applyMailOptions fills in the defaults for any mail option the letter
+// leaves out and checks the rest, including the stationery theme and text
+// format, against Lob's rules and the recipient facility's. Letters go
+// usps_standard, black and white, as plain text in the plain theme with the
+// address on the first page unless asked otherwise, and double-sided
+// wherever the facility accepts it. Colored themes are always printed in
+// color. Cards set their own options, see applyCardOptions.
+func applyMailOptions(letter Letter, facility Facility) (Letter, error) {
+	errs := []utils.FieldError{}
+
+	if letter.CardId != "" {
+		var cardErrs []utils.FieldError
+		var err error
+		letter, cardErrs, err = applyCardOptions(letter, facility)
+		if err != nil {
+			return letter, err
+		}
+		errs = append(errs, cardErrs...)
+	}
+
+	if letter.MailType == "" {
+		letter.MailType = lob.USPSStandard
+	}
+	if letter.AddressPlacement == "" {
+		letter.AddressPlacement = lob.AddressPlacementTopFirstPage
+	}
+	if letter.ThemeId == "" {
+		letter.ThemeId = render.DefaultThemeId
+	}
+	if letter.Locale == "" {
+		letter.Locale = i18n.DefaultLocale
+	}
+	if letter.Format == "" {
+		letter.Format = render.FormatPlain
+	}
+	if letter.LargePrint == nil {
+		largePrint := false
+		letter.LargePrint = &largePrint
+	}
+	if letter.DoubleSided == nil {
+		doubleSided := facility.AllowsDoubleSided
+		letter.DoubleSided = &doubleSided
+	}
+
+	switch letter.MailType {
+	case lob.USPSStandard, lob.USPSFirstClass:
+	default:
+		errs = append(errs, newFieldError("mailType", "field.mailType"))
+	}
+
+	switch letter.ExtraService {
+	case "":
+	case lob.ExtraServiceCertified, lob.ExtraServiceCertifiedReturnReceipt, lob.ExtraServiceRegistered:
+		if letter.MailType != lob.USPSFirstClass {
+			errs = append(errs, newFieldError("extraService", "field.extraServiceFirstClass"))
+		} else if !containsString(facility.AllowedExtraServices, letter.ExtraService) {
+			errs = append(errs, newFieldError("extraService", "field.extraServiceNotAccepted", facility.Name, letter.ExtraService))
+		}
+	default:
+		errs = append(errs, newFieldError("extraService", "field.extraService"))
+	}
+
+	switch letter.AddressPlacement {
+	case lob.AddressPlacementTopFirstPage, lob.AddressPlacementInsertBlankPage:
+	default:
+		errs = append(errs, newFieldError("addressPlacement", "field.addressPlacement"))
+	}
+
+	if letter.Color && !facility.AllowsColor {
+		errs = append(errs, newFieldError("color", "field.colorNotAccepted", facility.Name))
+	}
+
+	if *letter.DoubleSided && !facility.AllowsDoubleSided {
+		errs = append(errs, newFieldError("doubleSided", "field.doubleSidedNotAccepted", facility.Name))
+	}
+
+	if !render.ValidFormat(letter.Format) {
+		errs = append(errs, newFieldError("format", "field.format"))
+	} else if letter.Format == render.FormatMarkdown {
+		if err := render.CheckMarkdown(letter.Text); err == render.ErrImageInText {
+			errs = append(errs, newFieldError("text", "field.imageInText"))
+		} else if err != nil {
+			errs = append(errs, newFieldError("text", "field.unsafeMarkup"))
+		}
+	}
+
+	if !i18n.IsSupported(letter.Locale) {
+		errs = append(errs, newFieldError("locale", "field.locale", strings.Join(i18n.Locales(), ", ")))
+	}
+
+	theme, err := render.GetTheme(letter.ThemeId)
+	if err == render.ErrThemeNotFound {
+		errs = append(errs, newFieldError("themeId", "field.unknownTheme", letter.ThemeId))
+	} else if err != nil {
+		return letter, err
+	} else if theme.UsesColor && !facility.AllowsColor {
+		errs = append(errs, newFieldError("themeId", "field.colorThemeNotAccepted", facility.Name, theme.Name))
+	} else if theme.HeavyImages && !facility.AllowsImages {
+		errs = append(errs, newFieldError("themeId", "field.imageThemeNotAccepted", facility.Name, theme.Name))
+	} else if theme.UsesColor {
+		// Colored stationery has to be printed, and paid for, in color
+		letter.Color = true
+	}
+
+	if len(errs) > 0 {
+		return letter, &MailOptionsError{Errors: errs}
+	}
+	return letter, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
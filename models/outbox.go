@@ -0,0 +1,335 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/johnamadeo/intouchgo/lob"
+)
+
+const (
+	DispatchBatchSize   = 10
+	MaxDispatchAttempts = 10
+	MaxDispatchBackoff  = time.Hour
+
+	// A letter that has been "sending" for longer than this was most likely
+	// claimed by a process that crashed before recording the outcome
+	DispatchLease = 5 * time.Minute
+)
+
+var dispatchSignal = make(chan struct{}, 1)
+
+// RunLetterDispatcher sends queued letters to Lob until stop is closed. It
+// wakes up every interval, and also straight away whenever a letter is
+// queued. Letters left in flight by a previous crash are recovered first.
+func RunLetterDispatcher(interval time.Duration, stop <-chan struct{}) {
+	err := RecoverInFlightLetters()
+	if err != nil {
+		fmt.Println("Error recovering in-flight letters: " + err.Error())
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := DispatchQueuedLetters()
+		if err != nil {
+			fmt.Println("Error dispatching letters: " + err.Error())
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			err := RecoverInFlightLetters()
+			if err != nil {
+				fmt.Println("Error recovering in-flight letters: " + err.Error())
+			}
+		case <-dispatchSignal:
+		}
+	}
+}
+
+func notifyLetterDispatcher() {
+	select {
+	case dispatchSignal <- struct{}{}:
+	default:
+	}
+}
+
+// DispatchQueuedLetters claims queued letters that are due and sends each of
+// them to Lob, until there are none left.
+func DispatchQueuedLetters() error {
+	db, err := getDBConnection()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	for {
+		ids, err := claimQueuedLetters(db)
+		if err != nil {
+			return err
+		}
+
+		if len(ids) == 0 {
+			return nil
+		}
+
+		for _, id := range ids {
+			err := dispatchLetter(db, id)
+			if err != nil {
+				fmt.Println("Error dispatching letter " + id + ": " + err.Error())
+			}
+		}
+	}
+}
+
+// SKIP LOCKED lets several dispatchers (one per web dyno) run at once without
+// claiming the same letter twice
+func claimQueuedLetters(db *sql.DB) ([]string, error) {
+	ids := []string{}
+
+	rows, err := db.Query(
+		"UPDATE letters "+
+			"SET status = $1, attempts = attempts + 1, timeDispatchStarted = now() "+
+			"WHERE id IN ("+
+			"SELECT id FROM letters WHERE status = $2 AND timeNextAttempt <= now() "+
+			"ORDER BY timeQueued LIMIT $3 FOR UPDATE SKIP LOCKED"+
+			") RETURNING id",
+		LetterSending,
+		LetterQueued,
+		DispatchBatchSize,
+	)
+	if err != nil {
+		return ids, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+func dispatchLetter(db *sql.DB, id string) error {
+	letter, err := getLetter(db, id)
+	if err != nil {
+		return err
+	}
+
+	response, err := sendLetterToLob(letter, letter.LobEnvironment)
+	if err != nil {
+		return recordDispatchFailure(db, id, err)
+	}
+
+	err = markLetterSent(db, id, response)
+	if err != nil {
+		return err
+	}
+
+	// Lob may not have rendered the letter yet, in which case the previews
+	// are stored when its rendered webhook arrives
+	storeLetterPreviewsInBackground(id, response)
+	return nil
+}
+
+// markLetterSent records what Lob returned for a letter. A webhook may have
+// moved the letter past sent already, in which case its status is kept.
+func markLetterSent(db *sql.DB, id string, response lob.LobLetter) error {
+	_, err := db.Exec(
+		"UPDATE letters "+
+			"SET status = CASE WHEN status = $1 THEN $2 ELSE status END, "+
+			"lobLetterId = $3, timeDeliveredEstimate = $4, cost = $5, heldByLob = $6, lastError = NULL, timeDispatchStarted = NULL "+
+			"WHERE id = $7",
+		LetterSending,
+		LetterSent,
+		response.Id,
+		getLobDeliveryDate(response.ExpectedDeliveryDate),
+		getLobPrice(response),
+		isHeldByLob(response),
+		id,
+	)
+
+	return err
+}
+
+// Errors that might go away on their own put the letter back in the queue
+// with exponential backoff; anything else, like Lob rejecting the address,
+// fails the letter so that it isn't retried forever.
+func recordDispatchFailure(db *sql.DB, id string, dispatchErr error) error {
+	var attempts int
+	err := db.QueryRow("SELECT attempts FROM letters WHERE id = $1", id).Scan(&attempts)
+	if err != nil {
+		return err
+	}
+
+	status := LetterQueued
+	if !isTransientDispatchError(dispatchErr) || attempts >= MaxDispatchAttempts {
+		status = LetterFailed
+	}
+
+	backoff := time.Minute << uint(attempts)
+	if backoff > MaxDispatchBackoff || backoff <= 0 {
+		backoff = MaxDispatchBackoff
+	}
+
+	_, err = db.Exec(
+		"UPDATE letters "+
+			"SET status = $1, lastError = $2, timeNextAttempt = $3, timeDispatchStarted = NULL "+
+			"WHERE id = $4",
+		status,
+		dispatchErr.Error(),
+		time.Now().Add(backoff),
+		id,
+	)
+	if err != nil {
+		return err
+	}
+
+	return dispatchErr
+}
+
+func isTransientDispatchError(err error) bool {
+	if err == ErrMixedEnvironments || err == ErrNoLobAddress || err == ErrInmateNotFound ||
+		err == ErrInvalidRecipientFormat || err == ErrRecipientNameTooLong || err == ErrReturnAddressNotFound {
+		return false
+	}
+
+	if lobErr, ok := err.(*lob.LobError); ok {
+		return lobErr.IsRetryable()
+	}
+
+	// Network errors, timeouts, an open circuit breaker and errors talking to
+	// our own database or Auth0 are all worth another try
+	return true
+}
+
+// RecoverInFlightLetters finds letters whose dispatch was interrupted, e.g.
+// because the dyno restarted after sending a letter to Lob but before saving
+// the result. Lob is asked whether it has a letter tagged with our letter
+// id: if so the letter is marked sent, otherwise it is queued again. Queuing
+// again is safe because the retry reuses the letter id as idempotency key.
+// Postcards, which are sent straight away rather than queued, are recovered
+// the same way.
+func RecoverInFlightLetters() error {
+	db, err := getDBConnection()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(
+		"SELECT id, lobEnvironment FROM letters WHERE status = $1 AND timeDispatchStarted < $2",
+		LetterSending,
+		time.Now().Add(-DispatchLease),
+	)
+	if err != nil {
+		return err
+	}
+
+	environments := make(map[string]string)
+	for rows.Next() {
+		var id, lobEnvironment string
+		if err := rows.Scan(&id, &lobEnvironment); err != nil {
+			rows.Close()
+			return err
+		}
+		environments[id] = lobEnvironment
+	}
+	rows.Close()
+
+	for id, lobEnvironment := range environments {
+		err := recoverLetter(db, id, lobEnvironment)
+		if err != nil {
+			fmt.Println("Error recovering letter " + id + ": " + err.Error())
+		}
+	}
+
+	return recoverInFlightPostcards(db)
+}
+
+func recoverLetter(db *sql.DB, id string, lobEnvironment string) error {
+	lobLetter, found, err := findLobLetter(id, lobEnvironment)
+	if err != nil {
+		return err
+	}
+
+	if found {
+		fmt.Println("Recovered letter " + id + " as " + lobLetter.Id)
+		return markLetterSent(db, id, lobLetter)
+	}
+
+	_, err = db.Exec(
+		"UPDATE letters SET status = $1, timeNextAttempt = now(), timeDispatchStarted = NULL "+
+			"WHERE id = $2 AND status = $3",
+		LetterQueued,
+		id,
+		LetterSending,
+	)
+	return err
+}
+
+// findLobLetter looks up the Lob letter tagged with our letter id
+func findLobLetter(id string, lobEnvironment string) (lob.LobLetter, bool, error) {
+	client, err := lob.NewClient(lobEnvironment)
+	if err != nil {
+		return lob.LobLetter{}, false, err
+	}
+
+	list, err := client.ListLetters(lob.ListParams{
+		Limit:    1,
+		Metadata: map[string]string{LetterIdMetadataKey: id},
+	})
+	if err != nil {
+		return lob.LobLetter{}, false, err
+	}
+
+	if len(list.Data) == 0 {
+		return lob.LobLetter{}, false, nil
+	}
+	return list.Data[0], true, nil
+}
+
+// The letter has been sent whether or not Lob's delivery estimate can be
+// read, so nil is stored rather than failing and sending it again
+func getLobDeliveryDate(date string) interface{} {
+	if date == "" {
+		return nil
+	}
+
+	dbDate, err := lob.LobDateToDBDate(date)
+	if err != nil {
+		fmt.Println("Error reading Lob delivery date " + date + ": " + err.Error())
+		return nil
+	}
+	return dbDate
+}
+
+// Lob holds a letter until its send date only if it was given one; without
+// it, the send date Lob reports is when the letter was created
+func isHeldByLob(response lob.LobLetter) bool {
+	sendDate, err := time.Parse(time.RFC3339, response.SendDate)
+	if err != nil {
+		return false
+	}
+
+	created, err := time.Parse(time.RFC3339, response.DateCreated)
+	return err == nil && sendDate.After(created)
+}
+
+// Lob reports the price as a decimal string; nil is stored if it didn't
+func getLobPrice(response lob.LobLetter) interface{} {
+	price, err := strconv.ParseFloat(response.Price, 64)
+	if err != nil {
+		return nil
+	}
+	return price
+}
@@ -0,0 +1,355 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/johnamadeo/intouchgo/blob"
+	"github.com/johnamadeo/intouchgo/photos"
+	"github.com/johnamadeo/intouchgo/render"
+	"github.com/johnamadeo/intouchgo/utils"
+	"github.com/lib/pq"
+)
+
+const (
+	// Lob downloads the photos in a letter from here when it renders it
+	PublicURLEnv     = "PUBLIC_URL"
+	DefaultPublicURL = "https://intouch-android-backend.herokuapp.com"
+
+	// Print URLs are signed with this secret and stop working after
+	// PhotoURLLifetime, which leaves Lob enough time to render the letter
+	PhotoURLSecretEnv = "PHOTO_URL_SECRET"
+	PhotoURLLifetime  = 7 * 24 * time.Hour
+)
+
+var ErrPhotoNotFound = errors.New("No photo with requested id found.")
+var ErrPhotoLinkInvalid = errors.New("The photo link is invalid or has expired.")
+
+// Photo is a photo uploaded to be printed in the user's letters. Photos are
+// uploaded before the letter is sent and attached to it by id.
+type Photo struct {
+	Id          string `json:"id"`
+	Size        string `json:"size"`
+	Grayscale   bool   `json:"grayscale"`
+	Width       int    `json:"width"` // Of the print, in pixels at 300 DPI
+	Height      int    `json:"height"`
+	TimeCreated string `json:"timeCreated"`
+
+	originalKey string
+	printKey    string
+}
+
+// UploadPhoto runs an uploaded image through the photo pipeline and stores
+// both the cleaned up original and the print. The uploaded bytes themselves
+// are never stored, so neither is the EXIF data in them.
+func UploadPhoto(username string, data []byte, size string, grayscale bool) (Photo, error) {
+	processed, err := photos.Process(data, size, grayscale)
+	if err != nil {
+		return Photo{}, err
+	}
+
+	store, err := getBlobStore()
+	if err != nil {
+		return Photo{}, err
+	}
+
+	photo := Photo{
+		Id:        uuid.New().String(),
+		Size:      size,
+		Grayscale: grayscale,
+		Width:     processed.Width,
+		Height:    processed.Height,
+	}
+	photo.originalKey = "photos/" + photo.Id + "/original"
+	photo.printKey = "photos/" + photo.Id + "/print"
+
+	err = store.Put(photo.originalKey, photos.ContentType, processed.Original)
+	if err != nil {
+		return Photo{}, err
+	}
+
+	err = store.Put(photo.printKey, photos.ContentType, processed.Print)
+	if err != nil {
+		deletePhotoBlobs([]string{photo.originalKey})
+		return Photo{}, err
+	}
+
+	db, err := getDBConnection()
+	if err != nil {
+		return Photo{}, err
+	}
+	defer db.Close()
+
+	err = db.QueryRow(
+		"INSERT INTO photos (id, username, size, grayscale, width, height, originalKey, printKey) "+
+			"VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING TO_CHAR(timeCreated, 'MM/dd/yy')",
+		photo.Id,
+		username,
+		photo.Size,
+		photo.Grayscale,
+		photo.Width,
+		photo.Height,
+		photo.originalKey,
+		photo.printKey,
+	).Scan(&photo.TimeCreated)
+	if err != nil {
+		deletePhotoBlobs([]string{photo.originalKey, photo.printKey})
+		return Photo{}, err
+	}
+
+	return photo, nil
+}
+
+func GetPhotos(username string) ([]Photo, error) {
+	db, err := getDBConnection()
+	if err != nil {
+		return []Photo{}, err
+	}
+	defer db.Close()
+
+	return getPhotos(db, "WHERE username = $1", username)
+}
+
+// GetPhotoPrint returns the print of a photo. It is served without
+// authentication so that Lob can download it, so the link to it must carry
+// an unexpired signature from getPhotoPages.
+func GetPhotoPrint(id string, expires string, signature string) (blob.Blob, error) {
+	err := verifyPhotoURL(id, expires, signature, time.Now())
+	if err != nil {
+		return blob.Blob{}, err
+	}
+
+	db, err := getDBConnection()
+	if err != nil {
+		return blob.Blob{}, err
+	}
+	defer db.Close()
+
+	found, err := getPhotos(db, "WHERE id = $1", id)
+	if err != nil {
+		return blob.Blob{}, err
+	}
+
+	if len(found) == 0 {
+		return blob.Blob{}, ErrPhotoNotFound
+	}
+
+	store, err := getBlobStore()
+	if err != nil {
+		return blob.Blob{}, err
+	}
+
+	print, err := store.Get(found[0].printKey)
+	if err == blob.ErrNotFound {
+		return blob.Blob{}, ErrPhotoNotFound
+	}
+	return print, err
+}
+
+// getLetterPhotos returns the photos with the given ids, in that order,
+// making sure they were all uploaded by the author. The author must be the
+// authorized user, since their prints get signed links that anyone can open.
+func getLetterPhotos(author string, ids []string) ([]Photo, error) {
+	if len(ids) == 0 {
+		return []Photo{}, nil
+	}
+
+	if author == "" {
+		return []Photo{}, ErrPhotoNotFound
+	}
+
+	db, err := getDBConnection()
+	if err != nil {
+		return []Photo{}, err
+	}
+	defer db.Close()
+
+	found, err := getPhotos(db, "WHERE username = $1 AND id = ANY($2)", author, pq.Array(ids))
+	if err != nil {
+		return []Photo{}, err
+	}
+
+	byId := make(map[string]Photo)
+	for _, photo := range found {
+		byId[photo.Id] = photo
+	}
+
+	letterPhotos := []Photo{}
+	for _, id := range ids {
+		photo, ok := byId[id]
+		if !ok {
+			return []Photo{}, ErrPhotoNotFound
+		}
+		letterPhotos = append(letterPhotos, photo)
+	}
+
+	return letterPhotos, nil
+}
+
+// checkLetterPhotos applies the facility's rules on photos to a letter
+func checkLetterPhotos(letterPhotos []Photo, facility Facility) error {
+	errs := []utils.FieldError{}
+
+	if len(letterPhotos) > 0 && !facility.AllowsImages {
+		errs = append(errs, newFieldError("photoIds", "field.photosNotAccepted", facility.Name))
+	} else if len(letterPhotos) > facility.MaxPhotos {
+		errs = append(errs, newFieldError("photoIds", "field.tooManyPhotos", facility.Name, facility.MaxPhotos))
+	}
+
+	for _, photo := range letterPhotos {
+		if !containsString(facility.AllowedPhotoSizes, photo.Size) {
+			errs = append(errs, newFieldError("photoIds",
+				"field.photoSizeNotAccepted", facility.Name, photo.Size, strings.Join(facility.AllowedPhotoSizes, ", ")))
+			break
+		}
+	}
+
+	if len(errs) > 0 {
+		return &MailOptionsError{Errors: errs}
+	}
+	return nil
+}
+
+// getPhotoPages returns how the photos are laid out in the letter, each on
+// its own page at its print size, with a signed link to the print
+func getPhotoPages(letterPhotos []Photo) ([]render.PhotoPage, error) {
+	publicURL := DefaultPublicURL
+	if value, ok := os.LookupEnv(PublicURLEnv); ok && value != "" {
+		publicURL = strings.TrimSuffix(value, "/")
+	}
+	expires := strconv.FormatInt(time.Now().Add(PhotoURLLifetime).Unix(), 10)
+
+	pages := []render.PhotoPage{}
+	for _, photo := range letterPhotos {
+		width, height := float64(photo.Width)/photos.PrintDPI, float64(photo.Height)/photos.PrintDPI
+
+		// Full page photos smaller than the page are scaled up to fill it
+		// when printed, rather than stored larger
+		if photo.Size == photos.SizeFull && photo.Width > 0 && photo.Height > 0 {
+			pageWidth, pageHeight := photos.GetPrintInches(photos.SizeFull, false)
+			scale := math.Min(float64(pageWidth)/10/width, float64(pageHeight)/10/height)
+			width, height = width*scale, height*scale
+		}
+
+		signature, err := signPhotoURL(photo.Id, expires)
+		if err != nil {
+			return []render.PhotoPage{}, err
+		}
+
+		pages = append(pages, render.PhotoPage{
+			URL:    publicURL + "/photos/" + photo.Id + "/print?expires=" + expires + "&signature=" + signature,
+			Width:  formatInches(width),
+			Height: formatInches(height),
+		})
+	}
+	return pages, nil
+}
+
+// signPhotoURL returns the signature of the link to a photo's print, an
+// HMAC of its id and the Unix time it expires at
+func signPhotoURL(id string, expires string) (string, error) {
+	secret, ok := os.LookupEnv(PhotoURLSecretEnv)
+	if !ok || secret == "" {
+		return "", errors.New("Photo URL secret doesn't exist as an environment variable")
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(id + "." + expires))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// verifyPhotoURL checks that the link to a photo's print was signed by
+// signPhotoURL and hasn't expired
+func verifyPhotoURL(id string, expires string, signature string, now time.Time) error {
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || now.Unix() > expiresAt {
+		return ErrPhotoLinkInvalid
+	}
+
+	expected, err := signPhotoURL(id, expires)
+	if err != nil {
+		return err
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return ErrPhotoLinkInvalid
+	}
+	return nil
+}
+
+// deletePhotoBlobs removes photo files once they are no longer needed.
+// Errors are only logged, like for previews.
+func deletePhotoBlobs(keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+
+	store, err := getBlobStore()
+	if err != nil {
+		fmt.Println("Error deleting photos: " + err.Error())
+		return
+	}
+
+	for _, key := range keys {
+		err := store.Delete(key)
+		if err != nil {
+			fmt.Println("Error deleting photo " + key + ": " + err.Error())
+		}
+	}
+}
+
+func getPhotos(db *sql.DB, where string, args ...interface{}) ([]Photo, error) {
+	found := []Photo{}
+
+	fields := []string{
+		"id",
+		"size",
+		"grayscale",
+		"width",
+		"height",
+		"originalKey",
+		"printKey",
+		"TO_CHAR(timeCreated, 'MM/dd/yy')",
+	}
+
+	rows, err := db.Query("SELECT "+strings.Join(fields, ", ")+" FROM photos "+where+" ORDER BY timeCreated", args...)
+	if err != nil {
+		return found, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		photo := Photo{}
+		err := rows.Scan(
+			&photo.Id,
+			&photo.Size,
+			&photo.Grayscale,
+			&photo.Width,
+			&photo.Height,
+			&photo.originalKey,
+			&photo.printKey,
+			&photo.TimeCreated,
+		)
+		if err != nil {
+			return found, err
+		}
+
+		found = append(found, photo)
+	}
+
+	return found, rows.Err()
+}
+
+func formatInches(inches float64) string {
+	return strconv.FormatFloat(inches, 'f', 2, 64) + "in"
+}
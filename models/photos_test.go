@@ -0,0 +1,58 @@
+package models
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerifyPhotoURL(t *testing.T) {
+	t.Setenv(PhotoURLSecretEnv, "photo-secret")
+
+	now := time.Date(2018, 10, 8, 9, 30, 0, 0, time.UTC)
+	id := "0f6e1c5a-8d7b-4f5e-9a53-2b1c5f0e7d4a"
+	expires := strconv.FormatInt(now.Add(PhotoURLLifetime).Unix(), 10)
+	signature, err := signPhotoURL(id, expires)
+	if err != nil {
+		t.Fatalf("signPhotoURL() = %v", err)
+	}
+	otherSignature, err := signPhotoURL("1a2b3c4d-8d7b-4f5e-9a53-2b1c5f0e7d4a", expires)
+	if err != nil {
+		t.Fatalf("signPhotoURL() = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		id        string
+		expires   string
+		signature string
+		now       time.Time
+		want      error
+	}{
+		{"valid", id, expires, signature, now, nil},
+		{"valid until it expires", id, expires, signature, now.Add(PhotoURLLifetime), nil},
+		{"expired", id, expires, signature, now.Add(PhotoURLLifetime + time.Second), ErrPhotoLinkInvalid},
+		{"another photo's signature", id, expires, otherSignature, now, ErrPhotoLinkInvalid},
+		{"expiry pushed back", id, strconv.FormatInt(now.Add(2*PhotoURLLifetime).Unix(), 10), signature, now, ErrPhotoLinkInvalid},
+		{"no signature", id, expires, "", now, ErrPhotoLinkInvalid},
+		{"no expiry", id, "", signature, now, ErrPhotoLinkInvalid},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := verifyPhotoURL(test.id, test.expires, test.signature, test.now)
+			if err != test.want {
+				t.Errorf("verifyPhotoURL() = %v, want %v", err, test.want)
+			}
+		})
+	}
+}
+
+func TestSignPhotoURLWithoutSecret(t *testing.T) {
+	t.Setenv(PhotoURLSecretEnv, "")
+
+	_, err := signPhotoURL("0f6e1c5a-8d7b-4f5e-9a53-2b1c5f0e7d4a", "1539000000")
+	if err == nil {
+		t.Error("signPhotoURL() without a secret = nil error, want an error")
+	}
+}
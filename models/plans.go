@@ -0,0 +1,55 @@
+package models
+
+import (
+	"database/sql"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	PlanFree    = "free"
+	PlanPartner = "partner" // Organizations that write to many inmates
+
+	// e.g. MAX_LETTER_PAGES_FREE=4 overrides the free plan's page limit
+	MaxLetterPagesEnvPrefix = "MAX_LETTER_PAGES_"
+)
+
+var defaultPlanMaxPages = map[string]int{
+	PlanFree:    6,
+	PlanPartner: 2 * MaxLobLetterSheets,
+}
+
+// GetUserPlan returns the plan of the user; everyone not listed in
+// user_plans is on the free plan
+func GetUserPlan(username string) (string, error) {
+	db, err := getDBConnection()
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	var plan string
+	err = db.QueryRow("SELECT plan FROM user_plans WHERE username = $1", username).Scan(&plan)
+	if err == sql.ErrNoRows {
+		return PlanFree, nil
+	} else if err != nil {
+		return "", err
+	}
+	return plan, nil
+}
+
+// getPlanMaxPages returns the most pages a letter can have on the plan
+func getPlanMaxPages(plan string) int {
+	pages, ok := defaultPlanMaxPages[plan]
+	if !ok {
+		pages = defaultPlanMaxPages[PlanFree]
+	}
+
+	if value, ok := os.LookupEnv(MaxLetterPagesEnvPrefix + strings.ToUpper(plan)); ok {
+		if parsed, err := strconv.Atoi(value); err == nil && parsed > 0 {
+			pages = parsed
+		}
+	}
+	return pages
+}
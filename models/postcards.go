@@ -0,0 +1,580 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/johnamadeo/intouchgo/i18n"
+	"github.com/johnamadeo/intouchgo/lob"
+	"github.com/johnamadeo/intouchgo/render"
+	"github.com/johnamadeo/intouchgo/utils"
+)
+
+// What Lob charges us for a postcard of each size, in cents
+var postcardPrices = map[string]int{
+	lob.PostcardSize4x6:  45,
+	lob.PostcardSize6x9:  65,
+	lob.PostcardSize6x11: 85,
+}
+
+// Maps Lob postcard event types to the status they put the postcard in.
+// Postcards go through the same statuses as letters after they're sent.
+var lobPostcardEventStatuses = map[string]string{
+	"postcard.rendered_pdf":           LetterRendered,
+	"postcard.rendered_thumbnails":    LetterRendered,
+	"postcard.deleted":                LetterCancelled,
+	"postcard.mailed":                 LetterMailed,
+	"postcard.in_transit":             LetterInTransit,
+	"postcard.in_local_area":          LetterInLocalArea,
+	"postcard.processed_for_delivery": LetterProcessedForDelivery,
+	"postcard.re-routed":              LetterReRouted,
+	"postcard.returned_to_sender":     LetterReturnedToSender,
+}
+
+// Lob keeps idempotency keys for a day, so a postcard that still can't be
+// sent after that is failed rather than risk mailing it twice
+const MaxPostcardRecoveryAge = 24 * time.Hour
+
+var (
+	ErrPostcardExists   = errors.New("A postcard with that id already exists")
+	ErrPostcardNotFound = errors.New("No postcard with requested id found")
+)
+
+// Postcard is a short message with an optional photo on the front. Postcards
+// are cheaper than letters and some facilities accept them more readily.
+type Postcard struct {
+	Id                    string `json:"id"`
+	Author                string `json:"author"`
+	Recipient             string `json:"recipient"`
+	RecipientId           string `json:"recipientId"`
+	Message               string `json:"message"`
+	Size                  string `json:"size"`         // 4x6, 6x9 or 6x11; 4x6 if empty
+	FrontPhotoId          string `json:"frontPhotoId"` // An uploaded photo, or "" for the plain front
+	ReturnAddressId       string `json:"returnAddressId"`
+	Locale                string `json:"locale"`
+	LobPostcardId         string `json:"lobPostcardId"`
+	LobEnvironment        string `json:"lobEnvironment"`
+	Status                string `json:"status"`
+	TimeSent              string `json:"timeSent"`
+	TimeDeliveredEstimate string `json:"timeDeliveredEstimate"`
+	EstimatedCost         string `json:"estimatedCost"`
+
+	timeSent time.Time
+}
+
+// SendPostcard checks the postcard against the facility's rules and sends
+// it to Lob straight away. Sending a postcard whose id the same author has
+// already sent returns the existing postcard instead of sending it twice,
+// after recovering it if it was left in flight.
+func SendPostcard(postcard Postcard) (Postcard, error) {
+	if postcard.Size == "" {
+		postcard.Size = lob.PostcardSize4x6
+	}
+	if postcard.Locale == "" {
+		postcard.Locale = i18n.DefaultLocale
+	}
+
+	lobEnvironment, err := GetLobEnvironmentForUser(postcard.Author)
+	if err != nil {
+		return Postcard{}, err
+	}
+
+	if postcard.ReturnAddressId != "" {
+		err = checkReturnAddress(postcard.Author, postcard.ReturnAddressId)
+		if err != nil {
+			return Postcard{}, err
+		}
+	}
+
+	facility, err := getInmateFacility(postcard.RecipientId)
+	if err != nil {
+		return Postcard{}, err
+	}
+
+	frontPhotos, err := getLetterPhotos(postcard.Author, nonEmptyStrings(postcard.FrontPhotoId))
+	if err != nil {
+		return Postcard{}, err
+	}
+
+	err = validatePostcard(postcard, facility, frontPhotos)
+	if err != nil {
+		return Postcard{}, err
+	}
+
+	addresses, err := getMailAddresses(postcard.RecipientId, postcard.ReturnAddressId, lobEnvironment)
+	if err != nil {
+		return Postcard{}, err
+	}
+
+	authorName, err := GetUserRealName(postcard.Author)
+	if err != nil {
+		return Postcard{}, err
+	}
+
+	postcard.LobEnvironment = lobEnvironment
+	postcard.Status = LetterSending
+	postcard.timeSent = time.Now().UTC().Truncate(time.Second)
+
+	err = createPostcardInDB(postcard)
+	if err == ErrPostcardExists {
+		existing, getErr := getPostcard(postcard.Id)
+		if getErr != nil {
+			return Postcard{}, getErr
+		}
+
+		if existing.Author != postcard.Author {
+			return Postcard{}, ErrPostcardExists
+		}
+
+		if existing.Status == LetterSending && time.Since(existing.timeSent) > DispatchLease {
+			err = recoverPostcard(existing)
+			if err != nil && !isTransientDispatchError(err) {
+				return Postcard{}, err
+			}
+			return getPostcard(postcard.Id)
+		}
+		return existing, nil
+	} else if err != nil {
+		return Postcard{}, err
+	}
+
+	// Lob may have created the postcard even if the request timed out or
+	// failed with a server error, so only a definite rejection fails it. The
+	// postcard stays "sending" otherwise, until RecoverInFlightLetters asks
+	// Lob about it.
+	response, err := sendPostcardToLob(postcard, authorName, frontPhotos, addresses)
+	if err != nil && isTransientDispatchError(err) {
+		fmt.Println("Error sending postcard " + postcard.Id + ", leaving it for recovery: " + err.Error())
+		return getInFlightPostcard(postcard), nil
+	} else if err != nil {
+		markPostcardFailed(postcard.Id, err)
+		return Postcard{}, err
+	}
+
+	saveMailAddress(postcard.RecipientId, lobEnvironment, addresses, response.To.Id)
+
+	// Lob has the postcard now, so failing here would have the author send it
+	// again. It stays "sending" instead, until RecoverInFlightLetters finds it.
+	err = markPostcardSent(postcard.Id, response)
+	if err != nil {
+		fmt.Println("Error marking postcard " + postcard.Id + " sent: " + err.Error())
+		postcard.LobPostcardId = response.Id
+		return getInFlightPostcard(postcard), nil
+	}
+
+	return getPostcard(postcard.Id)
+}
+
+// getInFlightPostcard returns a postcard left "sending" for recovery as the
+// author should see it, from what was saved of it if that can be read
+func getInFlightPostcard(postcard Postcard) Postcard {
+	saved, err := getPostcard(postcard.Id)
+	if err == nil {
+		return saved
+	}
+
+	postcard.TimeSent = postcard.timeSent.Format("01/02/06")
+	postcard.EstimatedCost = formatCents(postcardPrices[postcard.Size])
+	return postcard
+}
+
+func GetPostcards(username string) ([]Postcard, error) {
+	db, err := getDBConnection()
+	if err != nil {
+		return []Postcard{}, err
+	}
+	defer db.Close()
+
+	return getPostcards(db, "WHERE postcards.author = $1", username)
+}
+
+func getPostcard(id string) (Postcard, error) {
+	db, err := getDBConnection()
+	if err != nil {
+		return Postcard{}, err
+	}
+	defer db.Close()
+
+	postcards, err := getPostcards(db, "WHERE postcards.id = $1", id)
+	if err != nil {
+		return Postcard{}, err
+	}
+
+	if len(postcards) == 0 {
+		return Postcard{}, ErrPostcardNotFound
+	}
+	return postcards[0], nil
+}
+
+// validatePostcard applies the facility's rules to a postcard. Lob always
+// prints postcards in color, so facilities that only accept black and white
+// mail can only be sent grayscale photos.
+func validatePostcard(postcard Postcard, facility Facility, frontPhotos []Photo) error {
+	errs := []utils.FieldError{}
+
+	if !facility.AllowsPostcards {
+		errs = append(errs, newFieldError("recipientId", "field.postcardsNotAccepted", facility.Name))
+	}
+
+	if !render.ValidPostcardSize(postcard.Size) {
+		errs = append(errs, newFieldError("size", "field.postcardSize"))
+	} else if strings.TrimSpace(postcard.Message) == "" {
+		errs = append(errs, newFieldError("message", "field.postcardMessageRequired"))
+	} else if maxLength := render.MaxPostcardMessage(postcard.Size); utf8.RuneCountInString(postcard.Message) > maxLength {
+		errs = append(errs, newFieldError("message", "field.postcardMessageTooLong", postcard.Size, maxLength))
+	}
+
+	if !i18n.IsSupported(postcard.Locale) {
+		errs = append(errs, newFieldError("locale", "field.locale", strings.Join(i18n.Locales(), ", ")))
+	}
+
+	for _, photo := range frontPhotos {
+		if !facility.AllowsImages {
+			errs = append(errs, newFieldError("frontPhotoId", "field.photosNotAccepted", facility.Name))
+		} else if !facility.AllowsColor && !photo.Grayscale {
+			errs = append(errs, newFieldError("frontPhotoId", "field.grayscalePhotoRequired", facility.Name))
+		}
+	}
+
+	if len(errs) > 0 {
+		return &MailOptionsError{Errors: errs}
+	}
+	return nil
+}
+
+func sendPostcardToLob(postcard Postcard, authorName string, frontPhotos []Photo, addresses mailAddresses) (lob.LobPostcard, error) {
+	var response lob.LobPostcard
+
+	data, err := render.NewPostcardData(
+		postcard.Locale,
+		authorName,
+		postcard.timeSent.Format("01/02/06"),
+		postcard.Message,
+		postcard.Size,
+	)
+	if err != nil {
+		return response, err
+	}
+
+	pages, err := getPhotoPages(frontPhotos)
+	if err != nil {
+		return response, err
+	}
+	if len(pages) > 0 {
+		data.FrontURL = pages[0].URL
+	}
+
+	front, back, err := render.RenderPostcardHTML(data)
+	if err != nil {
+		return response, err
+	}
+
+	request := lob.LobCreatePostcardRequest{
+		Description: "InTouch postcard " + postcard.Id,
+		To:          addresses.to,
+		From:        lob.AddressId(addresses.fromId),
+		Front:       front,
+		Back:        back,
+		Size:        postcard.Size,
+		Metadata: map[string]string{
+			PostcardIdMetadataKey: postcard.Id,
+			AppMetadataKey:        AppMetadataValue,
+		},
+	}
+
+	client, err := lob.NewClient(postcard.LobEnvironment)
+	if err != nil {
+		return response, err
+	}
+
+	return client.CreatePostcard(request, postcard.Id)
+}
+
+func createPostcardInDB(postcard Postcard) error {
+	db, err := getDBConnection()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec(
+		"INSERT INTO postcards (id, author, recipient, message, size, frontPhotoId, returnAddressId, locale, lobEnvironment, status, timeSent) "+
+			"VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), NULLIF($7, ''), $8, $9, $10, $11)",
+		postcard.Id,
+		postcard.Author,
+		postcard.RecipientId,
+		postcard.Message,
+		postcard.Size,
+		postcard.FrontPhotoId,
+		postcard.ReturnAddressId,
+		postcard.Locale,
+		postcard.LobEnvironment,
+		postcard.Status,
+		postcard.timeSent,
+	)
+
+	if isUniqueViolation(err) {
+		return ErrPostcardExists
+	}
+	return err
+}
+
+// markPostcardSent records what Lob returned for a postcard. A webhook may
+// have moved the postcard past sent already, in which case its status is kept.
+func markPostcardSent(id string, response lob.LobPostcard) error {
+	db, err := getDBConnection()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec(
+		"UPDATE postcards "+
+			"SET status = CASE WHEN status = $1 THEN $2 ELSE status END, "+
+			"lobPostcardId = $3, timeDeliveredEstimate = $4, lastError = NULL "+
+			"WHERE id = $5",
+		LetterSending,
+		LetterSent,
+		response.Id,
+		getLobDeliveryDate(response.ExpectedDeliveryDate),
+		id,
+	)
+	return err
+}
+
+// markPostcardFailed records why Lob didn't take the postcard. The author
+// sees the error and can send the postcard again with a new id.
+func markPostcardFailed(id string, sendErr error) {
+	db, err := getDBConnection()
+	if err != nil {
+		fmt.Println("Error marking postcard " + id + " failed: " + err.Error())
+		return
+	}
+	defer db.Close()
+
+	_, err = db.Exec(
+		"UPDATE postcards SET status = $1, lastError = $2 WHERE id = $3 AND status = $4",
+		LetterFailed,
+		sendErr.Error(),
+		id,
+		LetterSending,
+	)
+	if err != nil {
+		fmt.Println("Error marking postcard " + id + " failed: " + err.Error())
+	}
+}
+
+// recoverInFlightPostcards finds postcards whose sending was interrupted,
+// e.g. because the dyno restarted before Lob's response was saved
+func recoverInFlightPostcards(db *sql.DB) error {
+	postcards, err := getPostcards(
+		db,
+		"WHERE postcards.status = $1 AND postcards.timeSent < $2",
+		LetterSending,
+		time.Now().UTC().Add(-DispatchLease),
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, postcard := range postcards {
+		err := recoverPostcard(postcard)
+		if err != nil {
+			fmt.Println("Error recovering postcard " + postcard.Id + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// recoverPostcard asks Lob whether it has a postcard tagged with our
+// postcard id: if so the postcard is marked sent, otherwise it is sent
+// again. Sending again is safe because the postcard id is the idempotency key.
+func recoverPostcard(postcard Postcard) error {
+	client, err := lob.NewClient(postcard.LobEnvironment)
+	if err != nil {
+		return err
+	}
+
+	list, err := client.ListPostcards(lob.ListParams{
+		Limit:    1,
+		Metadata: map[string]string{PostcardIdMetadataKey: postcard.Id},
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(list.Data) > 0 {
+		fmt.Println("Recovered postcard " + postcard.Id + " as " + list.Data[0].Id)
+		return markPostcardSent(postcard.Id, list.Data[0])
+	}
+
+	// Errors that might go away on their own leave the postcard for the next
+	// recovery, until Lob would no longer recognize the idempotency key
+	response, err := resendPostcard(postcard)
+	if err != nil && (!isTransientDispatchError(err) || time.Since(postcard.timeSent) > MaxPostcardRecoveryAge) {
+		markPostcardFailed(postcard.Id, err)
+		return err
+	} else if err != nil {
+		return err
+	}
+	return markPostcardSent(postcard.Id, response)
+}
+
+// resendPostcard sends a postcard again from what was saved of it
+func resendPostcard(postcard Postcard) (lob.LobPostcard, error) {
+	authorName, err := GetUserRealName(postcard.Author)
+	if err != nil {
+		return lob.LobPostcard{}, err
+	}
+
+	frontPhotos, err := getLetterPhotos(postcard.Author, nonEmptyStrings(postcard.FrontPhotoId))
+	if err != nil {
+		return lob.LobPostcard{}, err
+	}
+
+	addresses, err := getMailAddresses(postcard.RecipientId, postcard.ReturnAddressId, postcard.LobEnvironment)
+	if err != nil {
+		return lob.LobPostcard{}, err
+	}
+
+	response, err := sendPostcardToLob(postcard, authorName, frontPhotos, addresses)
+	if err != nil {
+		return response, err
+	}
+
+	saveMailAddress(postcard.RecipientId, postcard.LobEnvironment, addresses, response.To.Id)
+	return response, nil
+}
+
+// recordPostcardEvent moves a postcard to the status a Lob webhook event
+// implies. Postcards have no timeline, so the event itself isn't kept.
+func recordPostcardEvent(event lob.LobEvent) error {
+	status, ok := lobPostcardEventStatuses[event.EventType.Id]
+	if !ok {
+		return nil
+	}
+
+	var lobPostcard lob.LobPostcard
+	err := json.Unmarshal(event.Body, &lobPostcard)
+	if err != nil {
+		return err
+	}
+
+	db, err := getDBConnection()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	var id, currentStatus string
+	err = tx.QueryRow(
+		"SELECT id, status FROM postcards WHERE id = $1 OR lobPostcardId = $2 LIMIT 1 FOR UPDATE",
+		lobPostcard.Metadata[PostcardIdMetadataKey],
+		lobPostcard.Id,
+	).Scan(&id, &currentStatus)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		fmt.Println("Ignoring " + event.EventType.Id + " event for unknown Lob postcard " + lobPostcard.Id)
+		return nil
+	} else if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if letterStatusRanks[status] > letterStatusRanks[currentStatus] {
+		_, err = tx.Exec(
+			"UPDATE postcards SET status = $1, lobPostcardId = COALESCE(lobPostcardId, $2) WHERE id = $3",
+			status,
+			lobPostcard.Id,
+			id,
+		)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func getPostcards(db *sql.DB, where string, args ...interface{}) ([]Postcard, error) {
+	postcards := []Postcard{}
+
+	fields := []string{
+		"postcards.id",
+		"postcards.author",
+		"CONCAT(inmates.firstName, ' ', inmates.lastName) AS recipient",
+		"postcards.recipient AS recipientId",
+		"postcards.message",
+		"postcards.size",
+		"COALESCE(postcards.frontPhotoId, '')",
+		"COALESCE(postcards.returnAddressId, '')",
+		"postcards.locale",
+		"COALESCE(postcards.lobPostcardId, '')",
+		"postcards.lobEnvironment",
+		"postcards.status",
+		"postcards.timeSent",
+		"COALESCE(TO_CHAR(postcards.timeDeliveredEstimate, 'MM/dd/yy'), '')",
+	}
+
+	rows, err := db.Query(
+		"SELECT "+strings.Join(fields, ", ")+" "+
+			"FROM postcards JOIN inmates ON postcards.recipient = inmates.id "+
+			where+" ORDER BY postcards.timeSent",
+		args...,
+	)
+	if err != nil {
+		return postcards, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		postcard := Postcard{}
+		err := rows.Scan(
+			&postcard.Id,
+			&postcard.Author,
+			&postcard.Recipient,
+			&postcard.RecipientId,
+			&postcard.Message,
+			&postcard.Size,
+			&postcard.FrontPhotoId,
+			&postcard.ReturnAddressId,
+			&postcard.Locale,
+			&postcard.LobPostcardId,
+			&postcard.LobEnvironment,
+			&postcard.Status,
+			&postcard.timeSent,
+			&postcard.TimeDeliveredEstimate,
+		)
+		if err != nil {
+			return postcards, err
+		}
+
+		postcard.TimeSent = postcard.timeSent.Format("01/02/06")
+		postcard.EstimatedCost = formatCents(postcardPrices[postcard.Size])
+		postcards = append(postcards, postcard)
+	}
+
+	return postcards, rows.Err()
+}
+
+// nonEmptyStrings returns the values that aren't ""
+func nonEmptyStrings(values ...string) []string {
+	nonEmpty := []string{}
+	for _, value := range values {
+		if value != "" {
+			nonEmpty = append(nonEmpty, value)
+		}
+	}
+	return nonEmpty
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/johnamadeo/intouchgo/i18n"
+	"github.com/johnamadeo/intouchgo/utils"
+)
+
+// Preferences are the settings a user chooses in the app. An empty Locale
+// means the user hasn't chosen one and gets the language of their device.
+type Preferences struct {
+	Locale string `json:"locale"`
+}
+
+func ValidatePreferences(preferences Preferences) []utils.FieldError {
+	errs := []utils.FieldError{}
+
+	if preferences.Locale != "" && !i18n.IsSupported(preferences.Locale) {
+		errs = append(errs, newFieldError("locale", "field.locale", strings.Join(i18n.Locales(), ", ")))
+	}
+
+	return errs
+}
+
+func GetPreferences(username string) (Preferences, error) {
+	db, err := getDBConnection()
+	if err != nil {
+		return Preferences{}, err
+	}
+	defer db.Close()
+
+	preferences := Preferences{}
+	err = db.QueryRow("SELECT locale FROM user_preferences WHERE username = $1", username).Scan(&preferences.Locale)
+	if err == sql.ErrNoRows {
+		return Preferences{}, nil
+	}
+	return preferences, err
+}
+
+func SavePreferences(username string, preferences Preferences) error {
+	db, err := getDBConnection()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if preferences.Locale == "" {
+		_, err = db.Exec("DELETE FROM user_preferences WHERE username = $1", username)
+		return err
+	}
+
+	_, err = db.Exec(
+		"INSERT INTO user_preferences (username, locale) VALUES ($1, $2) "+
+			"ON CONFLICT (username) DO UPDATE SET locale = EXCLUDED.locale, timeUpdated = now()",
+		username,
+		preferences.Locale,
+	)
+	return err
+}
@@ -0,0 +1,337 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/johnamadeo/intouchgo/blob"
+	"github.com/johnamadeo/intouchgo/lob"
+)
+
+const (
+	PreviewPDF    = "pdf"
+	PreviewSmall  = "small"
+	PreviewMedium = "medium"
+	PreviewLarge  = "large"
+)
+
+var (
+	ErrPreviewNotFound = errors.New("No preview with requested name found for the letter")
+
+	errPreviewsNotRendered = errors.New("Lob hasn't finished rendering the letter")
+
+	blobStore     blob.Store
+	blobStoreErr  error
+	blobStoreOnce sync.Once
+)
+
+// LetterPreviews lists where each stored preview of a letter can be fetched
+type LetterPreviews struct {
+	PDF   string        `json:"pdf"`
+	Pages []PagePreview `json:"pages"`
+}
+
+type PagePreview struct {
+	Page   int    `json:"page"`
+	Small  string `json:"small"`
+	Medium string `json:"medium"`
+	Large  string `json:"large"`
+}
+
+func getBlobStore() (blob.Store, error) {
+	blobStoreOnce.Do(func() {
+		blobStore, blobStoreErr = blob.NewStoreFromEnv()
+	})
+	return blobStore, blobStoreErr
+}
+
+// Previews are named "pdf" for the whole letter and "<page>-<size>" for the
+// thumbnails of each page, counting pages from 1
+func getThumbnailName(page int, size string) string {
+	return strconv.Itoa(page) + "-" + size
+}
+
+func getPreviewKey(letterId string, name string) string {
+	return "letters/" + letterId + "/preview/" + name
+}
+
+// StoreLetterPreviews downloads the rendered PDF and thumbnails of a Lob
+// letter into the blob store. Lob renders letters a little while after they
+// are created, so until the letter's rendered webhook has arrived only some
+// previews may be stored, and errPreviewsNotRendered is returned. Previews
+// that were already stored are skipped, and the letter is marked once all
+// of them are.
+func StoreLetterPreviews(letterId string, lobLetter lob.LobLetter) error {
+	store, err := getBlobStore()
+	if err != nil {
+		return err
+	}
+
+	db, err := getDBConnection()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	stored, err := getStoredPreviewNames(db, letterId)
+	if err != nil {
+		return err
+	}
+
+	// Until Lob renders the letter it may not list the PDF or any thumbnail
+	rendered := lobLetter.URL != "" && len(lobLetter.Thumbnails) > 0
+	for name, assetURL := range getLobPreviewAssets(lobLetter) {
+		if _, ok := stored[name]; ok {
+			continue
+		} else if assetURL == "" {
+			rendered = false
+			continue
+		}
+
+		data, contentType, err := lob.DownloadAsset(assetURL)
+		if lobErr, ok := err.(*lob.LobError); ok && (lobErr.StatusCode == 403 || lobErr.StatusCode == 404) {
+			rendered = false
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		key := getPreviewKey(letterId, name)
+		err = store.Put(key, contentType, data)
+		if err != nil {
+			return err
+		}
+
+		_, err = db.Exec(
+			"INSERT INTO letter_previews (letterId, name, blobKey, contentType, byteSize) "+
+				"VALUES ($1, $2, $3, $4, $5) "+
+				"ON CONFLICT (letterId, name) DO UPDATE "+
+				"SET blobKey = EXCLUDED.blobKey, contentType = EXCLUDED.contentType, "+
+				"byteSize = EXCLUDED.byteSize, timeStored = now()",
+			letterId,
+			name,
+			key,
+			contentType,
+			len(data),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !rendered {
+		return errPreviewsNotRendered
+	}
+
+	_, err = db.Exec("UPDATE letters SET timePreviewsStored = now() WHERE id = $1", letterId)
+	return err
+}
+
+// getLobPreviewAssets returns the URL of every preview of a Lob letter by
+// the name it's stored under
+func getLobPreviewAssets(lobLetter lob.LobLetter) map[string]string {
+	assets := map[string]string{PreviewPDF: lobLetter.URL}
+	for i, thumbnail := range lobLetter.Thumbnails {
+		assets[getThumbnailName(i+1, PreviewSmall)] = thumbnail.Small
+		assets[getThumbnailName(i+1, PreviewMedium)] = thumbnail.Medium
+		assets[getThumbnailName(i+1, PreviewLarge)] = thumbnail.Large
+	}
+	return assets
+}
+
+// storeLetterPreviewsInBackground is used where the caller shouldn't wait for
+// the downloads, like the webhook handler, which Lob expects to answer quickly
+func storeLetterPreviewsInBackground(letterId string, lobLetter lob.LobLetter) {
+	go func() {
+		err := StoreLetterPreviews(letterId, lobLetter)
+		if err != nil && err != errPreviewsNotRendered {
+			fmt.Println("Error storing previews of letter " + letterId + ": " + err.Error())
+		}
+	}()
+}
+
+// GetLetterPreviews lists the previews stored for one of the user's letters.
+// If some are missing, e.g. because the rendered webhook was missed, they
+// are fetched from Lob now. The list stays incomplete until Lob has
+// rendered the letter.
+func GetLetterPreviews(username string, letterId string) (LetterPreviews, error) {
+	letter, err := getAuthorsLetter(username, letterId)
+	if err != nil {
+		return LetterPreviews{}, err
+	}
+
+	db, err := getDBConnection()
+	if err != nil {
+		return LetterPreviews{}, err
+	}
+	defer db.Close()
+
+	var complete bool
+	err = db.QueryRow("SELECT timePreviewsStored IS NOT NULL FROM letters WHERE id = $1", letterId).Scan(&complete)
+	if err != nil {
+		return LetterPreviews{}, err
+	}
+
+	if !complete && letter.LobLetterId != "" {
+		client, err := lob.NewClient(letter.LobEnvironment)
+		if err != nil {
+			return LetterPreviews{}, err
+		}
+
+		lobLetter, err := client.GetLetter(letter.LobLetterId)
+		if err != nil {
+			return LetterPreviews{}, err
+		}
+
+		err = StoreLetterPreviews(letterId, lobLetter)
+		if err != nil && err != errPreviewsNotRendered {
+			return LetterPreviews{}, err
+		}
+	}
+
+	stored, err := getStoredPreviewNames(db, letterId)
+	if err != nil {
+		return LetterPreviews{}, err
+	}
+
+	return getLetterPreviewURLs(letterId, stored), nil
+}
+
+// GetLetterPreview returns one stored preview of one of the user's letters
+func GetLetterPreview(username string, letterId string, name string) (blob.Blob, error) {
+	_, err := getAuthorsLetter(username, letterId)
+	if err != nil {
+		return blob.Blob{}, err
+	}
+
+	db, err := getDBConnection()
+	if err != nil {
+		return blob.Blob{}, err
+	}
+	defer db.Close()
+
+	var key string
+	err = db.QueryRow(
+		"SELECT blobKey FROM letter_previews WHERE letterId = $1 AND name = $2",
+		letterId,
+		name,
+	).Scan(&key)
+	if err == sql.ErrNoRows {
+		return blob.Blob{}, ErrPreviewNotFound
+	} else if err != nil {
+		return blob.Blob{}, err
+	}
+
+	store, err := getBlobStore()
+	if err != nil {
+		return blob.Blob{}, err
+	}
+
+	preview, err := store.Get(key)
+	if err == blob.ErrNotFound {
+		return blob.Blob{}, ErrPreviewNotFound
+	}
+	return preview, err
+}
+
+// deletePreviewBlobs removes preview files once their rows are gone. Errors
+// are only logged: a leftover file is no longer reachable through the API.
+func deletePreviewBlobs(keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+
+	store, err := getBlobStore()
+	if err != nil {
+		fmt.Println("Error deleting letter previews: " + err.Error())
+		return
+	}
+
+	for _, key := range keys {
+		err := store.Delete(key)
+		if err != nil {
+			fmt.Println("Error deleting letter preview " + key + ": " + err.Error())
+		}
+	}
+}
+
+func getAuthorsLetter(username string, letterId string) (Letter, error) {
+	letter, err := GetLetterFromDB(letterId)
+	if err != nil {
+		return Letter{}, err
+	}
+
+	if letter.Author != username {
+		return Letter{}, ErrLetterNotFound
+	}
+	return letter, nil
+}
+
+func getStoredPreviewNames(db *sql.DB, letterId string) (map[string]struct{}, error) {
+	names := make(map[string]struct{})
+
+	rows, err := db.Query("SELECT name FROM letter_previews WHERE letterId = $1", letterId)
+	if err != nil {
+		return names, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return names, err
+		}
+		names[name] = struct{}{}
+	}
+
+	return names, rows.Err()
+}
+
+func getLetterPreviewURLs(letterId string, stored map[string]struct{}) LetterPreviews {
+	base := "/letters/" + letterId + "/preview/"
+	previews := LetterPreviews{Pages: []PagePreview{}}
+
+	if _, ok := stored[PreviewPDF]; ok {
+		previews.PDF = base + PreviewPDF
+	}
+
+	pages := make(map[int]*PagePreview)
+	for name := range stored {
+		parts := strings.SplitN(name, "-", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		page, err := strconv.Atoi(parts[0])
+		if err != nil {
+			continue
+		}
+
+		if pages[page] == nil {
+			pages[page] = &PagePreview{Page: page}
+		}
+
+		switch parts[1] {
+		case PreviewSmall:
+			pages[page].Small = base + name
+		case PreviewMedium:
+			pages[page].Medium = base + name
+		case PreviewLarge:
+			pages[page].Large = base + name
+		}
+	}
+
+	for _, page := range pages {
+		previews.Pages = append(previews.Pages, *page)
+	}
+	sort.Slice(previews.Pages, func(i, j int) bool {
+		return previews.Pages[i].Page < previews.Pages[j].Page
+	})
+
+	return previews
+}
@@ -0,0 +1,70 @@
+package models
+
+import (
+	"fmt"
+
+	"github.com/johnamadeo/intouchgo/lob"
+)
+
+// What Lob charges us for a letter, in cents. Lob bills by the sheet, so
+// the same letter costs less double-sided. Update these with our plan.
+const (
+	LetterFirstSheetPrice       = 60
+	LetterExtraSheetPrice       = 10
+	LetterColorSheetPrice       = 25 // On top of the black and white price
+	FirstClassPrice             = 15
+	CertifiedPrice              = 435
+	CertifiedReturnReceiptPrice = 795
+	RegisteredPrice             = 1495
+)
+
+// getLetterSheets returns how many sheets a letter of pageCount pages is
+// printed on, counting the address page if it gets one
+func getLetterSheets(letter Letter, pageCount int) int {
+	sides := pageCount
+	if letter.AddressPlacement == lob.AddressPlacementInsertBlankPage {
+		sides++
+	}
+
+	if letter.DoubleSided != nil && *letter.DoubleSided {
+		return (sides + 1) / 2
+	}
+	return sides
+}
+
+// estimateLetterCost returns what Lob will charge for the letter in dollars,
+// formatted like Letter.Price. Letters in large print run to more pages and
+// so cost more.
+func estimateLetterCost(letter Letter, pageCount int) string {
+	return formatCents(estimateLetterCents(letter, pageCount))
+}
+
+func estimateLetterCents(letter Letter, pageCount int) int {
+	sheets := getLetterSheets(letter, pageCount)
+	if sheets < 1 {
+		sheets = 1
+	}
+
+	cents := LetterFirstSheetPrice + (sheets-1)*LetterExtraSheetPrice
+	if letter.Color {
+		cents += sheets * LetterColorSheetPrice
+	}
+	if letter.MailType == lob.USPSFirstClass {
+		cents += FirstClassPrice
+	}
+
+	switch letter.ExtraService {
+	case lob.ExtraServiceCertified:
+		cents += CertifiedPrice
+	case lob.ExtraServiceCertifiedReturnReceipt:
+		cents += CertifiedReturnReceiptPrice
+	case lob.ExtraServiceRegistered:
+		cents += RegisteredPrice
+	}
+
+	return cents
+}
+
+func formatCents(cents int) string {
+	return fmt.Sprintf("%d.%02d", cents/100, cents%100)
+}
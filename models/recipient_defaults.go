@@ -0,0 +1,99 @@
+package models
+
+import (
+	"database/sql"
+)
+
+// RecipientDefaults are the letter options a user wants for every letter to
+// one recipient unless the letter says otherwise, e.g. large print for a
+// reader with poor eyesight
+type RecipientDefaults struct {
+	InmateId   string `json:"inmateId"`
+	LargePrint bool   `json:"largePrint"`
+}
+
+func GetRecipientDefaults(username string) ([]RecipientDefaults, error) {
+	defaults := []RecipientDefaults{}
+
+	db, err := getDBConnection()
+	if err != nil {
+		return defaults, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(
+		"SELECT inmateId, largePrint FROM recipient_defaults WHERE username = $1 ORDER BY timeUpdated",
+		username,
+	)
+	if err != nil {
+		return defaults, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		recipientDefaults := RecipientDefaults{}
+		err := rows.Scan(&recipientDefaults.InmateId, &recipientDefaults.LargePrint)
+		if err != nil {
+			return defaults, err
+		}
+
+		defaults = append(defaults, recipientDefaults)
+	}
+
+	return defaults, rows.Err()
+}
+
+func SaveRecipientDefaults(username string, defaults RecipientDefaults) error {
+	db, err := getDBConnection()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var found string
+	err = db.QueryRow("SELECT id FROM inmates WHERE id = $1", defaults.InmateId).Scan(&found)
+	if err == sql.ErrNoRows {
+		return ErrInmateNotFound
+	} else if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(
+		"INSERT INTO recipient_defaults (username, inmateId, largePrint) VALUES ($1, $2, $3) "+
+			"ON CONFLICT (username, inmateId) DO UPDATE "+
+			"SET largePrint = EXCLUDED.largePrint, timeUpdated = now()",
+		username,
+		defaults.InmateId,
+		defaults.LargePrint,
+	)
+	return err
+}
+
+// applyRecipientDefaults fills in the options the letter leaves out with the
+// ones its author saved for the recipient
+func applyRecipientDefaults(letter Letter) (Letter, error) {
+	if letter.LargePrint != nil {
+		return letter, nil
+	}
+
+	db, err := getDBConnection()
+	if err != nil {
+		return letter, err
+	}
+	defer db.Close()
+
+	var largePrint bool
+	err = db.QueryRow(
+		"SELECT largePrint FROM recipient_defaults WHERE username = $1 AND inmateId = $2",
+		letter.Author,
+		letter.RecipientId,
+	).Scan(&largePrint)
+	if err == sql.ErrNoRows {
+		return letter, nil
+	} else if err != nil {
+		return letter, err
+	}
+
+	letter.LargePrint = &largePrint
+	return letter, nil
+}
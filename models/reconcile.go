@@ -0,0 +1,386 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/johnamadeo/intouchgo/lob"
+)
+
+const (
+	ReconcilePageSize = 100
+
+	// Kinds of discrepancy found by ReconcileLetters
+	DiscrepancyOrphanInLob   = "orphan_in_lob"   // Lob has a letter we have no record of
+	DiscrepancyMissingInLob  = "missing_in_lob"  // we recorded a Lob id that Lob doesn't know
+	DiscrepancyMissingLobId  = "missing_lob_id"  // Lob has the letter but we never saved its id
+	DiscrepancyStatusDrift   = "status_drift"    // Lob's tracking is ahead of our status
+	DiscrepancyCostMismatch  = "cost_mismatch"   // the price we recorded differs from Lob's
+	DiscrepancyLobIdMismatch = "lob_id_mismatch" // Lob's letter is tagged with a different letter id
+)
+
+// Maps the names of Lob tracking events to letter statuses
+var lobTrackingStatuses = map[string]string{
+	"Mailed":                 LetterMailed,
+	"In Transit":             LetterInTransit,
+	"In Local Area":          LetterInLocalArea,
+	"Processed for Delivery": LetterProcessedForDelivery,
+	"Re-Routed":              LetterReRouted,
+	"Delivered":              LetterDelivered,
+	"Returned to Sender":     LetterReturnedToSender,
+}
+
+type ReconciliationReport struct {
+	Id                string                      `json:"id"`
+	Environment       string                      `json:"environment"`
+	Repair            bool                        `json:"repair"`
+	TimeStarted       string                      `json:"timeStarted"`
+	TimeFinished      string                      `json:"timeFinished"`
+	LobLettersChecked int                         `json:"lobLettersChecked"`
+	DBLettersChecked  int                         `json:"dbLettersChecked"`
+	Discrepancies     []ReconciliationDiscrepancy `json:"discrepancies"`
+}
+
+type ReconciliationDiscrepancy struct {
+	Kind        string `json:"kind"`
+	LetterId    string `json:"letterId,omitempty"`
+	LobLetterId string `json:"lobLetterId,omitempty"`
+	Ours        string `json:"ours,omitempty"`
+	Lobs        string `json:"lobs,omitempty"`
+	Repaired    bool   `json:"repaired"`
+	Change      string `json:"change,omitempty"`
+}
+
+type reconcileLetter struct {
+	id          string
+	lobLetterId string
+	status      string
+	cost        sql.NullFloat64
+}
+
+// ReconcileLetters compares every letter Lob has on record for us with the
+// letters table and reports orphans, missing records, status drift and cost
+// discrepancies. With repair set it also fixes what can be fixed safely:
+// saving Lob ids we lost, moving statuses forward and adopting Lob's price.
+// Orphans and letters missing from Lob are only reported, since they need a
+// person to look at them. The report is saved to reconciliation_runs.
+func ReconcileLetters(lobEnvironment string, repair bool) (ReconciliationReport, error) {
+	report := ReconciliationReport{
+		Id:            uuid.New().String(),
+		Environment:   lobEnvironment,
+		Repair:        repair,
+		TimeStarted:   time.Now().UTC().Format(time.RFC3339),
+		Discrepancies: []ReconciliationDiscrepancy{},
+	}
+
+	client, err := lob.NewClient(lobEnvironment)
+	if err != nil {
+		return report, err
+	}
+
+	db, err := getDBConnection()
+	if err != nil {
+		return report, err
+	}
+	defer db.Close()
+
+	ours, err := getLettersToReconcile(db, lobEnvironment)
+	if err != nil {
+		return report, err
+	}
+	report.DBLettersChecked = len(ours)
+
+	byLobId := make(map[string]reconcileLetter)
+	for _, letter := range ours {
+		if letter.lobLetterId != "" {
+			byLobId[letter.lobLetterId] = letter
+		}
+	}
+
+	// Letters sent before they were tagged with the app only carry their
+	// letter id, or no metadata at all, so every letter is listed and matched
+	// by Lob id or letter id instead
+	seenLobIds := make(map[string]bool)
+	params := lob.ListParams{Limit: ReconcilePageSize}
+
+	for {
+		page, err := client.ListLetters(params)
+		if err != nil {
+			return report, err
+		}
+
+		for _, lobLetter := range page.Data {
+			report.LobLettersChecked++
+			seenLobIds[lobLetter.Id] = true
+
+			discrepancies, err := reconcileLobLetter(db, lobLetter, ours, byLobId, repair)
+			if err != nil {
+				return report, err
+			}
+			report.Discrepancies = append(report.Discrepancies, discrepancies...)
+		}
+
+		if !page.HasMore() {
+			break
+		}
+		params.After = page.NextCursor()
+	}
+
+	for _, letter := range ours {
+		if letter.lobLetterId != "" && !seenLobIds[letter.lobLetterId] {
+			report.Discrepancies = append(report.Discrepancies, ReconciliationDiscrepancy{
+				Kind:        DiscrepancyMissingInLob,
+				LetterId:    letter.id,
+				LobLetterId: letter.lobLetterId,
+				Ours:        letter.status,
+			})
+		}
+	}
+
+	report.TimeFinished = time.Now().UTC().Format(time.RFC3339)
+
+	err = saveReconciliationReport(db, report)
+	if err != nil {
+		return report, err
+	}
+
+	return report, nil
+}
+
+func reconcileLobLetter(
+	db *sql.DB,
+	lobLetter lob.LobLetter,
+	ours map[string]reconcileLetter,
+	byLobId map[string]reconcileLetter,
+	repair bool,
+) ([]ReconciliationDiscrepancy, error) {
+	discrepancies := []ReconciliationDiscrepancy{}
+	letterId := lobLetter.Metadata[LetterIdMetadataKey]
+
+	letter, ok := byLobId[lobLetter.Id]
+	if !ok {
+		letter, ok = ours[letterId]
+		if !ok && letterId == "" && lobLetter.Metadata[AppMetadataKey] != AppMetadataValue {
+			// Not one of ours, e.g. sent from Lob's dashboard
+			return discrepancies, nil
+		} else if !ok {
+			return append(discrepancies, ReconciliationDiscrepancy{
+				Kind:        DiscrepancyOrphanInLob,
+				LetterId:    letterId,
+				LobLetterId: lobLetter.Id,
+			}), nil
+		}
+
+		if letter.lobLetterId != "" {
+			// We recorded a different Lob letter for this letter id, which
+			// means it was most likely mailed twice
+			return append(discrepancies, ReconciliationDiscrepancy{
+				Kind:        DiscrepancyLobIdMismatch,
+				LetterId:    letter.id,
+				LobLetterId: lobLetter.Id,
+				Ours:        letter.lobLetterId,
+				Lobs:        lobLetter.Id,
+			}), nil
+		}
+
+		discrepancy := ReconciliationDiscrepancy{
+			Kind:        DiscrepancyMissingLobId,
+			LetterId:    letter.id,
+			LobLetterId: lobLetter.Id,
+			Ours:        letter.status,
+		}
+		if repair {
+			status, err := markReconciledLetterSent(db, letter.id, lobLetter)
+			if err != nil {
+				return discrepancies, err
+			}
+			discrepancy.Repaired = true
+			discrepancy.Change = "status " + letter.status + " -> " + status + ", saved Lob id"
+			letter.status = status
+		}
+		discrepancies = append(discrepancies, discrepancy)
+	}
+
+	lobStatus := getLobLetterStatus(lobLetter)
+	if letterStatusRanks[lobStatus] > letterStatusRanks[letter.status] {
+		discrepancy := ReconciliationDiscrepancy{
+			Kind:        DiscrepancyStatusDrift,
+			LetterId:    letter.id,
+			LobLetterId: lobLetter.Id,
+			Ours:        letter.status,
+			Lobs:        lobStatus,
+		}
+		if repair {
+			// A webhook may have moved the letter on since it was read, in
+			// which case it is left alone
+			result, err := db.Exec(
+				"UPDATE letters SET status = $1 WHERE id = $2 AND status = $3",
+				lobStatus,
+				letter.id,
+				letter.status,
+			)
+			if err != nil {
+				return discrepancies, err
+			}
+
+			updated, err := result.RowsAffected()
+			if err != nil {
+				return discrepancies, err
+			}
+			if updated > 0 {
+				discrepancy.Repaired = true
+				discrepancy.Change = "status " + letter.status + " -> " + lobStatus
+			}
+		}
+		discrepancies = append(discrepancies, discrepancy)
+	}
+
+	lobPrice, err := strconv.ParseFloat(lobLetter.Price, 64)
+	if err == nil && (!letter.cost.Valid || math.Abs(letter.cost.Float64-lobPrice) >= 0.005) {
+		discrepancy := ReconciliationDiscrepancy{
+			Kind:        DiscrepancyCostMismatch,
+			LetterId:    letter.id,
+			LobLetterId: lobLetter.Id,
+			Lobs:        lobLetter.Price,
+		}
+		if letter.cost.Valid {
+			discrepancy.Ours = strconv.FormatFloat(letter.cost.Float64, 'f', 2, 64)
+		}
+		if repair {
+			_, err := db.Exec("UPDATE letters SET cost = $1 WHERE id = $2", lobPrice, letter.id)
+			if err != nil {
+				return discrepancies, err
+			}
+			discrepancy.Repaired = true
+			discrepancy.Change = "cost " + discrepancy.Ours + " -> " + lobLetter.Price
+		}
+		discrepancies = append(discrepancies, discrepancy)
+	}
+
+	return discrepancies, nil
+}
+
+// markReconciledLetterSent saves the Lob letter found for one of our letters
+// that never recorded it. Lob has the letter, so a letter that is queued,
+// being sent or failed is sent and must not be dispatched again; one a
+// webhook has moved further keeps its status. It returns the status saved.
+func markReconciledLetterSent(db *sql.DB, id string, lobLetter lob.LobLetter) (string, error) {
+	var status string
+	err := db.QueryRow(
+		"UPDATE letters "+
+			"SET status = CASE WHEN status IN ($1, $2, $3) THEN $4 ELSE status END, "+
+			"lobLetterId = $5, timeDeliveredEstimate = $6, cost = $7, heldByLob = $8, lastError = NULL, timeDispatchStarted = NULL "+
+			"WHERE id = $9 RETURNING status",
+		LetterQueued,
+		LetterSending,
+		LetterFailed,
+		LetterSent,
+		lobLetter.Id,
+		getLobDeliveryDate(lobLetter.ExpectedDeliveryDate),
+		getLobPrice(lobLetter),
+		isHeldByLob(lobLetter),
+		id,
+	).Scan(&status)
+	return status, err
+}
+
+// The furthest status Lob's copy of the letter has reached
+func getLobLetterStatus(lobLetter lob.LobLetter) string {
+	if lobLetter.Deleted {
+		return LetterCancelled
+	}
+
+	status := LetterSent
+	for _, event := range lobLetter.TrackingEvents {
+		eventStatus, ok := lobTrackingStatuses[event.Name]
+		if ok && letterStatusRanks[eventStatus] > letterStatusRanks[status] {
+			status = eventStatus
+		}
+	}
+
+	return status
+}
+
+// Queued letters haven't been sent to Lob yet, so there's nothing to compare
+func getLettersToReconcile(db *sql.DB, lobEnvironment string) (map[string]reconcileLetter, error) {
+	letters := make(map[string]reconcileLetter)
+
+	rows, err := db.Query(
+		"SELECT id, COALESCE(lobLetterId, ''), status, cost FROM letters WHERE status != $1 AND lobEnvironment = $2",
+		LetterQueued,
+		lobEnvironment,
+	)
+	if err != nil {
+		return letters, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		letter := reconcileLetter{}
+		err := rows.Scan(&letter.id, &letter.lobLetterId, &letter.status, &letter.cost)
+		if err != nil {
+			return letters, err
+		}
+		letters[letter.id] = letter
+	}
+
+	return letters, rows.Err()
+}
+
+func saveReconciliationReport(db *sql.DB, report ReconciliationReport) error {
+	bytes, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	repaired := 0
+	for _, discrepancy := range report.Discrepancies {
+		if discrepancy.Repaired {
+			repaired++
+		}
+	}
+
+	_, err = db.Exec(
+		"INSERT INTO reconciliation_runs "+
+			"(id, environment, repair, timeStarted, timeFinished, lobLettersChecked, dbLettersChecked, discrepancies, repaired, report) "+
+			"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)",
+		report.Id,
+		report.Environment,
+		report.Repair,
+		report.TimeStarted,
+		report.TimeFinished,
+		report.LobLettersChecked,
+		report.DBLettersChecked,
+		len(report.Discrepancies),
+		repaired,
+		string(bytes),
+	)
+
+	return err
+}
+
+// PrintReconciliationReport writes a human readable summary to stdout, for
+// the scheduler logs
+func PrintReconciliationReport(report ReconciliationReport) {
+	fmt.Printf(
+		"Reconciliation %s (%s): checked %d Lob letters and %d of ours, found %d discrepancies\n",
+		report.Id,
+		report.Environment,
+		report.LobLettersChecked,
+		report.DBLettersChecked,
+		len(report.Discrepancies),
+	)
+
+	for _, d := range report.Discrepancies {
+		line := fmt.Sprintf("  %s letter=%s lob=%s ours=%s lob's=%s", d.Kind, d.LetterId, d.LobLetterId, d.Ours, d.Lobs)
+		if d.Repaired {
+			line += " repaired: " + d.Change
+		}
+		fmt.Println(line)
+	}
+}
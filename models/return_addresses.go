@@ -0,0 +1,350 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/johnamadeo/intouchgo/lob"
+	"github.com/johnamadeo/intouchgo/utils"
+)
+
+const (
+	MaxReturnAddressesPerUser = 10
+)
+
+var (
+	ErrReturnAddressNotFound  = errors.New("No return address with requested id found.")
+	ErrUndeliverableAddress   = errors.New("USPS can't deliver to that address. Check it and try again.")
+	ErrTooManyReturnAddresses = errors.New("You can't register any more return addresses. Delete one first.")
+
+	statePattern = regexp.MustCompile(`^[A-Za-z]{2}$`)
+	zipPattern   = regexp.MustCompile(`^[0-9]{5}(-[0-9]{4})?$`)
+)
+
+type ReturnAddress struct {
+	Id             string `json:"id"`
+	Name           string `json:"name"`
+	AddressLine1   string `json:"addressLine1"`
+	AddressLine2   string `json:"addressLine2"`
+	City           string `json:"city"`
+	State          string `json:"state"`
+	Zip            string `json:"zip"`
+	Deliverability string `json:"deliverability"`
+	TimeCreated    string `json:"timeCreated"`
+
+	username         string
+	lobTestAddressId string
+	lobLiveAddressId string
+}
+
+func ValidateReturnAddress(address ReturnAddress) []utils.FieldError {
+	errs := []utils.FieldError{}
+
+	name := strings.TrimSpace(address.Name)
+	if name == "" {
+		errs = append(errs, newFieldError("name", "field.nameRequired"))
+	} else if len(name) > MaxLobNameLength {
+		errs = append(errs, newFieldError("name", "field.nameTooLong", MaxLobNameLength))
+	}
+
+	if strings.TrimSpace(address.AddressLine1) == "" {
+		errs = append(errs, newFieldError("addressLine1", "field.addressRequired"))
+	}
+
+	if strings.TrimSpace(address.City) == "" {
+		errs = append(errs, newFieldError("city", "field.cityRequired"))
+	}
+
+	if !statePattern.MatchString(strings.TrimSpace(address.State)) {
+		errs = append(errs, newFieldError("state", "field.state"))
+	}
+
+	if !zipPattern.MatchString(strings.TrimSpace(address.Zip)) {
+		errs = append(errs, newFieldError("zip", "field.zip"))
+	}
+
+	return errs
+}
+
+// CreateReturnAddress verifies the address with USPS through Lob and saves
+// the standardized address, so that inmates' replies aren't lost to a typo.
+// The Lob address object for the user's environment is created right away;
+// the other environment's is created the first time it is needed.
+func CreateReturnAddress(username string, address ReturnAddress) (ReturnAddress, error) {
+	addresses, err := GetReturnAddresses(username)
+	if err != nil {
+		return ReturnAddress{}, err
+	}
+
+	if len(addresses) >= MaxReturnAddressesPerUser {
+		return ReturnAddress{}, ErrTooManyReturnAddresses
+	}
+
+	verifier, err := getVerificationClient()
+	if err != nil {
+		return ReturnAddress{}, err
+	}
+
+	verification, err := verifier.VerifyUSAddress(lob.LobUSVerificationRequest{
+		PrimaryLine:   strings.TrimSpace(address.AddressLine1),
+		SecondaryLine: strings.TrimSpace(address.AddressLine2),
+		City:          strings.TrimSpace(address.City),
+		State:         strings.TrimSpace(address.State),
+		ZipCode:       strings.TrimSpace(address.Zip),
+	})
+	if err != nil {
+		return ReturnAddress{}, err
+	}
+
+	if !verification.IsDeliverable() {
+		return ReturnAddress{}, ErrUndeliverableAddress
+	}
+
+	address = ReturnAddress{
+		Id:             uuid.New().String(),
+		Name:           strings.TrimSpace(address.Name),
+		AddressLine1:   verification.PrimaryLine,
+		AddressLine2:   verification.SecondaryLine,
+		City:           verification.Components.City,
+		State:          verification.Components.State,
+		Zip:            verification.Components.ZipCode,
+		Deliverability: verification.Deliverability,
+		TimeCreated:    time.Now().UTC().Format("01/02/06"),
+		username:       username,
+	}
+
+	lobEnvironment, err := GetLobEnvironmentForUser(username)
+	if err != nil {
+		return ReturnAddress{}, err
+	}
+
+	lobAddressId, err := createReturnLobAddress(address, lobEnvironment)
+	if err != nil {
+		return ReturnAddress{}, err
+	}
+
+	if lobEnvironment == lob.LobLiveEnvironment {
+		address.lobLiveAddressId = lobAddressId
+	} else {
+		address.lobTestAddressId = lobAddressId
+	}
+
+	db, err := getDBConnection()
+	if err != nil {
+		return ReturnAddress{}, err
+	}
+	defer db.Close()
+
+	_, err = db.Exec(
+		"INSERT INTO return_addresses "+
+			"(id, username, name, addressLine1, addressLine2, city, state, zip, deliverability, lobTestAddressId, lobLiveAddressId) "+
+			"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)",
+		address.Id,
+		username,
+		address.Name,
+		address.AddressLine1,
+		address.AddressLine2,
+		address.City,
+		address.State,
+		address.Zip,
+		address.Deliverability,
+		address.lobTestAddressId,
+		address.lobLiveAddressId,
+	)
+	if err != nil {
+		return ReturnAddress{}, err
+	}
+
+	return address, nil
+}
+
+func GetReturnAddresses(username string) ([]ReturnAddress, error) {
+	db, err := getDBConnection()
+	if err != nil {
+		return []ReturnAddress{}, err
+	}
+	defer db.Close()
+
+	return getReturnAddresses(db, "WHERE username = $1 AND timeDeleted IS NULL", username)
+}
+
+// DeleteReturnAddress hides the address from the user. Letters already
+// queued with it are still sent with it.
+func DeleteReturnAddress(username string, id string) error {
+	db, err := getDBConnection()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	result, err := db.Exec(
+		"UPDATE return_addresses SET timeDeleted = $1 WHERE id = $2 AND username = $3 AND timeDeleted IS NULL",
+		time.Now().UTC(),
+		id,
+		username,
+	)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if updated == 0 {
+		return ErrReturnAddressNotFound
+	}
+	return nil
+}
+
+// checkReturnAddress makes sure a letter's return address belongs to its
+// author and hasn't been deleted
+func checkReturnAddress(username string, id string) error {
+	db, err := getDBConnection()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	addresses, err := getReturnAddresses(db, "WHERE id = $1 AND username = $2 AND timeDeleted IS NULL", id, username)
+	if err != nil {
+		return err
+	}
+
+	if len(addresses) == 0 {
+		return ErrReturnAddressNotFound
+	}
+	return nil
+}
+
+// getReturnLobAddressId returns the Lob address id of the return address in
+// the given environment, creating it if the address was registered while the
+// user's letters were sent in the other environment
+func getReturnLobAddressId(id string, lobEnvironment string) (string, error) {
+	db, err := getDBConnection()
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	addresses, err := getReturnAddresses(db, "WHERE id = $1", id)
+	if err != nil {
+		return "", err
+	}
+
+	if len(addresses) == 0 {
+		return "", ErrReturnAddressNotFound
+	}
+	address := addresses[0]
+
+	column := ""
+	lobAddressId := ""
+	switch lobEnvironment {
+	case lob.LobTestEnvironment:
+		column = "lobTestAddressId"
+		lobAddressId = address.lobTestAddressId
+	case lob.LobLiveEnvironment:
+		column = "lobLiveAddressId"
+		lobAddressId = address.lobLiveAddressId
+	default:
+		return "", errors.New(lob.LobInvalidEnvironmentError)
+	}
+
+	if lobAddressId != "" {
+		return lobAddressId, nil
+	}
+
+	lobAddressId, err = createReturnLobAddress(address, lobEnvironment)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = db.Exec("UPDATE return_addresses SET "+column+" = $1 WHERE id = $2", lobAddressId, id)
+	if err != nil {
+		return "", err
+	}
+
+	return lobAddressId, nil
+}
+
+func createReturnLobAddress(address ReturnAddress, lobEnvironment string) (string, error) {
+	client, err := lob.NewClient(lobEnvironment)
+	if err != nil {
+		return "", err
+	}
+
+	lobAddress, err := client.CreateAddress(lob.LobCreateAddressRequest{
+		Description:    "Return address " + address.Id,
+		Name:           address.Name,
+		AddressLine1:   address.AddressLine1,
+		AddressLine2:   address.AddressLine2,
+		AddressCity:    address.City,
+		AddressState:   address.State,
+		AddressZip:     address.Zip,
+		AddressCountry: "US",
+		Metadata: map[string]string{
+			AppMetadataKey:      AppMetadataValue,
+			"return_address_id": address.Id,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return lobAddress.Id, nil
+}
+
+func getReturnAddresses(db *sql.DB, where string, args ...interface{}) ([]ReturnAddress, error) {
+	addresses := []ReturnAddress{}
+
+	fields := []string{
+		"id",
+		"username",
+		"name",
+		"addressLine1",
+		"addressLine2",
+		"city",
+		"state",
+		"zip",
+		"deliverability",
+		"lobTestAddressId",
+		"lobLiveAddressId",
+		"TO_CHAR(timeCreated, 'MM/dd/yy')",
+	}
+
+	rows, err := db.Query("SELECT "+strings.Join(fields, ", ")+" FROM return_addresses "+where+" ORDER BY timeCreated", args...)
+	if err != nil {
+		return addresses, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		address := ReturnAddress{}
+		err := rows.Scan(
+			&address.Id,
+			&address.username,
+			&address.Name,
+			&address.AddressLine1,
+			&address.AddressLine2,
+			&address.City,
+			&address.State,
+			&address.Zip,
+			&address.Deliverability,
+			&address.lobTestAddressId,
+			&address.lobLiveAddressId,
+			&address.TimeCreated,
+		)
+		if err != nil {
+			return addresses, err
+		}
+
+		addresses = append(addresses, address)
+	}
+
+	return addresses, nil
+}
@@ -6,16 +6,43 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
 
 	"github.com/google/go-querystring/query"
+	"github.com/johnamadeo/intouchgo/i18n"
+	"github.com/johnamadeo/intouchgo/resilient"
+	"github.com/johnamadeo/intouchgo/utils"
 )
 
 const (
 	AuthConnection = "Username-Password-Authentication"
 	ClientId       = "UiMO3i34HawDk03M2D7hpu4A2fhJoIoh"
 	Domain         = "intouch-android.auth0.com"
+	Auth0Timeout   = 20 * time.Second
+
+	// Mirrors the username and password rules configured on the Auth0
+	// database connection so that bad input is rejected before we ever call
+	// the Management API
+	MinUsernameLength = 1
+	MaxUsernameLength = 15
+	MinPasswordLength = 8
+	MaxNameLength     = 40
+)
+
+var (
+	auth0Client = resilient.NewClient("auth0", Auth0Timeout)
+
+	ErrUserExists   = errors.New("A user with that username or email already exists")
+	ErrUserNotFound = errors.New("No users with given username found")
+
+	usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_.+\-]+$`)
+	emailPattern    = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
 )
 
 type GetAccessTokenRequest struct {
@@ -33,11 +60,25 @@ type GetAccessTokenResponse struct {
 }
 
 type CreateUserRequest struct {
-	Connection  string `json:"connection"`
-	Username    string `json:"username"`
-	Email       string `json:"email"`
-	Password    string `json:"password"`
-	VerifyEmail bool   `json:"verify_email"`
+	Connection   string          `json:"connection"`
+	Username     string          `json:"username"`
+	Email        string          `json:"email"`
+	Password     string          `json:"password"`
+	VerifyEmail  bool            `json:"verify_email"`
+	UserMetadata Auth0UserMedata `json:"user_metadata"`
+}
+
+// https://auth0.com/docs/api/management/v2#!/Jobs/post_verification_email
+type VerificationEmailRequest struct {
+	UserId   string `json:"user_id"`
+	ClientId string `json:"client_id"`
+}
+
+// https://auth0.com/docs/api/authentication#change-password
+type ChangePasswordRequest struct {
+	ClientId   string `json:"client_id"`
+	Email      string `json:"email"`
+	Connection string `json:"connection"`
 }
 
 type GetUserRealNameParams struct {
@@ -46,77 +87,180 @@ type GetUserRealNameParams struct {
 }
 
 type Auth0User struct {
-	Email        string          `json:"email"`
-	Username     string          `json:"username"`
-	UserMetadata Auth0UserMedata `json:"user_metadata"`
+	UserId        string          `json:"user_id"`
+	Email         string          `json:"email"`
+	EmailVerified bool            `json:"email_verified"`
+	Username      string          `json:"username"`
+	UserMetadata  Auth0UserMedata `json:"user_metadata"`
 }
 
 type Auth0UserMedata struct {
 	Name string `json:"name"`
 }
 
+// Auth0Error is the error body returned by the Auth0 Management API
+type Auth0Error struct {
+	StatusCode int    `json:"statusCode"`
+	Name       string `json:"error"`
+	Message    string `json:"message"`
+	ErrorCode  string `json:"errorCode"`
+}
+
+func (e *Auth0Error) Error() string {
+	return "Status Code: " + strconv.Itoa(e.StatusCode) + "\nMessage: " + e.Message
+}
+
 type User struct {
 	Username string `json:"username"`
 	Email    string `json:"email"`
+	Name     string `json:"name"`
 	Password string `json:"placeholderPassword"`
 }
 
+// ValidateUser checks a signup request against our username, email and
+// password rules and returns one FieldError per problem found.
+func ValidateUser(user User) []utils.FieldError {
+	fieldErrors := []utils.FieldError{}
+
+	username := strings.TrimSpace(user.Username)
+	switch {
+	case len(username) < MinUsernameLength:
+		fieldErrors = append(fieldErrors, newFieldError("username", "field.usernameRequired"))
+	case len(username) > MaxUsernameLength:
+		fieldErrors = append(fieldErrors, newFieldError("username", "field.usernameTooLong", MaxUsernameLength))
+	case !usernamePattern.MatchString(username):
+		fieldErrors = append(fieldErrors, newFieldError("username", "field.usernameCharacters"))
+	}
+
+	fieldErrors = append(fieldErrors, ValidateEmail(user.Email)...)
+
+	name := strings.TrimSpace(user.Name)
+	if name == "" {
+		fieldErrors = append(fieldErrors, newFieldError("name", "field.nameRequired"))
+	} else if len(name) > MaxNameLength {
+		fieldErrors = append(fieldErrors, newFieldError("name", "field.nameTooLong", MaxNameLength))
+	}
+
+	if key, args := validatePassword(user); key != "" {
+		fieldErrors = append(fieldErrors, newFieldError("placeholderPassword", key, args...))
+	}
+
+	return fieldErrors
+}
+
+func ValidateEmail(email string) []utils.FieldError {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return []utils.FieldError{newFieldError("email", "field.emailRequired")}
+	} else if !emailPattern.MatchString(email) {
+		return []utils.FieldError{newFieldError("email", "field.emailInvalid")}
+	}
+
+	return nil
+}
+
+// validatePassword returns the catalog key and arguments of what's wrong
+// with the password, or "" if nothing is
+func validatePassword(user User) (string, []interface{}) {
+	if len(user.Password) < MinPasswordLength {
+		return "field.passwordTooShort", []interface{}{MinPasswordLength}
+	}
+
+	var hasLower, hasUpper, hasDigit bool
+	for _, r := range user.Password {
+		switch {
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		}
+	}
+
+	if !hasLower || !hasUpper || !hasDigit {
+		return "field.passwordCharacters", nil
+	}
+
+	if strings.EqualFold(user.Password, user.Username) || strings.EqualFold(user.Password, user.Email) {
+		return "field.passwordSameAsUser", nil
+	}
+
+	return "", nil
+}
+
+// newFieldError builds the error from a message catalog key. The message is
+// in English until the route localizes it.
+func newFieldError(field string, key string, args ...interface{}) utils.FieldError {
+	return utils.FieldError{
+		Field:   field,
+		Message: i18n.T(i18n.DefaultLocale, key, args...),
+		Key:     key,
+		Args:    args,
+	}
+}
+
 func GetUserRealName(username string) (string, error) {
-	accessToken, err := getManagementAcessToken()
+	user, err := GetAuth0User(username)
 	if err != nil {
 		return "", err
 	}
 
+	return user.UserMetadata.Name, nil
+}
+
+// GetAuth0User returns the Auth0 profile of the user with the given username
+func GetAuth0User(username string) (Auth0User, error) {
+	accessToken, err := getManagementAcessToken()
+	if err != nil {
+		return Auth0User{}, err
+	}
+
 	url := "https://" + Domain + "/api/v2/users"
 	values, err := query.Values(GetUserRealNameParams{
 		SearchEngine: "v3",
 		Query:        `username:"` + username + `"`,
 	})
 	if err != nil {
-		return "", err
+		return Auth0User{}, err
 	}
 
 	params := values.Encode()
 
 	request, err := http.NewRequest("GET", url+"?"+params, nil)
 	if err != nil {
-		return "", err
+		return Auth0User{}, err
 	}
 
 	request.Header.Add("content-type", "application/json")
 	request.Header.Add("authorization", "Bearer "+accessToken)
 
-	response, err := http.DefaultClient.Do(request)
+	response, err := auth0Client.Do(request)
 	if err != nil {
-		return "", err
-	}
-
-	if response.StatusCode != 200 {
-		bytes, err := ioutil.ReadAll(response.Body)
-		if err != nil {
-			return "", err
-		}
-
-		fmt.Printf("%d, %s", response.StatusCode, string(bytes))
-		return "", errors.New("Status Code: " + string(response.StatusCode))
+		return Auth0User{}, err
 	}
+	defer response.Body.Close()
 
 	bytes, err := ioutil.ReadAll(response.Body)
 	if err != nil {
-		return "", err
+		return Auth0User{}, err
+	}
+
+	if response.StatusCode != 200 {
+		return Auth0User{}, auth0ErrorFromBody(response.StatusCode, bytes)
 	}
 
 	var users []Auth0User
 	err = json.Unmarshal(bytes, &users)
 	if err != nil {
-		return "", err
+		return Auth0User{}, err
 	}
 
 	if len(users) == 0 {
-		return "", errors.New("No users with given username found")
+		return Auth0User{}, ErrUserNotFound
 	}
 
-	return users[0].UserMetadata.Name, nil
+	return users[0], nil
 }
 
 func CreateUser(user User) error {
@@ -127,11 +271,12 @@ func CreateUser(user User) error {
 
 	url := "https://" + Domain + "/api/v2/users"
 	body := CreateUserRequest{
-		Connection:  AuthConnection,
-		Username:    user.Username,
-		Email:       user.Email,
-		Password:    user.Password,
-		VerifyEmail: false,
+		Connection:   AuthConnection,
+		Username:     strings.TrimSpace(user.Username),
+		Email:        strings.TrimSpace(user.Email),
+		Password:     user.Password,
+		VerifyEmail:  true,
+		UserMetadata: Auth0UserMedata{Name: strings.TrimSpace(user.Name)},
 	}
 
 	bytes, err := json.Marshal(body)
@@ -147,10 +292,11 @@ func CreateUser(user User) error {
 	request.Header.Add("content-type", "application/json")
 	request.Header.Add("authorization", "Bearer "+accessToken)
 
-	response, err := http.DefaultClient.Do(request)
+	response, err := auth0Client.Do(request)
 	if err != nil {
 		return err
 	}
+	defer response.Body.Close()
 
 	if response.StatusCode != 201 {
 		body, err := ioutil.ReadAll(response.Body)
@@ -158,15 +304,142 @@ func CreateUser(user User) error {
 			return err
 		}
 
-		return errors.New(
-			"Status Code: " + string(response.StatusCode) +
-				"\nBody: " + string(body),
-		)
+		if response.StatusCode == http.StatusConflict {
+			return ErrUserExists
+		}
+
+		return auth0ErrorFromBody(response.StatusCode, body)
 	}
 
 	return nil
 }
 
+// SendVerificationEmail asks Auth0 to resend the email verification link to
+// the user with the given username.
+func SendVerificationEmail(username string) error {
+	user, err := GetAuth0User(username)
+	if err != nil {
+		return err
+	}
+
+	if user.EmailVerified {
+		return nil
+	}
+
+	accessToken, err := getManagementAcessToken()
+	if err != nil {
+		return err
+	}
+
+	url := "https://" + Domain + "/api/v2/jobs/verification-email"
+	bytes, err := json.Marshal(VerificationEmailRequest{
+		UserId:   user.UserId,
+		ClientId: ClientId,
+	})
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest("POST", url, strings.NewReader(string(bytes)))
+	if err != nil {
+		return err
+	}
+	request.Header.Add("content-type", "application/json")
+	request.Header.Add("authorization", "Bearer "+accessToken)
+
+	response, err := auth0Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != 201 {
+		body, err := ioutil.ReadAll(response.Body)
+		if err != nil {
+			return err
+		}
+
+		return auth0ErrorFromBody(response.StatusCode, body)
+	}
+
+	return nil
+}
+
+// SendPasswordReset asks Auth0 to email a password reset link. Auth0 responds
+// with 200 whether or not the email belongs to a user, so callers can't use
+// this to find out which emails have accounts.
+func SendPasswordReset(email string) error {
+	url := "https://" + Domain + "/dbconnections/change_password"
+	bytes, err := json.Marshal(ChangePasswordRequest{
+		ClientId:   ClientId,
+		Email:      strings.TrimSpace(email),
+		Connection: AuthConnection,
+	})
+	if err != nil {
+		return err
+	}
+
+	response, err := auth0Client.Post(url, "application/json", strings.NewReader(string(bytes)))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != 200 {
+		body, err := ioutil.ReadAll(response.Body)
+		if err != nil {
+			return err
+		}
+
+		return auth0ErrorFromBody(response.StatusCode, body)
+	}
+
+	return nil
+}
+
+// deleteAuth0User removes the user from Auth0. A user that is already gone is
+// not treated as an error so that interrupted deletions can be retried.
+func deleteAuth0User(userId string) error {
+	accessToken, err := getManagementAcessToken()
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest("DELETE", "https://"+Domain+"/api/v2/users/"+url.PathEscape(userId), nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Add("authorization", "Bearer "+accessToken)
+
+	response, err := auth0Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != 204 && response.StatusCode != 404 {
+		body, err := ioutil.ReadAll(response.Body)
+		if err != nil {
+			return err
+		}
+
+		return auth0ErrorFromBody(response.StatusCode, body)
+	}
+
+	return nil
+}
+
+func auth0ErrorFromBody(statusCode int, body []byte) error {
+	auth0Err := Auth0Error{}
+	if err := json.Unmarshal(body, &auth0Err); err != nil || auth0Err.Message == "" {
+		auth0Err.Message = string(body)
+	}
+	auth0Err.StatusCode = statusCode
+
+	fmt.Println(auth0Err.Error())
+	return &auth0Err
+}
+
 func getManagementAcessToken() (string, error) {
 	url := "https://" + Domain + "/oauth/token"
 	secret, ok := os.LookupEnv("AUTH0_INTOUCH_CLIENT_SECRET")
@@ -188,7 +461,7 @@ func getManagementAcessToken() (string, error) {
 
 	payload := strings.NewReader(string(bytes))
 
-	response, err := http.Post(url, "application/json", payload)
+	response, err := auth0Client.Post(url, "application/json", payload)
 	if err != nil {
 		return "", err
 	}
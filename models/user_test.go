@@ -0,0 +1,261 @@
+package models
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/johnamadeo/intouchgo/mailer"
+)
+
+func TestValidateUser(t *testing.T) {
+	valid := User{Username: "jadk157", Email: "jadk157@gmail.com", Name: "John Amadeo", Password: "Arden2018"}
+
+	tests := []struct {
+		name   string
+		change func(user *User)
+		want   []string // field:key of each error
+	}{
+		{"valid", func(user *User) {}, nil},
+		{"username required", func(user *User) { user.Username = "  " }, []string{"username:field.usernameRequired"}},
+		{"username too long", func(user *User) { user.Username = strings.Repeat("j", MaxUsernameLength+1) }, []string{"username:field.usernameTooLong"}},
+		{"username characters", func(user *User) { user.Username = "jadk 157" }, []string{"username:field.usernameCharacters"}},
+		{"email required", func(user *User) { user.Email = "" }, []string{"email:field.emailRequired"}},
+		{"email invalid", func(user *User) { user.Email = "jadk157@gmail" }, []string{"email:field.emailInvalid"}},
+		{"name required", func(user *User) { user.Name = " " }, []string{"name:field.nameRequired"}},
+		{"name too long", func(user *User) { user.Name = strings.Repeat("J", MaxNameLength+1) }, []string{"name:field.nameTooLong"}},
+		{"password too short", func(user *User) { user.Password = "Arden18" }, []string{"placeholderPassword:field.passwordTooShort"}},
+		{"password without a digit", func(user *User) { user.Password = "ArdenArden" }, []string{"placeholderPassword:field.passwordCharacters"}},
+		{"password without upper case", func(user *User) { user.Password = "arden2018" }, []string{"placeholderPassword:field.passwordCharacters"}},
+		{"password same as username", func(user *User) { user.Username = "Arden2018" }, []string{"placeholderPassword:field.passwordSameAsUser"}},
+		{
+			"every field wrong",
+			func(user *User) { *user = User{Username: "", Email: "arden", Name: "", Password: "arden"} },
+			[]string{
+				"username:field.usernameRequired",
+				"email:field.emailInvalid",
+				"name:field.nameRequired",
+				"placeholderPassword:field.passwordTooShort",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			user := valid
+			test.change(&user)
+
+			got := []string{}
+			for _, fieldError := range ValidateUser(user) {
+				got = append(got, fieldError.Field+":"+fieldError.Key)
+			}
+			want := test.want
+			if want == nil {
+				want = []string{}
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("ValidateUser(%+v) = %v, want %v", user, got, want)
+			}
+		})
+	}
+}
+
+// startSMTPServer runs the local SMTP stand-in on a free port and returns
+// its address once it accepts connections.
+func startSMTPServer(t *testing.T) (*mailer.Server, string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() = %v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	server := mailer.NewServer(addr)
+	go server.ListenAndServe()
+	t.Cleanup(func() { server.Close() })
+
+	for i := 0; ; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			return server, addr
+		}
+		if i == 50 {
+			t.Fatalf("SMTP server never started: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// fakeAuth0 plays the part of Auth0 with its custom email provider pointed
+// at smtpAddr: verification and password reset requests are answered the
+// way Auth0 answers them and the email is sent over SMTP.
+func fakeAuth0(t *testing.T, smtpAddr string, users []Auth0User) *httptest.Server {
+	send := func(to string, subject string, link string) {
+		message := "To: " + to + "\r\nSubject: " + subject + "\r\n\r\n" + link + "\r\n"
+		err := smtp.SendMail(smtpAddr, nil, "no-reply@"+Domain, []string{to}, []byte(message))
+		if err != nil {
+			t.Errorf("smtp.SendMail() = %v", err)
+		}
+	}
+
+	findUser := func(match func(user Auth0User) bool) (Auth0User, bool) {
+		for _, user := range users {
+			if match(user) {
+				return user, true
+			}
+		}
+		return Auth0User{}, false
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth/token", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(GetAccessTokenResponse{AccessToken: "management-token"})
+	})
+	mux.HandleFunc("/api/v2/users", func(w http.ResponseWriter, r *http.Request) {
+		username := strings.Trim(strings.TrimPrefix(r.URL.Query().Get("q"), "username:"), `"`)
+		matches := []Auth0User{}
+		if user, ok := findUser(func(user Auth0User) bool { return user.Username == username }); ok {
+			matches = append(matches, user)
+		}
+		json.NewEncoder(w).Encode(matches)
+	})
+	mux.HandleFunc("/api/v2/jobs/verification-email", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("authorization") != "Bearer management-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var request VerificationEmailRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		user, ok := findUser(func(user Auth0User) bool { return user.UserId == request.UserId })
+		if !ok || request.ClientId != ClientId {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"statusCode":400,"message":"Invalid user_id or client_id"}`))
+			return
+		}
+
+		send(user.Email, "Verify your email", "https://"+Domain+"/u/email-verification?user="+url.QueryEscape(user.UserId))
+		w.WriteHeader(http.StatusCreated)
+	})
+	mux.HandleFunc("/dbconnections/change_password", func(w http.ResponseWriter, r *http.Request) {
+		var request ChangePasswordRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		user, ok := findUser(func(user Auth0User) bool { return user.Email == request.Email })
+		if ok && request.Connection == AuthConnection && request.ClientId == ClientId {
+			send(user.Email, "Reset your password", "https://"+Domain+"/lo/reset?user="+url.QueryEscape(user.UserId))
+		}
+		w.Write([]byte(`"We've just sent you an email to reset your password."`))
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// redirectTransport sends every request to the fake Auth0 server instead of
+// the real domain
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (rt redirectTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	request = request.Clone(request.Context())
+	request.URL.Scheme = rt.target.Scheme
+	request.URL.Host = rt.target.Host
+	return http.DefaultTransport.RoundTrip(request)
+}
+
+func TestAccountEmails(t *testing.T) {
+	t.Setenv("AUTH0_INTOUCH_CLIENT_SECRET", "client-secret")
+
+	users := []Auth0User{
+		{UserId: "auth0|1", Username: "jadk157", Email: "jadk157@gmail.com"},
+		{UserId: "auth0|2", Username: "arden", Email: "arden@example.com", EmailVerified: true},
+	}
+
+	smtpServer, smtpAddr := startSMTPServer(t)
+	auth0Server := fakeAuth0(t, smtpAddr, users)
+	target, err := url.Parse(auth0Server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := auth0Client
+	auth0Client = &http.Client{Transport: redirectTransport{target}}
+	t.Cleanup(func() { auth0Client = client })
+
+	tests := []struct {
+		name    string
+		send    func() error
+		err     error
+		to      string // "" if no email should be sent
+		subject string
+		link    string
+	}{
+		{
+			name:    "verification email",
+			send:    func() error { return SendVerificationEmail("jadk157") },
+			to:      "jadk157@gmail.com",
+			subject: "Subject: Verify your email",
+			link:    "/u/email-verification?user=auth0%7C1",
+		},
+		{
+			name: "already verified",
+			send: func() error { return SendVerificationEmail("arden") },
+		},
+		{
+			name: "unknown username",
+			send: func() error { return SendVerificationEmail("nobody") },
+			err:  ErrUserNotFound,
+		},
+		{
+			name:    "password reset",
+			send:    func() error { return SendPasswordReset(" arden@example.com ") },
+			to:      "arden@example.com",
+			subject: "Subject: Reset your password",
+			link:    "/lo/reset?user=auth0%7C2",
+		},
+		{
+			name: "password reset for an unknown email",
+			send: func() error { return SendPasswordReset("nobody@example.com") },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sent := len(smtpServer.Messages())
+
+			if err := test.send(); err != test.err {
+				t.Fatalf("error = %v, want %v", err, test.err)
+			}
+
+			messages := smtpServer.Messages()[sent:]
+			if test.to == "" {
+				if len(messages) != 0 {
+					t.Fatalf("sent %d emails, want none", len(messages))
+				}
+				return
+			}
+
+			if len(messages) != 1 {
+				t.Fatalf("sent %d emails, want 1", len(messages))
+			}
+			message := messages[0]
+			if !reflect.DeepEqual(message.Recipients, []string{test.to}) {
+				t.Errorf("email sent to %v, want %s", message.Recipients, test.to)
+			}
+			if !strings.Contains(message.Data, test.subject) || !strings.Contains(message.Data, test.link) {
+				t.Errorf("email = %q, want %q with a link to %s", message.Data, test.subject, test.link)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+// Package photos prepares photos that families upload to be printed in
+// letters.
+package photos
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	_ "image/jpeg" // Registers the formats we accept with image.Decode
+	_ "image/png"
+	"net/http"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	SizeWallet = "wallet" // 2.5 x 3.5 in
+	Size4x6    = "4x6"
+	Size5x7    = "5x7"
+	SizeFull   = "full" // As large as fits inside the page margins
+
+	// Lob prints at 300 DPI, so there's no point in keeping more pixels
+	PrintDPI = 300
+
+	MaxUploadSize = 15 << 20
+	MaxPixels     = 50000000 // Guards against images that decode to gigabytes
+
+	ContentType = "image/jpeg"
+	jpegQuality = 90
+)
+
+var (
+	ErrUnsupportedImage = errors.New("Photos must be JPEG or PNG images")
+	ErrImageTooLarge    = errors.New("The photo is too large. Upload a photo smaller than 15MB.")
+	ErrInvalidSize      = errors.New("Photo size must be wallet, 4x6, 5x7 or full")
+
+	// The long and short edge of each print size in inches, times 10
+	printSizes = map[string][2]int{
+		SizeWallet: {35, 25},
+		Size4x6:    {60, 40},
+		Size5x7:    {70, 50},
+		SizeFull:   {100, 75},
+	}
+)
+
+// Processed is an uploaded photo ready to be stored. Original is the full
+// photo and Print the one cropped to its print size; both are re-encoded
+// from the decoded pixels, which leaves behind all EXIF data, including the
+// GPS position phones record.
+type Processed struct {
+	Original []byte
+	Print    []byte
+	Width    int // Of the print, in pixels
+	Height   int
+}
+
+func IsValidSize(size string) bool {
+	_, ok := printSizes[size]
+	return ok
+}
+
+// GetPrintInches returns the width and height the photo is printed at, in
+// tenths of an inch. Full page photos are always fit into a portrait page.
+func GetPrintInches(size string, landscape bool) (int, int) {
+	edges := printSizes[size]
+	if landscape && size != SizeFull {
+		return edges[0], edges[1]
+	}
+	return edges[1], edges[0]
+}
+
+// Process turns an upload into a photo that can be printed: it is turned
+// upright according to its EXIF orientation and cropped around its center
+// to the print size, in the print's orientation. A full page photo is
+// scaled to fit the page instead of cropped.
+func Process(data []byte, size string, grayscale bool) (Processed, error) {
+	if len(data) > MaxUploadSize {
+		return Processed{}, ErrImageTooLarge
+	}
+
+	if !IsValidSize(size) {
+		return Processed{}, ErrInvalidSize
+	}
+
+	switch http.DetectContentType(data) {
+	case "image/jpeg", "image/png":
+	default:
+		return Processed{}, ErrUnsupportedImage
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return Processed{}, ErrUnsupportedImage
+	}
+
+	if config.Width*config.Height > MaxPixels {
+		return Processed{}, ErrImageTooLarge
+	}
+
+	img, err := imaging.Decode(bytes.NewReader(data), imaging.AutoOrientation(true))
+	if err != nil {
+		return Processed{}, ErrUnsupportedImage
+	}
+
+	bounds := img.Bounds()
+	width, height := GetPrintInches(size, bounds.Dx() > bounds.Dy())
+	width = width * PrintDPI / 10
+	height = height * PrintDPI / 10
+
+	var print image.Image
+	if size == SizeFull {
+		print = imaging.Fit(img, width, height, imaging.Lanczos)
+	} else {
+		print = imaging.Fill(img, width, height, imaging.Center, imaging.Lanczos)
+	}
+
+	if grayscale {
+		print = imaging.Grayscale(print)
+	}
+
+	processed := Processed{
+		Width:  print.Bounds().Dx(),
+		Height: print.Bounds().Dy(),
+	}
+
+	processed.Original, err = encode(img)
+	if err != nil {
+		return Processed{}, err
+	}
+
+	processed.Print, err = encode(print)
+	if err != nil {
+		return Processed{}, err
+	}
+
+	return processed, nil
+}
+
+func encode(img image.Image) ([]byte, error) {
+	var buffer bytes.Buffer
+	err := imaging.Encode(&buffer, img, imaging.JPEG, imaging.JPEGQuality(jpegQuality))
+	if err != nil {
+		return []byte{}, err
+	}
+	return buffer.Bytes(), nil
+}
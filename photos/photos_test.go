@@ -0,0 +1,130 @@
+package photos
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// testImage is a colorful width x height image encoded as format
+func testImage(t *testing.T, format string, width int, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 200, 255})
+		}
+	}
+
+	var buffer bytes.Buffer
+	var err error
+	if format == "png" {
+		err = png.Encode(&buffer, img)
+	} else {
+		err = jpeg.Encode(&buffer, img, nil)
+	}
+	if err != nil {
+		t.Fatalf("encoding a test %s = %v", format, err)
+	}
+	return buffer.Bytes()
+}
+
+func TestGetPrintInches(t *testing.T) {
+	tests := []struct {
+		size       string
+		landscape  bool
+		wantWidth  int
+		wantHeight int
+	}{
+		{SizeWallet, false, 25, 35},
+		{SizeWallet, true, 35, 25},
+		{Size4x6, true, 60, 40},
+		{Size5x7, false, 50, 70},
+		{SizeFull, false, 75, 100},
+		{SizeFull, true, 75, 100},
+	}
+
+	for _, test := range tests {
+		width, height := GetPrintInches(test.size, test.landscape)
+		if width != test.wantWidth || height != test.wantHeight {
+			t.Errorf("GetPrintInches(%q, %t) = %d, %d, want %d, %d", test.size, test.landscape, width, height, test.wantWidth, test.wantHeight)
+		}
+	}
+}
+
+func TestProcess(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       []byte
+		size       string
+		wantWidth  int
+		wantHeight int
+		err        error
+	}{
+		{"portrait jpeg cropped to wallet", testImage(t, "jpeg", 300, 400), SizeWallet, 750, 1050, nil},
+		{"landscape png cropped to 4x6", testImage(t, "png", 600, 300), Size4x6, 1800, 1200, nil},
+		{"portrait 5x7", testImage(t, "png", 200, 300), Size5x7, 1500, 2100, nil},
+		{"landscape scaled to fit a portrait page", testImage(t, "jpeg", 4500, 2000), SizeFull, 2250, 1000, nil},
+		{"small photo not enlarged to fit a page", testImage(t, "jpeg", 300, 600), SizeFull, 300, 600, nil},
+		{"invalid size", testImage(t, "jpeg", 30, 40), "8x10", 0, 0, ErrInvalidSize},
+		{"gif", []byte("GIF89a\x01\x00\x01\x00\x00\x00\x00;"), Size4x6, 0, 0, ErrUnsupportedImage},
+		{"not an image", []byte("Hi Dad"), Size4x6, 0, 0, ErrUnsupportedImage},
+		{"truncated jpeg", testImage(t, "jpeg", 30, 40)[:20], Size4x6, 0, 0, ErrUnsupportedImage},
+		{"upload too large", make([]byte, MaxUploadSize+1), Size4x6, 0, 0, ErrImageTooLarge},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			processed, err := Process(test.data, test.size, false)
+			if err != test.err {
+				t.Fatalf("Process() error = %v, want %v", err, test.err)
+			}
+			if err != nil {
+				return
+			}
+
+			if processed.Width != test.wantWidth || processed.Height != test.wantHeight {
+				t.Errorf("Process() = %dx%d, want %dx%d", processed.Width, processed.Height, test.wantWidth, test.wantHeight)
+			}
+
+			print, format, err := image.Decode(bytes.NewReader(processed.Print))
+			if err != nil || format != "jpeg" {
+				t.Fatalf("print is %q, %v, want a jpeg", format, err)
+			}
+			if print.Bounds().Dx() != processed.Width || print.Bounds().Dy() != processed.Height {
+				t.Errorf("print is %v, want %dx%d", print.Bounds(), processed.Width, processed.Height)
+			}
+
+			original, _, err := image.DecodeConfig(bytes.NewReader(processed.Original))
+			if err != nil {
+				t.Fatalf("original = %v", err)
+			}
+			source, _, _ := image.DecodeConfig(bytes.NewReader(test.data))
+			if original.Width != source.Width || original.Height != source.Height {
+				t.Errorf("original is %dx%d, want %dx%d", original.Width, original.Height, source.Width, source.Height)
+			}
+		})
+	}
+}
+
+func TestProcessGrayscale(t *testing.T) {
+	processed, err := Process(testImage(t, "png", 300, 200), Size4x6, true)
+	if err != nil {
+		t.Fatalf("Process() = %v", err)
+	}
+
+	print, _, err := image.Decode(bytes.NewReader(processed.Print))
+	if err != nil {
+		t.Fatalf("decoding the print = %v", err)
+	}
+
+	bounds := print.Bounds()
+	for _, point := range []image.Point{bounds.Min, bounds.Max.Sub(image.Pt(1, 1)), bounds.Min.Add(image.Pt(bounds.Dx()/2, bounds.Dy()/3))} {
+		r, g, b, _ := print.At(point.X, point.Y).RGBA()
+		if r != g || g != b {
+			t.Errorf("print pixel at %v = %d, %d, %d, want gray", point, r, g, b)
+		}
+	}
+}
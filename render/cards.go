@@ -0,0 +1,200 @@
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/johnamadeo/intouchgo/i18n"
+)
+
+const (
+	CardsFile = "cards.json"
+	CardsDir  = "cards"
+
+	// A card is one sheet, printed on both sides and folded in half: the
+	// outside with the front and back, then the inside
+	CardPages = 2
+
+	OccasionBirthday   = "birthday"
+	OccasionHoliday    = "holiday"
+	OccasionMothersDay = "mothers-day"
+	OccasionFathersDay = "fathers-day"
+
+	PaperWhite = "white"
+)
+
+var (
+	ErrCardNotFound    = errors.New("No card with requested id found")
+	ErrUnknownOccasion = errors.New("Occasion must be birthday, holiday, mothers-day or fathers-day")
+
+	Occasions = []string{OccasionBirthday, OccasionHoliday, OccasionMothersDay, OccasionFathersDay}
+
+	cards         []Card
+	cardTemplates map[string]*template.Template
+)
+
+// Card is a folded greeting card in templates/cards. Mailrooms look at a
+// card the way they look at stationery, so the registry records what they
+// check for: the color the paper looks once printed, and artwork printed to
+// look like glitter or stickers.
+type Card struct {
+	Id          string `json:"id"`
+	Occasion    string `json:"occasion"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	PaperColor  string `json:"paperColor"`
+	Glitter     bool   `json:"glitter"`
+	Stickers    bool   `json:"stickers"`
+	UsesColor   bool   `json:"usesColor"`
+	MaxMessage  int    `json:"maxMessage"` // In characters, so that the message fits inside
+}
+
+// cardEntry is a card as listed in the registry, with its template file
+type cardEntry struct {
+	Card
+	File string `json:"file"`
+}
+
+func IsOccasion(occasion string) bool {
+	for _, o := range Occasions {
+		if o == occasion {
+			return true
+		}
+	}
+	return false
+}
+
+// GetCards returns the cards for an occasion in the order of the registry,
+// or every card if occasion is ""
+func GetCards(occasion string) []Card {
+	templatesMutex.RLock()
+	defer templatesMutex.RUnlock()
+
+	found := []Card{}
+	for _, card := range cards {
+		if occasion == "" || card.Occasion == occasion {
+			found = append(found, card)
+		}
+	}
+	return found
+}
+
+func GetCard(id string) (Card, error) {
+	templatesMutex.RLock()
+	defer templatesMutex.RUnlock()
+
+	for _, card := range cards {
+		if card.Id == id {
+			return card, nil
+		}
+	}
+
+	if cardTemplates == nil {
+		return Card{}, ErrTemplatesNotLoaded
+	}
+	return Card{}, ErrCardNotFound
+}
+
+// RenderCardHTML returns the complete HTML document of a card, with the
+// letter's text as the message inside
+func RenderCardHTML(cardId string, data LetterData) (string, error) {
+	templatesMutex.RLock()
+	tmpl, ok := cardTemplates[cardId]
+	loaded := cardTemplates != nil
+	templatesMutex.RUnlock()
+
+	if !loaded {
+		return "", ErrTemplatesNotLoaded
+	} else if !ok {
+		return "", ErrCardNotFound
+	}
+
+	var document bytes.Buffer
+	err := tmpl.Execute(&document, data)
+	if err != nil {
+		return "", err
+	}
+
+	return document.String(), nil
+}
+
+// loadCards reads the card registry in dir and parses every card together
+// with the shared partials, checking them the way LoadTemplates checks
+// themes
+func loadCards(dir string) ([]Card, map[string]*template.Template, error) {
+	bytes, err := ioutil.ReadFile(filepath.Join(dir, CardsFile))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	registry := []cardEntry{}
+	err = json.Unmarshal(bytes, &registry)
+	if err != nil {
+		return nil, nil, errors.New(CardsFile + ": " + err.Error())
+	}
+
+	parsed := make(map[string]*template.Template)
+	loaded := []Card{}
+	for _, card := range registry {
+		if card.Id == "" || card.File == "" {
+			return nil, nil, errors.New(CardsFile + ": every card needs an id and a file")
+		} else if _, ok := parsed[card.Id]; ok {
+			return nil, nil, errors.New(CardsFile + ": card " + card.Id + " is listed twice")
+		} else if !IsOccasion(card.Occasion) {
+			return nil, nil, errors.New(CardsFile + ": card " + card.Id + " has an unknown occasion")
+		} else if card.PaperColor == "" || card.MaxMessage <= 0 {
+			return nil, nil, errors.New(CardsFile + ": card " + card.Id + " needs a paper color and a message length")
+		}
+
+		tmpl, err := template.New(card.File).
+			Funcs(templateFuncs).
+			Option("missingkey=error").
+			ParseFiles(filepath.Join(dir, CardsDir, card.File))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		tmpl, err = tmpl.ParseGlob(filepath.Join(dir, PartialsPattern))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		err = validateCardTemplate(tmpl)
+		if err != nil {
+			return nil, nil, errors.New(card.File + ": " + err.Error())
+		}
+
+		parsed[card.Id] = tmpl
+		loaded = append(loaded, card.Card)
+	}
+
+	return loaded, parsed, nil
+}
+
+func validateCardTemplate(tmpl *template.Template) error {
+	for _, locale := range i18n.Locales() {
+		sample := NewLetterData(locale, "sample-author", "sample-recipient", "sample-subject", "sample-time", FormatPlain, "sample-text")
+
+		var document bytes.Buffer
+		err := tmpl.Execute(&document, sample)
+		if err != nil {
+			return err
+		}
+
+		for _, value := range []string{"sample-author", "sample-text", footerMarker} {
+			if !strings.Contains(document.String(), value) {
+				return errors.New("the card never shows " + value + " in " + locale)
+			}
+		}
+
+		if strings.Contains(document.String(), "{{") {
+			return errors.New("the card still contains a Lob merge variable")
+		}
+	}
+	return nil
+}
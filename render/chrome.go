@@ -0,0 +1,139 @@
+// Package render lays out letters locally in headless Chrome, the same way
+// Lob does, so that users can see a letter before paying to send it.
+package render
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/emulation"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+	"github.com/chromedp/chromedp/runner"
+)
+
+const (
+	// Lob prints letters on US letter paper
+	PageWidthInches  = 8.5
+	PageHeightInches = 11
+	CSSPixelsPerInch = 96
+
+	// Each render starts its own Chrome, which takes a few hundred MB, so
+	// only a couple may run at once
+	MaxConcurrentRenders = 2
+	RenderTimeout        = 30 * time.Second
+)
+
+var (
+	ErrChromeUnavailable = errors.New("Letter previews are unavailable right now")
+
+	renderSlots = make(chan struct{}, MaxConcurrentRenders)
+
+	// Matches each page object of a PDF but not the /Pages tree
+	pdfPageObject = regexp.MustCompile(`/Type\s*/Page[^s]`)
+)
+
+type Result struct {
+	PDF       []byte
+	Pages     [][]byte // PNG image of each page
+	PageCount int
+}
+
+// RenderLetter prints an HTML document to a PDF and a PNG per page. The PDF
+// is what Lob would print; the PNGs are screenshots of the same document laid
+// out at page size, meant for showing on a phone, and can differ slightly
+// from the PDF where the document has print-only styles.
+func RenderLetter(html string) (Result, error) {
+	ctxt, cancel := context.WithTimeout(context.Background(), RenderTimeout)
+	defer cancel()
+
+	select {
+	case renderSlots <- struct{}{}:
+		defer func() { <-renderSlots }()
+	case <-ctxt.Done():
+		return Result{}, ErrChromeUnavailable
+	}
+
+	chrome, err := chromedp.New(ctxt, chromedp.WithRunnerOptions(
+		runner.Headless,
+		runner.DisableGPU,
+		runner.NoSandbox,
+		runner.NoFirstRun,
+		runner.NoDefaultBrowserCheck,
+	))
+	if err != nil {
+		return Result{}, ErrChromeUnavailable
+	}
+	defer func() {
+		chrome.Shutdown(ctxt)
+		chrome.Wait()
+	}()
+
+	result := Result{}
+	err = chrome.Run(ctxt, chromedp.Tasks{
+		chromedp.Navigate("data:text/html;charset=utf-8;base64," + base64.StdEncoding.EncodeToString([]byte(html))),
+		chromedp.WaitReady("body", chromedp.ByQuery),
+		chromedp.ActionFunc(func(ctxt context.Context, h cdp.Executor) error {
+			return printPages(ctxt, h, &result)
+		}),
+	})
+	if err != nil {
+		return Result{}, err
+	}
+
+	return result, nil
+}
+
+func printPages(ctxt context.Context, h cdp.Executor, result *Result) error {
+	pdf, err := page.PrintToPDF().
+		WithPaperWidth(PageWidthInches).
+		WithPaperHeight(PageHeightInches).
+		WithMarginTop(0).
+		WithMarginBottom(0).
+		WithMarginLeft(0).
+		WithMarginRight(0).
+		WithPrintBackground(true).
+		Do(ctxt, h)
+	if err != nil {
+		return err
+	}
+
+	result.PDF = pdf
+	result.PageCount = CountPDFPages(pdf)
+
+	width := float64(PageWidthInches * CSSPixelsPerInch)
+	height := float64(PageHeightInches * CSSPixelsPerInch)
+
+	err = emulation.SetEmulatedMedia("print").Do(ctxt, h)
+	if err != nil {
+		return err
+	}
+
+	err = emulation.SetDeviceMetricsOverride(int64(width), int64(height)*int64(result.PageCount), 1, false).Do(ctxt, h)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < result.PageCount; i++ {
+		png, err := page.CaptureScreenshot().
+			WithFormat(page.CaptureScreenshotFormatPng).
+			WithClip(&page.Viewport{X: 0, Y: float64(i) * height, Width: width, Height: height, Scale: 1}).
+			Do(ctxt, h)
+		if err != nil {
+			return err
+		}
+		result.Pages = append(result.Pages, png)
+	}
+
+	return nil
+}
+
+// CountPDFPages counts the page objects in a PDF produced by Chrome, which
+// writes them uncompressed
+func CountPDFPages(pdf []byte) int {
+	return len(pdfPageObject.FindAllIndex(pdf, -1))
+}
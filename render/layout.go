@@ -0,0 +1,166 @@
+package render
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+// Layout is roughly how much text fits on a page of a theme, measured from
+// its printed PDFs. FirstPageLines is what's left for the text on the first
+// page after the margin for the address and the header.
+type Layout struct {
+	CharsPerLine   int `json:"charsPerLine"`
+	LinesPerPage   int `json:"linesPerPage"`
+	FirstPageLines int `json:"firstPageLines"`
+}
+
+var (
+	ErrTextTooLong = errors.New("A single paragraph of the letter is too long to fit")
+
+	// Large print overrides every theme's font sizes, so it fits the same
+	// amount of text on a page whatever the theme
+	LargePrintLayout = Layout{CharsPerLine: 60, LinesPerPage: 26, FirstPageLines: 14}
+)
+
+func (l Layout) valid() bool {
+	return l.CharsPerLine > 0 && l.LinesPerPage > 0 && l.FirstPageLines > 0
+}
+
+// EstimatePages predicts how many pages the text of a letter fills in the
+// theme without rendering it. It errs on the side of too many pages.
+func EstimatePages(themeId string, format string, text string, largePrint bool) (int, error) {
+	layout, err := getLayout(themeId, largePrint)
+	if err != nil {
+		return 0, err
+	}
+	return estimatePages(layout, format, text), nil
+}
+
+// SplitText divides the text of a letter at paragraph breaks into as few
+// parts as possible that each fit in maxPages. Paragraphs too long for a
+// part on their own are divided between words.
+func SplitText(themeId string, format string, text string, largePrint bool, maxPages int) ([]string, error) {
+	layout, err := getLayout(themeId, largePrint)
+	if err != nil {
+		return []string{}, err
+	}
+
+	fits := func(chunks []string) bool {
+		return estimatePages(layout, format, strings.Join(chunks, "\n\n")) <= maxPages
+	}
+
+	parts := []string{}
+	current := []string{}
+	for _, chunk := range paragraphBreak.Split(normalizeNewlines(text), -1) {
+		if fits(append(current[:len(current):len(current)], chunk)) {
+			current = append(current, chunk)
+			continue
+		}
+
+		if len(current) > 0 {
+			parts = append(parts, strings.Join(current, "\n\n"))
+			current = []string{}
+		}
+
+		if fits([]string{chunk}) {
+			current = []string{chunk}
+			continue
+		}
+
+		piece := ""
+		for _, word := range strings.Fields(chunk) {
+			longer := strings.TrimSpace(piece + " " + word)
+			if fits([]string{longer}) {
+				piece = longer
+				continue
+			}
+
+			if piece == "" {
+				return []string{}, ErrTextTooLong
+			}
+			parts = append(parts, piece)
+			piece = word
+		}
+		current = []string{piece}
+	}
+
+	if len(current) > 0 {
+		parts = append(parts, strings.Join(current, "\n\n"))
+	}
+	return parts, nil
+}
+
+func getLayout(themeId string, largePrint bool) (Layout, error) {
+	theme, err := GetTheme(themeId)
+	if err != nil {
+		return Layout{}, err
+	}
+
+	if largePrint {
+		return LargePrintLayout, nil
+	}
+	return theme.Layout, nil
+}
+
+func estimatePages(layout Layout, format string, text string) int {
+	lines := estimateLines(layout, format, text)
+	if lines <= layout.FirstPageLines {
+		return 1
+	}
+
+	rest := lines - layout.FirstPageLines
+	return 1 + (rest+layout.LinesPerPage-1)/layout.LinesPerPage
+}
+
+// estimateLines counts the printed lines of the text, with a blank line
+// between blocks. Headings count double for their larger font and list
+// items lose a little width to their bullets.
+func estimateLines(layout Layout, format string, text string) int {
+	text = normalizeNewlines(text)
+	if text == "" {
+		return 0
+	}
+
+	blocks := []markdownBlock{}
+	if format == FormatMarkdown {
+		blocks = parseMarkdown(text)
+	} else {
+		for _, paragraph := range paragraphBreak.Split(text, -1) {
+			blocks = append(blocks, markdownBlock{kind: "p", lines: strings.Split(paragraph, "\n")})
+		}
+	}
+
+	lines := 0
+	for i, block := range blocks {
+		if i > 0 {
+			lines++
+		}
+
+		switch block.kind {
+		case "p":
+			for _, line := range block.lines {
+				lines += wrappedLines(line, layout.CharsPerLine)
+			}
+		case "ul", "ol":
+			for _, item := range block.lines {
+				lines += wrappedLines(item, layout.CharsPerLine-4)
+			}
+		default:
+			lines += 2 * wrappedLines(block.lines[0], layout.CharsPerLine*2/3)
+		}
+	}
+	return lines
+}
+
+func wrappedLines(line string, charsPerLine int) int {
+	if charsPerLine < 1 {
+		charsPerLine = 1
+	}
+
+	length := utf8.RuneCountInString(strings.TrimSpace(line))
+	if length == 0 {
+		return 1
+	}
+	return (length + charsPerLine - 1) / charsPerLine
+}
@@ -0,0 +1,140 @@
+package render
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/johnamadeo/intouchgo/i18n"
+)
+
+func loadTestTemplates(t *testing.T) {
+	err := i18n.LoadCatalogs("../locales")
+	if err != nil {
+		t.Fatalf("LoadCatalogs() = %v", err)
+	}
+
+	err = LoadTemplates("../templates")
+	if err != nil {
+		t.Fatalf("LoadTemplates() = %v", err)
+	}
+}
+
+func TestEstimatePagesLayout(t *testing.T) {
+	layout := Layout{CharsPerLine: 10, LinesPerPage: 4, FirstPageLines: 2}
+
+	tests := []struct {
+		name   string
+		format string
+		text   string
+		want   int
+	}{
+		{"empty", FormatPlain, "", 1},
+		{"one line", FormatPlain, "Hi Dad", 1},
+		{"line breaks", FormatPlain, "Hi\nDad\nLove", 2},
+		{"paragraphs", FormatPlain, "Hi\n\nDad", 2},
+		{"wrapped line", FormatPlain, strings.Repeat("a", 25), 2},
+		{"first page and a full page", FormatPlain, strings.Repeat("a", 60), 2},
+		{"a line on a third page", FormatPlain, strings.Repeat("a", 61), 3},
+		{"heading counts double", FormatMarkdown, "# Title", 1},
+		{"heading is narrower", FormatMarkdown, "# Long title", 2},
+		{"list items lose width", FormatMarkdown, "- abcdefgh\n- x", 2},
+		{"markdown as plain", FormatPlain, "- abcdefgh\n- x", 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := estimatePages(layout, test.format, test.text); got != test.want {
+				t.Errorf("estimatePages(%q) = %d, want %d", test.text, got, test.want)
+			}
+		})
+	}
+}
+
+func TestEstimatePages(t *testing.T) {
+	loadTestTemplates(t)
+
+	paragraph := strings.Repeat("a", 100)
+	long := strings.Repeat(paragraph+"\n\n", 29) + paragraph
+
+	tests := []struct {
+		name       string
+		themeId    string
+		text       string
+		largePrint bool
+		want       int
+		err        error
+	}{
+		{"short letter", "plain", "Hi Dad", false, 1, nil},
+		{"long letter", "plain", long, false, 2, nil},
+		{"large print", "plain", long, true, 4, nil},
+		{"unknown theme", "no-such-theme", "Hi Dad", false, 0, ErrThemeNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := EstimatePages(test.themeId, FormatPlain, test.text, test.largePrint)
+			if err != test.err || got != test.want {
+				t.Errorf("EstimatePages() = %d, %v, want %d, %v", got, err, test.want, test.err)
+			}
+		})
+	}
+}
+
+func TestSplitText(t *testing.T) {
+	loadTestTemplates(t)
+
+	paragraph := strings.Repeat("a", 100)
+	paragraphs := make([]string, 30)
+	for i := range paragraphs {
+		paragraphs[i] = paragraph
+	}
+
+	words := strings.TrimSpace(strings.Repeat("word ", 6000))
+
+	tests := []struct {
+		name      string
+		text      string
+		wantParts []string
+		err       error
+	}{
+		{"fits in one part", "Hi Dad\n\nLove, Arden", []string{"Hi Dad\n\nLove, Arden"}, nil},
+		{
+			"split between paragraphs",
+			strings.Join(paragraphs, "\n\n"),
+			[]string{strings.Join(paragraphs[:23], "\n\n"), strings.Join(paragraphs[23:], "\n\n")},
+			nil,
+		},
+		{"word too long for a page", strings.Repeat("a", 110*47), nil, ErrTextTooLong},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parts, err := SplitText("plain", FormatPlain, test.text, false, 1)
+			if err != test.err {
+				t.Fatalf("SplitText() error = %v, want %v", err, test.err)
+			}
+			if test.err == nil && strings.Join(parts, "|") != strings.Join(test.wantParts, "|") {
+				t.Errorf("SplitText() = %d parts %q, want %d parts", len(parts), parts, len(test.wantParts))
+			}
+		})
+	}
+
+	t.Run("split between words", func(t *testing.T) {
+		parts, err := SplitText("plain", FormatPlain, words, false, 1)
+		if err != nil {
+			t.Fatalf("SplitText() = %v", err)
+		}
+		if len(parts) < 2 {
+			t.Fatalf("SplitText() = %d parts, want the paragraph divided", len(parts))
+		}
+
+		for i, part := range parts {
+			if pages, _ := EstimatePages("plain", FormatPlain, part, false); pages != 1 {
+				t.Errorf("part %d is %d pages, want 1", i+1, pages)
+			}
+		}
+		if strings.Join(parts, " ") != words {
+			t.Error("SplitText() lost or reordered words")
+		}
+	})
+}
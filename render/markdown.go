@@ -0,0 +1,286 @@
+package render
+
+import (
+	"errors"
+	"html/template"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	FormatPlain    = "plain"
+	FormatMarkdown = "markdown"
+)
+
+// Markdown letters only support what prints well and what facilities
+// accept: paragraphs, headings, bulleted and numbered lists, bold and
+// italics. Links are printed as their text; images aren't allowed at all.
+var (
+	ErrInvalidFormat = errors.New("Letter format must be plain or markdown")
+	ErrImageInText   = errors.New("Letters can't contain images. Remove the image from the text and try again.")
+	ErrUnsafeMarkup  = errors.New("The letter's formatting couldn't be printed safely. Send it as plain text instead.")
+
+	headingLine = regexp.MustCompile(`^(#{1,3})[ \t]+(.*?)[ \t#]*$`)
+	bulletLine  = regexp.MustCompile(`^[-*+][ \t]+(.*)$`)
+	numberLine  = regexp.MustCompile(`^([0-9]{1,3})[.)][ \t]+(.*)$`)
+
+	escapedChar  = regexp.MustCompile(`\\([\\*_#\[\]()!+.\-])`)
+	imagePattern = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	linkPattern  = regexp.MustCompile(`\[([^\]]*)\]\([^)]*\)`)
+	starBold     = regexp.MustCompile(`\*\*([^\s*](?:[^*]*[^\s*])?)\*\*`)
+	underBold    = regexp.MustCompile(`__([^\s_](?:[^_]*[^\s_])?)__`)
+	starItalic   = regexp.MustCompile(`\*([^\s*<>](?:[^*<>]*[^\s*<>])?)\*`)
+	underItalic  = regexp.MustCompile(`(^|[^\w])_([^\s_<>](?:[^_<>]*[^\s_<>])?)_([^\w]|$)`)
+
+	// Every tag renderMarkdownBody writes. A stray "<" matches on its own.
+	renderedTag = regexp.MustCompile(`<[^<>]*>|<`)
+	allowedTag  = regexp.MustCompile(`^<(?:/?(?:p|strong|em|h[234]|ul|ol|li)|br/|ol start="[0-9]+")>$`)
+)
+
+// Backslash escapes are swapped for characters from the private use area
+// while the inline patterns run, so that they can't match escaped markers.
+// Authors can't type private use characters themselves: they're removed
+// from Markdown letters before anything else.
+const (
+	escapeBase   = '\uE000'
+	escapedChars = "\\*_#[]()!+.-"
+)
+
+type markdownBlock struct {
+	kind  string   // "p", "h2", "h3", "h4", "ul" or "ol"
+	start int      // The first number of an "ol"
+	lines []string // The lines of a paragraph, the heading or the list items
+}
+
+// ValidFormat reports whether format is a letter format we can render
+func ValidFormat(format string) bool {
+	return format == "" || format == FormatPlain || format == FormatMarkdown
+}
+
+// CheckMarkdown returns an error for anything in a Markdown letter that
+// would be stripped in a way the author should know about, or that renders
+// to markup other than the formatting we support
+func CheckMarkdown(text string) error {
+	if imagePattern.MatchString(protectEscapes(removePrivateUse(text))) {
+		return ErrImageInText
+	}
+	return checkRenderedMarkdown(string(formatMarkdownBody(text)))
+}
+
+// PlainText returns the letter text without any formatting, for places
+// that can't show HTML. Lists keep their bullets and numbers.
+func PlainText(format string, text string) string {
+	text = normalizeNewlines(text)
+	if format != FormatMarkdown {
+		return text
+	}
+	text = removePrivateUse(text)
+
+	parts := []string{}
+	for _, block := range parseMarkdown(text) {
+		lines := []string{}
+		for i, line := range block.lines {
+			line = restoreEscapes(formatInline(protectEscapes(line), false))
+			switch block.kind {
+			case "ul":
+				line = "- " + line
+			case "ol":
+				line = strconv.Itoa(block.start+i) + ". " + line
+			}
+			lines = append(lines, line)
+		}
+		parts = append(parts, strings.Join(lines, "\n"))
+	}
+
+	return strings.Join(parts, "\n\n")
+}
+
+func renderPlainBody(text string) template.HTML {
+	var body strings.Builder
+	for _, paragraph := range paragraphBreak.Split(normalizeNewlines(text), -1) {
+		if strings.TrimSpace(paragraph) == "" {
+			continue
+		}
+		body.WriteString("<p>" + joinLines(strings.Split(paragraph, "\n"), escapeText) + "</p>\n")
+	}
+	return template.HTML(body.String())
+}
+
+// renderMarkdownBody falls back to plain text should the Markdown ever
+// render to markup other than the formatting we support, so that nothing
+// the author wrote reaches Lob or Chrome as HTML
+func renderMarkdownBody(text string) template.HTML {
+	body := formatMarkdownBody(text)
+	if checkRenderedMarkdown(string(body)) != nil {
+		return renderPlainBody(text)
+	}
+	return body
+}
+
+func formatMarkdownBody(text string) template.HTML {
+	inline := func(line string) template.HTML {
+		escaped := string(escapeText(protectEscapes(line)))
+		return template.HTML(restoreEscapes(formatInline(escaped, true)))
+	}
+
+	var body strings.Builder
+	for _, block := range parseMarkdown(removePrivateUse(normalizeNewlines(text))) {
+		switch block.kind {
+		case "p":
+			body.WriteString("<p>" + joinLines(block.lines, inline) + "</p>\n")
+		case "ul", "ol":
+			open := "<" + block.kind + ">"
+			if block.kind == "ol" && block.start != 1 {
+				open = `<ol start="` + strconv.Itoa(block.start) + `">`
+			}
+			body.WriteString(open + "\n")
+			for _, item := range block.lines {
+				body.WriteString("<li>" + string(inline(item)) + "</li>\n")
+			}
+			body.WriteString("</" + block.kind + ">\n")
+		default:
+			body.WriteString("<" + block.kind + ">" + string(inline(block.lines[0])) + "</" + block.kind + ">\n")
+		}
+	}
+	return template.HTML(body.String())
+}
+
+// parseMarkdown splits the text into blocks. Blank lines end paragraphs and
+// lists, indented lines continue the previous list item, and any other line
+// break within a paragraph is kept.
+func parseMarkdown(text string) []markdownBlock {
+	blocks := []markdownBlock{}
+	var current *markdownBlock
+
+	flush := func() {
+		if current != nil {
+			blocks = append(blocks, *current)
+			current = nil
+		}
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, " \t")
+		trimmed := strings.TrimLeft(line, " \t")
+		indented := len(trimmed) < len(line)
+
+		if trimmed == "" {
+			flush()
+			continue
+		}
+
+		if match := headingLine.FindStringSubmatch(trimmed); match != nil {
+			flush()
+			blocks = append(blocks, markdownBlock{
+				kind:  "h" + strconv.Itoa(len(match[1])+1),
+				lines: []string{match[2]},
+			})
+			continue
+		}
+
+		if match := bulletLine.FindStringSubmatch(trimmed); match != nil {
+			if current == nil || current.kind != "ul" {
+				flush()
+				current = &markdownBlock{kind: "ul"}
+			}
+			current.lines = append(current.lines, match[1])
+			continue
+		}
+
+		if match := numberLine.FindStringSubmatch(trimmed); match != nil {
+			if current == nil || current.kind != "ol" {
+				flush()
+				start, _ := strconv.Atoi(match[1])
+				current = &markdownBlock{kind: "ol", start: start}
+			}
+			current.lines = append(current.lines, match[2])
+			continue
+		}
+
+		if current != nil && (current.kind == "ul" || current.kind == "ol") {
+			if indented {
+				last := len(current.lines) - 1
+				current.lines[last] += " " + trimmed
+				continue
+			}
+			flush()
+		}
+
+		if current == nil {
+			current = &markdownBlock{kind: "p"}
+		}
+		current.lines = append(current.lines, line)
+	}
+
+	flush()
+	return blocks
+}
+
+// formatInline turns emphasis into tags, or drops the markers if html is
+// false, and replaces links and images with their text
+func formatInline(text string, html bool) string {
+	text = imagePattern.ReplaceAllString(text, "$1")
+	text = linkPattern.ReplaceAllString(text, "$1")
+
+	if html {
+		text = starBold.ReplaceAllString(text, "<strong>$1</strong>")
+		text = underBold.ReplaceAllString(text, "<strong>$1</strong>")
+		text = starItalic.ReplaceAllString(text, "<em>$1</em>")
+		return underItalic.ReplaceAllString(text, "$1<em>$2</em>$3")
+	}
+
+	text = starBold.ReplaceAllString(text, "$1")
+	text = underBold.ReplaceAllString(text, "$1")
+	text = starItalic.ReplaceAllString(text, "$1")
+	return underItalic.ReplaceAllString(text, "$1$2$3")
+}
+
+func protectEscapes(text string) string {
+	return escapedChar.ReplaceAllStringFunc(text, func(escape string) string {
+		return string(escapeBase + rune(escape[1]))
+	})
+}
+
+// restoreEscapes only gives back the characters protectEscapes swapped,
+// none of which mean anything in HTML
+func restoreEscapes(text string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= escapeBase && r < escapeBase+128 && strings.ContainsRune(escapedChars, r-escapeBase) {
+			return r - escapeBase
+		}
+		return r
+	}, text)
+}
+
+// removePrivateUse drops characters from the private use area, which
+// print as nothing anyway
+func removePrivateUse(text string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '\uE000' && r <= '\uF8FF' {
+			return -1
+		}
+		return r
+	}, text)
+}
+
+func checkRenderedMarkdown(html string) error {
+	for _, tag := range renderedTag.FindAllString(html, -1) {
+		if !allowedTag.MatchString(tag) {
+			return ErrUnsafeMarkup
+		}
+	}
+	return nil
+}
+
+func joinLines(lines []string, format func(string) template.HTML) string {
+	formatted := make([]string, len(lines))
+	for i, line := range lines {
+		formatted[i] = string(format(strings.TrimRight(line, " \t")))
+	}
+	return strings.Join(formatted, "<br/>")
+}
+
+func normalizeNewlines(text string) string {
+	return strings.Replace(strings.TrimSpace(text), "\r\n", "\n", -1)
+}
@@ -0,0 +1,92 @@
+package render
+
+import "testing"
+
+func TestCheckMarkdown(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want error
+	}{
+		{"formatting", "# Hi Dad\n\n**Arden** made the _soccer_ team!\n\n- goalie\n- captain", nil},
+		{"link", "See [the team](https://example.com/team)", nil},
+		{"html is escaped", "<script>alert(1)</script> & <b>bold</b>", nil},
+		{"image", "Look! ![Arden](https://example.com/arden.png)", ErrImageInText},
+		{"escaped image", "\\![Arden](https://example.com/arden.png)", nil},
+		{"escaped markers", "2 \\* 3 \\_ 4 \\# 5", nil},
+		{"private use characters", "\uE03Cscript\uE03E **bold** \uE022", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := CheckMarkdown(test.text)
+			if err != test.want {
+				t.Errorf("CheckMarkdown(%q) = %v, want %v", test.text, err, test.want)
+			}
+		})
+	}
+}
+
+func TestCheckRenderedMarkdown(t *testing.T) {
+	tests := []struct {
+		html string
+		want error
+	}{
+		{"<p><strong>Arden</strong> <em>made</em> it</p>\n<ol start=\"3\">\n<li>one</li>\n</ol>\n<h2>Hi</h2>\n<p>a<br/>b</p>", nil},
+		{"<p>&lt;script&gt;</p>", nil},
+		{"<p><script>alert(1)</script></p>", ErrUnsafeMarkup},
+		{"<p><em onclick=\"alert(1)\">hi</em></p>", ErrUnsafeMarkup},
+		{"<p>a < b</p>", ErrUnsafeMarkup},
+	}
+
+	for _, test := range tests {
+		if err := checkRenderedMarkdown(test.html); err != test.want {
+			t.Errorf("checkRenderedMarkdown(%q) = %v, want %v", test.html, err, test.want)
+		}
+	}
+}
+
+func TestPlainText(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		text   string
+		want   string
+	}{
+		{"plain is kept", FormatPlain, "**Hi** Dad\r\n# not a heading", "**Hi** Dad\n# not a heading"},
+		{"emphasis", FormatMarkdown, "**Arden** made the _soccer_ team", "Arden made the soccer team"},
+		{"heading", FormatMarkdown, "## Hi Dad ##\nHow are you?", "Hi Dad\n\nHow are you?"},
+		{"lists", FormatMarkdown, "- goalie\n- captain\n\n3. Monday\n4. Tuesday", "- goalie\n- captain\n\n3. Monday\n4. Tuesday"},
+		{"list item continued", FormatMarkdown, "- goalie and\n  captain", "- goalie and captain"},
+		{"links and images", FormatMarkdown, "[team](https://example.com) ![photo](https://example.com/a.png)", "team photo"},
+		{"escapes", FormatMarkdown, "2 \\* 3 \\* 4 and \\_not italic\\_", "2 * 3 * 4 and _not italic_"},
+		{"private use characters", FormatMarkdown, "Hi\uE02A Dad\uE000", "Hi Dad"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := PlainText(test.format, test.text); got != test.want {
+				t.Errorf("PlainText(%q, %q) = %q, want %q", test.format, test.text, got, test.want)
+			}
+		})
+	}
+}
+
+func TestRenderMarkdownBody(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"**Arden** & _Mom_", "<p><strong>Arden</strong> &amp; <em>Mom</em></p>\n"},
+		{"# Hi\n- a\n- b", "<h2>Hi</h2>\n<ul>\n<li>a</li>\n<li>b</li>\n</ul>\n"},
+		{"2. two\n3. three", "<ol start=\"2\">\n<li>two</li>\n<li>three</li>\n</ol>\n"},
+		{"<b>not bold</b>", "<p>&lt;b&gt;not bold&lt;/b&gt;</p>\n"},
+		{"\uE03Cb\uE03Ebold", "<p>bbold</p>\n"},
+	}
+
+	for _, test := range tests {
+		if got := string(renderMarkdownBody(test.text)); got != test.want {
+			t.Errorf("renderMarkdownBody(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
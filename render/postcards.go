@@ -0,0 +1,186 @@
+package render
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/johnamadeo/intouchgo/i18n"
+)
+
+const (
+	PostcardsPattern  = "postcards/*.html"
+	PostcardFrontFile = "front.html"
+	PostcardBackFile  = "back.html"
+)
+
+var ErrInvalidPostcardSize = errors.New("Postcard size must be 4x6, 6x9 or 6x11")
+
+// postcardSize is a size Lob prints postcards in. Width and Height include
+// the eighth of an inch of bleed on each side, and Lob keeps the right
+// AddressWidth inches of the back free for the address and postage.
+type postcardSize struct {
+	Width        float64
+	Height       float64
+	AddressWidth float64
+	MaxMessage   int // In characters, so that the message fits on the back
+}
+
+var postcardSizes = map[string]postcardSize{
+	"4x6":  {Width: 6.25, Height: 4.25, AddressWidth: 3.6, MaxMessage: 350},
+	"6x9":  {Width: 9.25, Height: 6.25, AddressWidth: 4.1, MaxMessage: 800},
+	"6x11": {Width: 11.25, Height: 6.25, AddressWidth: 4.1, MaxMessage: 1100},
+}
+
+// PostcardData is what the postcard templates are filled in with. Use
+// NewPostcardData to build it.
+type PostcardData struct {
+	Locale   string
+	Author   template.HTML
+	TimeSent template.HTML
+	Message  template.HTML
+
+	// The photo on the front, or "" for the plain front
+	FrontURL string
+
+	// CSS lengths of the whole card and of the message on its back
+	Width        string
+	Height       string
+	MessageWidth string
+}
+
+func ValidPostcardSize(size string) bool {
+	_, ok := postcardSizes[size]
+	return ok
+}
+
+// MaxPostcardMessage returns how many characters fit on the back of a
+// postcard of the given size
+func MaxPostcardMessage(size string) int {
+	return postcardSizes[size].MaxMessage
+}
+
+// NewPostcardData escapes everything the author wrote. The message is plain
+// text, split into paragraphs like a plain letter.
+func NewPostcardData(locale, author, timeSent, message, size string) (PostcardData, error) {
+	dimensions, ok := postcardSizes[size]
+	if !ok {
+		return PostcardData{}, ErrInvalidPostcardSize
+	}
+
+	if !i18n.IsSupported(locale) {
+		locale = i18n.DefaultLocale
+	}
+
+	return PostcardData{
+		Locale:       locale,
+		Author:       escapeText(author),
+		TimeSent:     escapeText(timeSent),
+		Message:      renderPlainBody(message),
+		Width:        formatInches(dimensions.Width),
+		Height:       formatInches(dimensions.Height),
+		MessageWidth: formatInches(dimensions.Width - dimensions.AddressWidth - 0.5),
+	}, nil
+}
+
+// RenderPostcardHTML returns the HTML documents of the front and the back
+// of a postcard, ready to be sent to Lob
+func RenderPostcardHTML(data PostcardData) (string, string, error) {
+	templatesMutex.RLock()
+	tmpl := postcardTemplates
+	templatesMutex.RUnlock()
+
+	if tmpl == nil {
+		return "", "", ErrTemplatesNotLoaded
+	}
+
+	var front, back bytes.Buffer
+	err := tmpl.ExecuteTemplate(&front, PostcardFrontFile, data)
+	if err != nil {
+		return "", "", err
+	}
+
+	err = tmpl.ExecuteTemplate(&back, PostcardBackFile, data)
+	if err != nil {
+		return "", "", err
+	}
+
+	return front.String(), back.String(), nil
+}
+
+// loadPostcardTemplates parses the front and back templates together with
+// the shared partials and checks them the way LoadTemplates checks themes
+func loadPostcardTemplates(dir string) (*template.Template, error) {
+	tmpl, err := template.New(PostcardFrontFile).
+		Funcs(templateFuncs).
+		Option("missingkey=error").
+		ParseGlob(filepath.Join(dir, PostcardsPattern))
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl, err = tmpl.ParseGlob(filepath.Join(dir, PartialsPattern))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range []string{PostcardFrontFile, PostcardBackFile} {
+		if tmpl.Lookup(name) == nil {
+			return nil, errors.New("postcards: " + name + " is missing")
+		}
+	}
+
+	for _, locale := range i18n.Locales() {
+		sample, err := NewPostcardData(locale, "sample-author", "sample-time", "sample-text", "4x6")
+		if err != nil {
+			return nil, err
+		}
+		sample.FrontURL = samplePhotoURL
+
+		var front, back bytes.Buffer
+		err = tmpl.ExecuteTemplate(&front, PostcardFrontFile, sample)
+		if err != nil {
+			return nil, err
+		}
+
+		err = tmpl.ExecuteTemplate(&back, PostcardBackFile, sample)
+		if err != nil {
+			return nil, err
+		}
+
+		if !strings.Contains(front.String(), samplePhotoURL) {
+			return nil, errors.New("postcards: the front never shows the photo in " + locale)
+		}
+
+		// Without a photo the front greets the reader from the author instead
+		sample.FrontURL = ""
+		front.Reset()
+		err = tmpl.ExecuteTemplate(&front, PostcardFrontFile, sample)
+		if err != nil {
+			return nil, err
+		}
+
+		if !strings.Contains(front.String(), "sample-author") {
+			return nil, errors.New("postcards: the plain front never shows sample-author in " + locale)
+		}
+
+		for _, value := range []string{"sample-author", "sample-time", "sample-text", footerMarker} {
+			if !strings.Contains(back.String(), value) {
+				return nil, errors.New("postcards: the back never shows " + value + " in " + locale)
+			}
+		}
+
+		if strings.Contains(front.String()+back.String(), "{{") {
+			return nil, errors.New("postcards: the templates still contain a Lob merge variable")
+		}
+	}
+
+	return tmpl, nil
+}
+
+func formatInches(inches float64) string {
+	return strconv.FormatFloat(inches, 'f', 2, 64) + "in"
+}
@@ -0,0 +1,336 @@
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/johnamadeo/intouchgo/i18n"
+)
+
+const (
+	TemplatesDir      = "./templates"
+	ThemesFile        = "themes.json"
+	ThemesDir         = "themes"
+	PartialsPattern   = "partials/*.html"
+	DefaultThemeId    = "plain"
+	LobLetterPageSize = "us_letter"
+
+	// Every theme must show this, which comes from the shared footer
+	footerMarker = "intouchproject.org"
+
+	// And this when the letter is in large print, from the accessibility
+	// partial
+	largePrintMarker = "intouch-large-print"
+
+	// And every photo, from the photos partial
+	samplePhotoURL = "https://example.com/sample-photo"
+)
+
+var (
+	ErrTemplatesNotLoaded = errors.New("Letter templates have not been loaded")
+	ErrThemeNotFound      = errors.New("No theme with requested id found")
+
+	templatesMutex sync.RWMutex
+	themes         []Theme
+	themeTemplates map[string]*template.Template
+
+	postcardTemplates *template.Template
+
+	paragraphBreak = regexp.MustCompile(`\n[ \t]*\n+`)
+
+	templateFuncs = template.FuncMap{"t": translate}
+)
+
+// Theme describes one of the stationery templates in templates/themes.
+// UsesColor and HeavyImages let facilities that only accept plain mail rule
+// the theme out, and Layout is used to estimate the length of letters.
+type Theme struct {
+	Id          string   `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	PageSizes   []string `json:"pageSizes"`
+	UsesColor   bool     `json:"usesColor"`
+	HeavyImages bool     `json:"heavyImages"`
+	Layout      Layout   `json:"layout"`
+}
+
+// themeEntry is a theme as listed in the registry, with its template file
+type themeEntry struct {
+	Theme
+	File string `json:"file"`
+}
+
+// LetterData is what a letter template is filled in with. Use NewLetterData
+// to build it from the letter's text.
+type LetterData struct {
+	Locale    string // Which catalog the template's own text comes from
+	Author    template.HTML
+	Recipient template.HTML
+	Subject   template.HTML
+	TimeSent  template.HTML
+	Body      template.HTML // The paragraphs, headings and lists of the text
+
+	// Overrides the theme with bigger, more widely spaced black text
+	LargePrint bool
+
+	// Printed after the text, one per page
+	Photos []PhotoPage
+}
+
+// PhotoPage is a photo printed on its own page. Lob downloads it from URL,
+// since letters with photos inlined are too large to send.
+type PhotoPage struct {
+	URL    string
+	Width  string // CSS lengths, e.g. "4.00in"
+	Height string
+}
+
+// NewLetterData escapes everything the author wrote and renders the text in
+// its format. Plain text is split into paragraphs at blank lines, keeping
+// single line breaks within a paragraph; see markdown.go for Markdown.
+func NewLetterData(locale, author, recipient, subject, timeSent, format, text string) LetterData {
+	if !i18n.IsSupported(locale) {
+		locale = i18n.DefaultLocale
+	}
+
+	data := LetterData{
+		Locale:    locale,
+		Author:    escapeText(author),
+		Recipient: escapeText(recipient),
+		Subject:   escapeText(subject),
+		TimeSent:  escapeText(timeSent),
+	}
+
+	if format == FormatMarkdown {
+		data.Body = renderMarkdownBody(text)
+	} else {
+		data.Body = renderPlainBody(text)
+	}
+
+	return data
+}
+
+// escapeText HTML-escapes user content. Braces are escaped as well because
+// Lob treats {{...}} anywhere in a letter as a merge variable and rejects
+// letters that use one it wasn't given.
+func escapeText(text string) template.HTML {
+	escaped := template.HTMLEscapeString(text)
+	escaped = strings.Replace(escaped, "{", "&#123;", -1)
+	escaped = strings.Replace(escaped, "}", "&#125;", -1)
+	return template.HTML(escaped)
+}
+
+// translate is the "t" function of letter templates. Its arguments are
+// already escaped, so only the message from the catalog is.
+func translate(locale string, key string, args ...template.HTML) template.HTML {
+	message := escapeText(i18n.T(locale, key))
+	if len(args) == 0 {
+		return message
+	}
+
+	values := make([]interface{}, len(args))
+	for i, arg := range args {
+		values[i] = arg
+	}
+	return template.HTML(fmt.Sprintf(string(message), values...))
+}
+
+// LoadTemplates reads the theme and card registries in dir and parses every
+// theme and card, and the postcard templates, together with the shared
+// partials, checking that each renders completely in every locale, so that
+// a broken template stops the server at startup rather than failing every
+// letter. The message catalogs must be loaded first. It can be called again
+// to reload.
+func LoadTemplates(dir string) error {
+	bytes, err := ioutil.ReadFile(filepath.Join(dir, ThemesFile))
+	if err != nil {
+		return err
+	}
+
+	registry := []themeEntry{}
+	err = json.Unmarshal(bytes, &registry)
+	if err != nil {
+		return errors.New(ThemesFile + ": " + err.Error())
+	}
+
+	parsed := make(map[string]*template.Template)
+	loaded := []Theme{}
+	for _, theme := range registry {
+		if theme.Id == "" || theme.File == "" {
+			return errors.New(ThemesFile + ": every theme needs an id and a file")
+		} else if _, ok := parsed[theme.Id]; ok {
+			return errors.New(ThemesFile + ": theme " + theme.Id + " is listed twice")
+		} else if !theme.Layout.valid() {
+			return errors.New(ThemesFile + ": theme " + theme.Id + " needs a layout")
+		} else if !theme.SupportsPageSize(LobLetterPageSize) {
+			return errors.New(ThemesFile + ": theme " + theme.Id + " must support " + LobLetterPageSize)
+		}
+
+		tmpl, err := template.New(theme.File).
+			Funcs(templateFuncs).
+			Option("missingkey=error").
+			ParseFiles(filepath.Join(dir, ThemesDir, theme.File))
+		if err != nil {
+			return err
+		}
+
+		tmpl, err = tmpl.ParseGlob(filepath.Join(dir, PartialsPattern))
+		if err != nil {
+			return err
+		}
+
+		err = validateLetterTemplate(tmpl)
+		if err != nil {
+			return errors.New(theme.File + ": " + err.Error())
+		}
+
+		parsed[theme.Id] = tmpl
+		loaded = append(loaded, theme.Theme)
+	}
+
+	if _, ok := parsed[DefaultThemeId]; !ok {
+		return errors.New(ThemesFile + ": the " + DefaultThemeId + " theme is missing")
+	}
+
+	postcards, err := loadPostcardTemplates(dir)
+	if err != nil {
+		return err
+	}
+
+	loadedCards, parsedCards, err := loadCards(dir)
+	if err != nil {
+		return err
+	}
+
+	templatesMutex.Lock()
+	themes = loaded
+	themeTemplates = parsed
+	postcardTemplates = postcards
+	cards = loadedCards
+	cardTemplates = parsedCards
+	templatesMutex.Unlock()
+	return nil
+}
+
+func (t Theme) SupportsPageSize(pageSize string) bool {
+	for _, size := range t.PageSizes {
+		if size == pageSize {
+			return true
+		}
+	}
+	return false
+}
+
+// GetThemes returns every theme in the order of the registry
+func GetThemes() []Theme {
+	templatesMutex.RLock()
+	defer templatesMutex.RUnlock()
+
+	return append([]Theme{}, themes...)
+}
+
+// GetTheme returns the theme with the given id; "" is the default theme
+func GetTheme(id string) (Theme, error) {
+	if id == "" {
+		id = DefaultThemeId
+	}
+
+	templatesMutex.RLock()
+	defer templatesMutex.RUnlock()
+
+	for _, theme := range themes {
+		if theme.Id == id {
+			return theme, nil
+		}
+	}
+
+	if themeTemplates == nil {
+		return Theme{}, ErrTemplatesNotLoaded
+	}
+	return Theme{}, ErrThemeNotFound
+}
+
+func validateLetterTemplate(tmpl *template.Template) error {
+	locales := i18n.Locales()
+	if len(locales) == 0 {
+		return i18n.ErrCatalogsNotLoaded
+	}
+
+	for _, locale := range locales {
+		sample := NewLetterData(locale, "sample-author", "sample-recipient", "sample-subject", "sample-time", FormatPlain, "sample-text")
+
+		var document bytes.Buffer
+		err := tmpl.Execute(&document, sample)
+		if err != nil {
+			return err
+		}
+
+		for _, value := range []string{"sample-author", "sample-recipient", "sample-subject", "sample-time", "sample-text", footerMarker} {
+			if !strings.Contains(document.String(), value) {
+				return errors.New("the template never shows " + value + " in " + locale)
+			}
+		}
+
+		if strings.Contains(document.String(), "{{") {
+			return errors.New("the template still contains a Lob merge variable")
+		}
+
+		sample.LargePrint = true
+		document.Reset()
+		err = tmpl.Execute(&document, sample)
+		if err != nil {
+			return err
+		}
+
+		if !strings.Contains(document.String(), largePrintMarker) {
+			return errors.New("the template doesn't include the accessibility partial")
+		}
+
+		sample.Photos = []PhotoPage{{URL: samplePhotoURL, Width: "4.00in", Height: "6.00in"}}
+		document.Reset()
+		err = tmpl.Execute(&document, sample)
+		if err != nil {
+			return err
+		}
+
+		if !strings.Contains(document.String(), samplePhotoURL) {
+			return errors.New("the template doesn't include the photos partial")
+		}
+	}
+	return nil
+}
+
+// RenderLetterHTML returns the complete HTML document of a letter in the
+// given theme, ready to be sent to Lob or printed locally
+func RenderLetterHTML(themeId string, data LetterData) (string, error) {
+	if themeId == "" {
+		themeId = DefaultThemeId
+	}
+
+	templatesMutex.RLock()
+	tmpl, ok := themeTemplates[themeId]
+	loaded := themeTemplates != nil
+	templatesMutex.RUnlock()
+
+	if !loaded {
+		return "", ErrTemplatesNotLoaded
+	} else if !ok {
+		return "", ErrThemeNotFound
+	}
+
+	var document bytes.Buffer
+	err := tmpl.Execute(&document, data)
+	if err != nil {
+		return "", err
+	}
+
+	return document.String(), nil
+}
@@ -0,0 +1,169 @@
+package resilient
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	StateClosed   = "closed"
+	StateOpen     = "open"
+	StateHalfOpen = "half-open"
+
+	DefaultFailureThreshold = 5
+	DefaultCooldown         = 30 * time.Second
+)
+
+// Breaker is a circuit breaker. After FailureThreshold consecutive failures
+// it opens and rejects every call for Cooldown, after which a single trial
+// call is let through: if it succeeds the breaker closes again, otherwise it
+// goes back to being open.
+type Breaker struct {
+	Name             string
+	FailureThreshold int
+	Cooldown         time.Duration
+
+	mutex         sync.Mutex
+	state         string
+	failures      int
+	openedAt      time.Time
+	trialInFlight bool
+	lastError     string
+	lastFailure   time.Time
+}
+
+// BreakerStatus is a snapshot of a breaker, as reported on the admin status
+// endpoint
+type BreakerStatus struct {
+	Name                string `json:"name"`
+	State               string `json:"state"`
+	ConsecutiveFailures int    `json:"consecutiveFailures"`
+	OpenedAt            string `json:"openedAt,omitempty"`
+	LastFailure         string `json:"lastFailure,omitempty"`
+	LastError           string `json:"lastError,omitempty"`
+}
+
+type CircuitOpenError struct {
+	Name string
+}
+
+func (e *CircuitOpenError) Error() string {
+	return "Circuit breaker for " + e.Name + " is open; not sending request"
+}
+
+var (
+	registryMutex sync.Mutex
+	registry      = map[string]*Breaker{}
+)
+
+// GetBreaker returns the breaker registered under name, creating it with the
+// default settings if this is the first time it has been asked for. Clients
+// that talk to the same service share a breaker.
+func GetBreaker(name string) *Breaker {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	breaker, ok := registry[name]
+	if !ok {
+		breaker = &Breaker{
+			Name:             name,
+			FailureThreshold: DefaultFailureThreshold,
+			Cooldown:         DefaultCooldown,
+			state:            StateClosed,
+		}
+		registry[name] = breaker
+	}
+
+	return breaker
+}
+
+// GetBreakerStatuses returns the status of every registered breaker, sorted
+// by name
+func GetBreakerStatuses() []BreakerStatus {
+	registryMutex.Lock()
+	breakers := make([]*Breaker, 0, len(registry))
+	for _, breaker := range registry {
+		breakers = append(breakers, breaker)
+	}
+	registryMutex.Unlock()
+
+	statuses := make([]BreakerStatus, 0, len(breakers))
+	for _, breaker := range breakers {
+		statuses = append(statuses, breaker.Status())
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// Allow reports whether a call may be made right now
+func (b *Breaker) Allow() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case StateOpen:
+		if time.Since(b.openedAt) < b.Cooldown {
+			return &CircuitOpenError{b.Name}
+		}
+		b.state = StateHalfOpen
+		b.trialInFlight = true
+		return nil
+	case StateHalfOpen:
+		if b.trialInFlight {
+			return &CircuitOpenError{b.Name}
+		}
+		b.trialInFlight = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+func (b *Breaker) Success() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.state = StateClosed
+	b.failures = 0
+	b.trialInFlight = false
+}
+
+func (b *Breaker) Failure(reason string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.failures++
+	b.lastError = reason
+	b.lastFailure = time.Now()
+	b.trialInFlight = false
+
+	if b.state == StateHalfOpen || b.failures >= b.FailureThreshold {
+		b.state = StateOpen
+		b.openedAt = time.Now()
+	}
+}
+
+func (b *Breaker) Status() BreakerStatus {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	status := BreakerStatus{
+		Name:                b.Name,
+		State:               b.state,
+		ConsecutiveFailures: b.failures,
+		LastError:           b.lastError,
+	}
+
+	if b.state != StateClosed {
+		status.OpenedAt = b.openedAt.UTC().Format(time.RFC3339)
+	}
+	if !b.lastFailure.IsZero() {
+		status.LastFailure = b.lastFailure.UTC().Format(time.RFC3339)
+	}
+
+	return status
+}
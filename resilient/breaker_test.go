@@ -0,0 +1,76 @@
+package resilient
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestBreaker(cooldown time.Duration) *Breaker {
+	return &Breaker{
+		Name:             "test",
+		FailureThreshold: 3,
+		Cooldown:         cooldown,
+		state:            StateClosed,
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	tests := []struct {
+		name     string
+		cooldown time.Duration
+		calls    []string // "allow", "deny", "success" or "failure"
+		want     string
+	}{
+		{"closed until the threshold", time.Hour, []string{"failure", "failure", "allow"}, StateClosed},
+		{"opens at the threshold", time.Hour, []string{"failure", "failure", "failure", "deny"}, StateOpen},
+		{"success resets the count", time.Hour, []string{"failure", "failure", "success", "failure", "failure", "allow"}, StateClosed},
+		{"one trial after the cooldown", 0, []string{"failure", "failure", "failure", "allow", "deny"}, StateHalfOpen},
+		{"trial success closes", 0, []string{"failure", "failure", "failure", "allow", "success", "allow", "allow"}, StateClosed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			breaker := newTestBreaker(test.cooldown)
+			for i, call := range test.calls {
+				switch call {
+				case "allow":
+					if err := breaker.Allow(); err != nil {
+						t.Fatalf("call %d: Allow() = %v, want nil", i, err)
+					}
+				case "deny":
+					if _, ok := breaker.Allow().(*CircuitOpenError); !ok {
+						t.Fatalf("call %d: Allow() let the call through, want a CircuitOpenError", i)
+					}
+				case "success":
+					breaker.Success()
+				case "failure":
+					breaker.Failure("503 Service Unavailable")
+				}
+			}
+
+			if state := breaker.Status().State; state != test.want {
+				t.Errorf("state = %s, want %s", state, test.want)
+			}
+		})
+	}
+}
+
+func TestBreakerTrialFailureReopens(t *testing.T) {
+	breaker := newTestBreaker(0)
+	for i := 0; i < breaker.FailureThreshold; i++ {
+		breaker.Failure("timeout")
+	}
+
+	if err := breaker.Allow(); err != nil {
+		t.Fatalf("Allow() after the cooldown = %v, want nil", err)
+	}
+	breaker.Failure("timeout")
+
+	status := breaker.Status()
+	if status.State != StateOpen {
+		t.Errorf("state after a failed trial = %s, want %s", status.State, StateOpen)
+	}
+	if status.ConsecutiveFailures != breaker.FailureThreshold+1 || status.LastError != "timeout" {
+		t.Errorf("Status() = %+v, want %d failures and the last error", status, breaker.FailureThreshold+1)
+	}
+}
@@ -0,0 +1,166 @@
+// Package resilient wraps outbound HTTP calls with timeouts, retries with
+// exponential backoff and a circuit breaker per remote service.
+package resilient
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	IdempotencyKeyHeader = "Idempotency-Key"
+
+	DefaultTimeout    = 60 * time.Second
+	DefaultMaxRetries = 3
+	DefaultBaseDelay  = 250 * time.Millisecond
+	DefaultMaxDelay   = 8 * time.Second
+)
+
+// Transport is an http.RoundTripper that retries failed requests and trips a
+// circuit breaker when the remote service keeps failing.
+//
+// A request is only retried if sending it twice is harmless: GET, HEAD,
+// OPTIONS, PUT and DELETE requests, and POST requests that carry an
+// Idempotency-Key header. Network errors, 429s and 5xx responses are retried;
+// every other response is returned as is.
+type Transport struct {
+	Base       http.RoundTripper
+	Breaker    *Breaker
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+}
+
+// NewClient returns an http.Client for calling the named service. The whole
+// call, including retries, must finish within timeout.
+func NewClient(name string, timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &Transport{
+			Base:       http.DefaultTransport,
+			Breaker:    GetBreaker(name),
+			MaxRetries: DefaultMaxRetries,
+			BaseDelay:  DefaultBaseDelay,
+			MaxDelay:   DefaultMaxDelay,
+		},
+	}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	retries := t.MaxRetries
+	if !isRetryable(req) {
+		retries = 0
+	}
+
+	// The caller's request is never modified: each retry sends a copy of it
+	// with a fresh body
+	attemptReq := req
+	var resp *http.Response
+	var err error
+	for attempt := 0; ; attempt++ {
+		if t.Breaker != nil {
+			if err := t.Breaker.Allow(); err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err = base.RoundTrip(attemptReq)
+		t.record(resp, err)
+
+		if !shouldRetry(resp, err) || attempt >= retries {
+			return resp, err
+		}
+
+		delay := t.backoff(attempt, resp)
+		if resp != nil {
+			// Drain the body so the connection can be reused
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		attemptReq = req.Clone(req.Context())
+		if req.Body != nil {
+			if req.GetBody == nil {
+				return nil, errors.New("Can't retry request to " + req.URL.String() + ": body can't be rewound")
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attemptReq.Body = body
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+func (t *Transport) record(resp *http.Response, err error) {
+	if t.Breaker == nil {
+		return
+	}
+
+	switch {
+	case err != nil:
+		t.Breaker.Failure(err.Error())
+	case resp.StatusCode >= 500:
+		t.Breaker.Failure(resp.Status)
+	default:
+		// 429s mean we're sending too much, not that the service is down, so
+		// they don't count towards opening the breaker
+		t.Breaker.Success()
+	}
+}
+
+// Exponential backoff with full jitter, unless the server told us how long
+// to wait with a Retry-After header
+func (t *Transport) backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+			delay := time.Duration(seconds) * time.Second
+			if delay <= t.MaxDelay {
+				return delay
+			}
+			return t.MaxDelay
+		}
+	}
+
+	delay := t.BaseDelay << uint(attempt)
+	if delay > t.MaxDelay || delay <= 0 {
+		delay = t.MaxDelay
+	}
+
+	return time.Duration(rand.Int63n(int64(delay)) + 1)
+}
+
+func isRetryable(req *http.Request) bool {
+	switch req.Method {
+	case "GET", "HEAD", "OPTIONS", "PUT", "DELETE":
+		return true
+	case "POST":
+		return req.Header.Get(IdempotencyKeyHeader) != ""
+	default:
+		return false
+	}
+}
+
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
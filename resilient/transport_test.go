@@ -0,0 +1,139 @@
+package resilient
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTransportRetries(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		idempotencyKey string
+		statuses       []int // Returned in turn, repeating the last one
+		wantStatus     int
+		wantAttempts   int
+	}{
+		{"GET retried after a 503", "GET", "", []int{503, 200}, 200, 2},
+		{"GET retried after a 429", "GET", "", []int{429, 200}, 200, 2},
+		{"GET gives up after MaxRetries", "GET", "", []int{500}, 500, 3},
+		{"GET not retried after a 404", "GET", "", []int{404, 200}, 404, 1},
+		{"DELETE retried", "DELETE", "", []int{502, 200}, 200, 2},
+		{"POST not retried without a key", "POST", "", []int{503, 200}, 503, 1},
+		{"POST retried with a key", "POST", "eiwo-19da-p2gv", []int{503, 200}, 200, 2},
+		{"PATCH not retried", "PATCH", "", []int{503, 200}, 503, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			attempts := 0
+			bodies := []string{}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				bodies = append(bodies, string(body))
+
+				status := test.statuses[len(test.statuses)-1]
+				if attempts < len(test.statuses) {
+					status = test.statuses[attempts]
+				}
+				attempts++
+				w.WriteHeader(status)
+			}))
+			defer server.Close()
+
+			transport := &Transport{
+				MaxRetries: 2,
+				BaseDelay:  time.Millisecond,
+				MaxDelay:   time.Millisecond,
+			}
+
+			req, err := http.NewRequest(test.method, server.URL, bytes.NewReader([]byte("to=adr_123")))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.idempotencyKey != "" {
+				req.Header.Set(IdempotencyKeyHeader, test.idempotencyKey)
+			}
+			originalBody := req.Body
+
+			resp, err := transport.RoundTrip(req)
+			if err != nil {
+				t.Fatalf("RoundTrip() = %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != test.wantStatus || attempts != test.wantAttempts {
+				t.Errorf("got %d after %d attempts, want %d after %d", resp.StatusCode, attempts, test.wantStatus, test.wantAttempts)
+			}
+			for i, body := range bodies {
+				if body != "to=adr_123" {
+					t.Errorf("attempt %d sent body %q, want the whole body", i+1, body)
+				}
+			}
+			if req.Body != originalBody {
+				t.Error("RoundTrip() replaced the caller's request body")
+			}
+		})
+	}
+}
+
+func TestTransportRetryAfter(t *testing.T) {
+	transport := &Transport{BaseDelay: time.Second, MaxDelay: 8 * time.Second}
+
+	tests := []struct {
+		retryAfter string
+		want       time.Duration
+	}{
+		{"2", 2 * time.Second},
+		{"0", 0},
+		{"60", 8 * time.Second},
+	}
+
+	for _, test := range tests {
+		resp := &http.Response{Header: http.Header{"Retry-After": {test.retryAfter}}}
+		if delay := transport.backoff(0, resp); delay != test.want {
+			t.Errorf("backoff with Retry-After %s = %v, want %v", test.retryAfter, delay, test.want)
+		}
+	}
+
+	for attempt := 0; attempt < 10; attempt++ {
+		delay := transport.backoff(attempt, nil)
+		if delay <= 0 || delay > transport.MaxDelay {
+			t.Errorf("backoff(%d) = %v, want between 0 and %v", attempt, delay, transport.MaxDelay)
+		}
+	}
+}
+
+func TestTransportOpenBreaker(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	breaker := newTestBreaker(time.Hour)
+	transport := &Transport{
+		Breaker:    breaker,
+		MaxRetries: 5,
+		BaseDelay:  time.Millisecond,
+		MaxDelay:   time.Millisecond,
+	}
+
+	req, err := http.NewRequest("GET", server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = transport.RoundTrip(req)
+	if _, ok := err.(*CircuitOpenError); !ok {
+		t.Errorf("RoundTrip() = %v, want a CircuitOpenError", err)
+	}
+	if attempts != breaker.FailureThreshold {
+		t.Errorf("sent %d requests, want %d before the breaker opened", attempts, breaker.FailureThreshold)
+	}
+}
@@ -0,0 +1,158 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/johnamadeo/intouchgo/auth"
+	"github.com/johnamadeo/intouchgo/models"
+	"github.com/johnamadeo/intouchgo/utils"
+)
+
+// getAuthorizedUsername reads the username query parameter and checks that it
+// belongs to the user whose access token was sent. Routes that hand out or
+// destroy personal data must not trust the query parameter alone.
+func getAuthorizedUsername(w http.ResponseWriter, r *http.Request) (string, bool) {
+	usernames, ok := r.URL.Query()["username"]
+	if !ok || len(usernames) > 1 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(messageToBytes(r, "api.singleUsername"))
+		return "", false
+	}
+
+	subject, ok := auth.GetTokenSubject(r)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(utils.MessageToBytes(auth.InvalidAccessToken))
+		return "", false
+	}
+
+	// An unknown username is refused like someone else's, so that this can't
+	// be used to find out which usernames exist
+	user, err := models.GetAuth0User(usernames[0])
+	if err == models.ErrUserNotFound {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write(messageToBytes(r, "api.ownAccountOnly"))
+		return "", false
+	} else if err != nil {
+		utils.PrintErr(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errorToBytes(r, err))
+		return "", false
+	}
+
+	if user.UserId != subject {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write(messageToBytes(r, "api.ownAccountOnly"))
+		return "", false
+	}
+
+	return usernames[0], true
+}
+
+/*
+curl -X GET -H "Authorization: Bearer <token>" -o export.zip http://localhost:8080/user/export?username=jadk157
+*/
+func ExportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write(messageToBytes(r, "api.onlyGet"))
+		return
+	}
+
+	username, ok := getAuthorizedUsername(w, r)
+	if !ok {
+		return
+	}
+
+	// Build the archive in memory first so that a failure halfway through
+	// can still be reported with a proper status code
+	var buffer bytes.Buffer
+	err := models.ExportUserData(username, &buffer)
+	if err != nil {
+		utils.PrintErr(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errorToBytes(r, err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="intouch-`+username+`.zip"`)
+	w.Header().Set("Content-Length", strconv.Itoa(buffer.Len()))
+	w.WriteHeader(http.StatusOK)
+	w.Write(buffer.Bytes())
+}
+
+/*
+curl -X POST -H "Authorization: Bearer <token>" http://localhost:8080/user/deletion?username=jadk157
+curl -X DELETE -H "Authorization: Bearer <token>" http://localhost:8080/user/deletion?username=jadk157
+*/
+func AccountDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "" && r.Method != "POST" && r.Method != "DELETE" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write(messageToBytes(r, "api.onlyGetPostDelete"))
+		return
+	}
+
+	username, ok := getAuthorizedUsername(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case "POST":
+		deletion, err := models.ScheduleAccountDeletion(username)
+		if err != nil {
+			utils.PrintErr(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write(errorToBytes(r, err))
+			return
+		}
+
+		writeAccountDeletion(w, http.StatusAccepted, deletion)
+	case "DELETE":
+		err := models.CancelAccountDeletion(username)
+		if err == models.ErrNoPendingDeletion {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write(errorToBytes(r, err))
+			return
+		} else if err != nil {
+			utils.PrintErr(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write(errorToBytes(r, err))
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write(messageToBytes(r, "api.deletionCancelled"))
+	default:
+		deletion, err := models.GetPendingAccountDeletion(username)
+		if err == models.ErrNoPendingDeletion {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write(errorToBytes(r, err))
+			return
+		} else if err != nil {
+			utils.PrintErr(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write(errorToBytes(r, err))
+			return
+		}
+
+		writeAccountDeletion(w, http.StatusOK, deletion)
+	}
+}
+
+func writeAccountDeletion(w http.ResponseWriter, status int, deletion models.AccountDeletion) {
+	bytes, err := json.Marshal(deletion)
+	if err != nil {
+		utils.PrintErr(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(utils.MessageToBytes(err.Error()))
+		return
+	}
+
+	w.WriteHeader(status)
+	w.Write(bytes)
+}
@@ -0,0 +1,117 @@
+package routes
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/johnamadeo/intouchgo/models"
+	"github.com/johnamadeo/intouchgo/utils"
+)
+
+/*
+curl -X GET -H "Authorization: Bearer <token>" http://localhost:8080/user/addresses?username=jadk157
+curl -X POST -H "Content-Type: application/json" -H "Authorization: Bearer <token>" -d '{"name": "Jane Doe", "addressLine1": "185 Berry St", "addressLine2": "Suite 6100", "city": "San Francisco", "state": "CA", "zip": "94107"}' http://localhost:8080/user/addresses?username=jadk157
+curl -X DELETE -H "Authorization: Bearer <token>" "http://localhost:8080/user/addresses?username=jadk157&id=<id>"
+*/
+func ReturnAddressesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "" && r.Method != "POST" && r.Method != "DELETE" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write(messageToBytes(r, "api.onlyGetPostDelete"))
+		return
+	}
+
+	username, ok := getAuthorizedUsername(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case "POST":
+		createReturnAddress(w, r, username)
+	case "DELETE":
+		ids, ok := r.URL.Query()["id"]
+		if !ok || len(ids) > 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(messageToBytes(r, "api.singleId"))
+			return
+		}
+
+		err := models.DeleteReturnAddress(username, ids[0])
+		if err == models.ErrReturnAddressNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write(errorToBytes(r, err))
+			return
+		} else if err != nil {
+			utils.PrintErr(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write(errorToBytes(r, err))
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write(messageToBytes(r, "api.returnAddressDeleted"))
+	default:
+		addresses, err := models.GetReturnAddresses(username)
+		if err != nil {
+			utils.PrintErr(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write(errorToBytes(r, err))
+			return
+		}
+
+		writeReturnAddresses(w, http.StatusOK, addresses)
+	}
+}
+
+func createReturnAddress(w http.ResponseWriter, r *http.Request, username string) {
+	bytes, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(messageToBytes(r, "api.malformedBody"))
+		return
+	}
+	defer r.Body.Close()
+
+	var address models.ReturnAddress
+	err = json.Unmarshal(bytes, &address)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(messageToBytes(r, "api.bodyMustBeAddress"))
+		return
+	}
+
+	fieldErrors := models.ValidateReturnAddress(address)
+	if len(fieldErrors) > 0 {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write(utils.ValidationErrorsToBytes(localize(r, "api.invalidAddress"), localizeFieldErrors(r, fieldErrors)))
+		return
+	}
+
+	address, err = models.CreateReturnAddress(username, address)
+	if err == models.ErrUndeliverableAddress || err == models.ErrTooManyReturnAddresses {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write(errorToBytes(r, err))
+		return
+	} else if err != nil {
+		utils.PrintErr(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(messageToBytes(r, "api.saveAddressFailed"))
+		return
+	}
+
+	writeReturnAddresses(w, http.StatusCreated, address)
+}
+
+func writeReturnAddresses(w http.ResponseWriter, status int, addresses interface{}) {
+	bytes, err := json.Marshal(addresses)
+	if err != nil {
+		utils.PrintErr(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(utils.MessageToBytes(err.Error()))
+		return
+	}
+
+	w.WriteHeader(status)
+	w.Write(bytes)
+}
@@ -0,0 +1,152 @@
+package routes
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/johnamadeo/intouchgo/models"
+	"github.com/johnamadeo/intouchgo/resilient"
+	"github.com/johnamadeo/intouchgo/utils"
+)
+
+type AdminStatus struct {
+	Breakers []resilient.BreakerStatus `json:"breakers"`
+}
+
+/*
+curl -X GET -H "Authorization: Bearer $INTOUCH_ADMIN_API_KEY" http://localhost:8080/admin/status
+*/
+func AdminStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write(utils.MessageToBytes("Only GET requests are allowed at this route"))
+		return
+	}
+
+	bytes, err := json.Marshal(AdminStatus{
+		Breakers: resilient.GetBreakerStatuses(),
+	})
+	if err != nil {
+		utils.PrintErr(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(utils.MessageToBytes(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(bytes)
+}
+
+type SandboxUserBody struct {
+	Username string `json:"username"`
+	Note     string `json:"note"`
+}
+
+/*
+curl -X POST -H "Authorization: Bearer $INTOUCH_ADMIN_API_KEY" -d '{"username": "qa-tester", "note": "QA"}' http://localhost:8080/admin/sandbox-users
+curl -X DELETE -H "Authorization: Bearer $INTOUCH_ADMIN_API_KEY" http://localhost:8080/admin/sandbox-users?username=qa-tester
+*/
+func SandboxUsersHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET", "":
+		users, err := models.GetSandboxUsers()
+		if err != nil {
+			utils.PrintErr(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write(utils.MessageToBytes(err.Error()))
+			return
+		}
+
+		bytes, err := json.Marshal(users)
+		if err != nil {
+			utils.PrintErr(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write(utils.MessageToBytes(err.Error()))
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write(bytes)
+	case "POST":
+		bytes, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(utils.MessageToBytes("Malformed body."))
+			return
+		}
+		defer r.Body.Close()
+
+		var body SandboxUserBody
+		err = json.Unmarshal(bytes, &body)
+		if err != nil || body.Username == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(utils.MessageToBytes("Request body must contain a username"))
+			return
+		}
+
+		err = models.AddSandboxUser(body.Username, body.Note)
+		if err != nil {
+			utils.PrintErr(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write(utils.MessageToBytes(err.Error()))
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		w.Write(utils.MessageToBytes("Letters from " + body.Username + " will be sent in the Lob test environment."))
+	case "DELETE":
+		usernames, ok := r.URL.Query()["username"]
+		if !ok || len(usernames) > 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(utils.MessageToBytes("Request query parameters must contain a single username"))
+			return
+		}
+
+		err := models.RemoveSandboxUser(usernames[0])
+		if err != nil {
+			utils.PrintErr(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write(utils.MessageToBytes(err.Error()))
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write(utils.MessageToBytes("Removed sandbox override for " + usernames[0] + "."))
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write(utils.MessageToBytes("Only GET, POST and DELETE requests are allowed at this route"))
+	}
+}
+
+/*
+curl -X POST -H "Authorization: Bearer $INTOUCH_ADMIN_API_KEY" http://localhost:8080/admin/facilities/provision?dryRun=true
+*/
+func ProvisionFacilitiesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write(utils.MessageToBytes("Only POST requests are allowed at this route"))
+		return
+	}
+
+	dryRun := r.URL.Query().Get("dryRun") == "true"
+
+	results, err := models.ProvisionFacilityAddresses(dryRun)
+	if err != nil {
+		utils.PrintErr(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(utils.MessageToBytes(err.Error()))
+		return
+	}
+
+	bytes, err := json.Marshal(results)
+	if err != nil {
+		utils.PrintErr(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(utils.MessageToBytes(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(bytes)
+}
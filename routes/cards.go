@@ -0,0 +1,70 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/johnamadeo/intouchgo/i18n"
+	"github.com/johnamadeo/intouchgo/models"
+	"github.com/johnamadeo/intouchgo/render"
+	"github.com/johnamadeo/intouchgo/utils"
+)
+
+/*
+curl -X GET -H "Authorization: Bearer <token>" http://localhost:8080/cards
+curl -X GET -H "Authorization: Bearer <token>" "http://localhost:8080/cards?occasion=birthday&recipientId=asdf-123s-ddss"
+*/
+func CardsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write(messageToBytes(r, "api.onlyGet"))
+		return
+	}
+
+	query := r.URL.Query()
+	occasion := query.Get("occasion")
+	if occasion != "" && !render.IsOccasion(occasion) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(errorToBytes(r, render.ErrUnknownOccasion))
+		return
+	}
+
+	// With a recipient, only the cards their facility accepts are listed
+	cards := render.GetCards(occasion)
+	if recipientId := query.Get("recipientId"); recipientId != "" {
+		var err error
+		cards, err = models.GetCardsForRecipient(recipientId, occasion)
+		if err == models.ErrInmateNotFound {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Write(errorToBytes(r, err))
+			return
+		} else if err != nil {
+			utils.PrintErr(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write(messageToBytes(r, "api.getCardsError", localizeError(r, err)))
+			return
+		}
+	}
+
+	// Cards without a translation keep the name from the registry
+	locale := getLocale(r)
+	for i, card := range cards {
+		if name, ok := i18n.Lookup(locale, "card."+card.Id+".name"); ok {
+			cards[i].Name = name
+		}
+		if description, ok := i18n.Lookup(locale, "card."+card.Id+".description"); ok {
+			cards[i].Description = description
+		}
+	}
+
+	bytes, err := json.Marshal(cards)
+	if err != nil {
+		utils.PrintErr(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errorToBytes(r, err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(bytes)
+}
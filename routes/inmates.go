@@ -14,14 +14,14 @@ curl -X GET -H "Content-Type: application/json" -H "Authorization: Bearer eyJ0eX
 func InmatesHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "GET" && r.Method != "" {
 		w.WriteHeader(http.StatusMethodNotAllowed)
-		w.Write(utils.MessageToBytes("Only GET requests are allowed at this route"))
+		w.Write(messageToBytes(r, "api.onlyGet"))
 		return
 	}
 
 	queries, ok := r.URL.Query()["query"]
 	if !ok || len(queries) > 1 {
 		w.WriteHeader(http.StatusBadRequest)
-		w.Write(utils.MessageToBytes("Request query parameters must contain a single username"))
+		w.Write(messageToBytes(r, "api.singleUsername"))
 		return
 	}
 
@@ -29,7 +29,7 @@ func InmatesHandler(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		utils.PrintErr(err)
 		w.WriteHeader(http.StatusInternalServerError)
-		w.Write(utils.MessageToBytes(err.Error()))
+		w.Write(errorToBytes(r, err))
 		return
 	}
 
@@ -37,7 +37,7 @@ func InmatesHandler(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		utils.PrintErr(err)
 		w.WriteHeader(http.StatusInternalServerError)
-		w.Write(utils.MessageToBytes(err.Error()))
+		w.Write(errorToBytes(r, err))
 		return
 	}
 
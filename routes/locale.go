@@ -0,0 +1,138 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/johnamadeo/intouchgo/i18n"
+	"github.com/johnamadeo/intouchgo/models"
+	"github.com/johnamadeo/intouchgo/photos"
+	"github.com/johnamadeo/intouchgo/render"
+	"github.com/johnamadeo/intouchgo/utils"
+)
+
+// errorKeys are the catalog keys of the errors users are expected to see.
+// Any other error is shown as is.
+var errorKeys = map[error]string{
+	models.ErrNoPendingDeletion:      "error.noPendingDeletion",
+	models.ErrMixedEnvironments:      "error.mixedEnvironments",
+	models.ErrInvalidRecipientFormat: "error.invalidRecipientFormat",
+	models.ErrRecipientNameTooLong:   "error.recipientNameTooLong",
+	models.ErrInmateNotFound:         "error.inmateNotFound",
+	models.ErrNoLobAddress:           "error.noLobAddress",
+	models.ErrLetterExists:           "error.letterExists",
+	models.ErrLetterNotFound:         "error.letterNotFound",
+	models.ErrLetterBeingSent:        "error.letterBeingSent",
+	models.ErrLetterNotCancellable:   "error.letterNotCancellable",
+	models.ErrPreviewNotFound:        "error.previewNotFound",
+	models.ErrReturnAddressNotFound:  "error.returnAddressNotFound",
+	models.ErrUndeliverableAddress:   "error.undeliverableAddress",
+	models.ErrTooManyReturnAddresses: "error.tooManyReturnAddresses",
+	models.ErrUserExists:             "error.userExists",
+	models.ErrUserNotFound:           "error.userNotFound",
+	models.ErrPhotoNotFound:          "error.photoNotFound",
+	models.ErrPhotoLinkInvalid:       "error.photoLinkInvalid",
+	models.ErrPostcardExists:         "error.postcardExists",
+	models.ErrPostcardNotFound:       "error.postcardNotFound",
+	photos.ErrUnsupportedImage:       "error.unsupportedImage",
+	photos.ErrImageTooLarge:          "error.imageTooLarge",
+	photos.ErrInvalidSize:            "error.invalidPhotoSize",
+	render.ErrChromeUnavailable:      "error.chromeUnavailable",
+	render.ErrThemeNotFound:          "error.themeNotFound",
+	render.ErrCardNotFound:           "error.cardNotFound",
+	render.ErrUnknownOccasion:        "error.unknownOccasion",
+}
+
+type localeContextKey struct{}
+
+// requestLocale remembers the locale of a request once it has been looked
+// up, since every message in the response needs it
+type requestLocale struct {
+	username string
+	locale   string
+	resolved bool
+}
+
+// LocaleHandler lets the handlers behind it look up the locale of each
+// request only once
+func LocaleHandler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), localeContextKey{}, &requestLocale{})
+		handler.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// getLocale picks the language of the response: the one the user chose in
+// the app if the request names a user, otherwise the one their device asks
+// for in Accept-Language, otherwise English
+func getLocale(r *http.Request) string {
+	usernames, ok := r.URL.Query()["username"]
+	if !ok || len(usernames) != 1 {
+		return getUserLocale(r, "")
+	}
+	return getUserLocale(r, usernames[0])
+}
+
+func getUserLocale(r *http.Request, username string) string {
+	cached, ok := r.Context().Value(localeContextKey{}).(*requestLocale)
+	if ok && cached.resolved && cached.username == username {
+		return cached.locale
+	}
+
+	locale := findUserLocale(r, username)
+	if ok {
+		*cached = requestLocale{username: username, locale: locale, resolved: true}
+	}
+	return locale
+}
+
+func findUserLocale(r *http.Request, username string) string {
+	if username != "" {
+		preferences, err := models.GetPreferences(username)
+		if err != nil {
+			utils.PrintErr(err)
+		} else if preferences.Locale != "" {
+			return preferences.Locale
+		}
+	}
+
+	locale := i18n.MatchAcceptLanguage(r.Header.Get("Accept-Language"))
+	if locale == "" {
+		return i18n.DefaultLocale
+	}
+	return locale
+}
+
+func localize(r *http.Request, key string, args ...interface{}) string {
+	return i18n.T(getLocale(r), key, args...)
+}
+
+// localizeFieldErrors translates the messages of validation errors built
+// from catalog keys
+func localizeFieldErrors(r *http.Request, errs []utils.FieldError) []utils.FieldError {
+	locale := getLocale(r)
+	localized := make([]utils.FieldError, len(errs))
+	for i, fieldError := range errs {
+		localized[i] = fieldError
+		if fieldError.Key != "" {
+			localized[i].Message = i18n.T(locale, fieldError.Key, fieldError.Args...)
+		}
+	}
+	return localized
+}
+
+func localizeError(r *http.Request, err error) string {
+	key, ok := errorKeys[err]
+	if !ok {
+		return err.Error()
+	}
+	return localize(r, key)
+}
+
+func messageToBytes(r *http.Request, key string, args ...interface{}) []byte {
+	return utils.MessageToBytes(localize(r, key, args...))
+}
+
+func errorToBytes(r *http.Request, err error) []byte {
+	return utils.MessageToBytes(localizeError(r, err))
+}
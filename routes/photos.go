@@ -0,0 +1,142 @@
+package routes
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/johnamadeo/intouchgo/models"
+	"github.com/johnamadeo/intouchgo/photos"
+	"github.com/johnamadeo/intouchgo/utils"
+)
+
+/*
+curl -X GET -H "Authorization: Bearer <token>" http://localhost:8080/photos?username=jadk157
+curl -X POST -H "Content-Type: image/jpeg" -H "Authorization: Bearer <token>" --data-binary @arden.jpg "http://localhost:8080/photos?username=jadk157&size=4x6&grayscale=false"
+*/
+func PhotosHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "" && r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write(messageToBytes(r, "api.onlyGetPost"))
+		return
+	}
+
+	username, ok := getAuthorizedUsername(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Method == "POST" {
+		uploadPhoto(w, r, username)
+		return
+	}
+
+	found, err := models.GetPhotos(username)
+	if err != nil {
+		utils.PrintErr(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errorToBytes(r, err))
+		return
+	}
+
+	bytes, err := json.Marshal(found)
+	if err != nil {
+		utils.PrintErr(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errorToBytes(r, err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(bytes)
+}
+
+// uploadPhoto takes the image itself as the request body, with the print
+// size and whether to print it in grayscale as query parameters
+func uploadPhoto(w http.ResponseWriter, r *http.Request, username string) {
+	query := r.URL.Query()
+	size := query.Get("size")
+	grayscale, _ := strconv.ParseBool(query.Get("grayscale"))
+
+	// Read one byte past the limit so that larger uploads are refused
+	// instead of truncated
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, photos.MaxUploadSize+1))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(messageToBytes(r, "api.malformedBody"))
+		return
+	}
+	defer r.Body.Close()
+
+	photo, err := models.UploadPhoto(username, data, size, grayscale)
+	if err == photos.ErrImageTooLarge {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		w.Write(errorToBytes(r, err))
+		return
+	} else if err == photos.ErrUnsupportedImage || err == photos.ErrInvalidSize {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write(errorToBytes(r, err))
+		return
+	} else if err != nil {
+		utils.PrintErr(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(messageToBytes(r, "api.uploadPhotoError", localizeError(r, err)))
+		return
+	}
+
+	bytes, err := json.Marshal(photo)
+	if err != nil {
+		utils.PrintErr(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errorToBytes(r, err))
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	w.Write(bytes)
+}
+
+/*
+curl -X GET "http://localhost:8080/photos/0f6e1c5a-8d7b-4f5e-9a53-2b1c5f0e7d4a/print?expires=1700000000&signature=<signature>"
+*/
+func PhotoPrintHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write(messageToBytes(r, "api.onlyGet"))
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/photos/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] != "print" {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(errorToBytes(r, models.ErrPhotoNotFound))
+		return
+	}
+
+	query := r.URL.Query()
+	print, err := models.GetPhotoPrint(parts[0], query.Get("expires"), query.Get("signature"))
+	if err == models.ErrPhotoLinkInvalid {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write(errorToBytes(r, err))
+		return
+	} else if err == models.ErrPhotoNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(errorToBytes(r, err))
+		return
+	} else if err != nil {
+		utils.PrintErr(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(messageToBytes(r, "api.getPhotoError", localizeError(r, err)))
+		return
+	}
+
+	w.Header().Set("Content-Type", print.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(print.Data)))
+	// Only the renderer that was given the link should keep the print
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	w.WriteHeader(http.StatusOK)
+	w.Write(print.Data)
+}
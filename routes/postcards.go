@@ -0,0 +1,141 @@
+package routes
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/johnamadeo/intouchgo/models"
+	"github.com/johnamadeo/intouchgo/utils"
+)
+
+/*
+curl -X GET -H "Authorization: Bearer <token>" http://localhost:8080/postcards?username=jadk157
+curl -X POST -H "Content-Type: application/json" -H "Authorization: Bearer <token>" -d '{"id": "a8c2-41fe-p9qd", "recipientId": "asdf-123s-ddss", "message": "Arden made the soccer team! We miss you.", "size": "4x6", "frontPhotoId": "0f6e1c5a-8d7b-4f5e-9a53-2b1c5f0e7d4a"}' http://localhost:8080/postcards?username=jadk157
+*/
+func PostcardsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "" && r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write(messageToBytes(r, "api.onlyGetPost"))
+		return
+	}
+
+	username, ok := getAuthorizedUsername(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Method == "POST" {
+		sendPostcard(w, r, username)
+		return
+	}
+
+	postcards, err := models.GetPostcards(username)
+	if err != nil {
+		utils.PrintErr(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errorToBytes(r, err))
+		return
+	}
+
+	bytes, err := json.Marshal(postcards)
+	if err != nil {
+		utils.PrintErr(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errorToBytes(r, err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(bytes)
+}
+
+func sendPostcard(w http.ResponseWriter, r *http.Request, username string) {
+	bytes, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(messageToBytes(r, "api.malformedBody"))
+		return
+	}
+	defer r.Body.Close()
+
+	var postcard models.Postcard
+	err = json.Unmarshal(bytes, &postcard)
+	if err != nil || postcard.Id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(messageToBytes(r, "api.bodyMustBePostcard"))
+		return
+	}
+
+	postcard.Author = username
+	if postcard.Locale == "" {
+		postcard.Locale = getUserLocale(r, username)
+	}
+
+	postcard, err = models.SendPostcard(postcard)
+	if optionsErr, ok := err.(*models.MailOptionsError); ok {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write(utils.ValidationErrorsToBytes(localize(r, "api.invalidPostcard"), localizeFieldErrors(r, optionsErr.Errors)))
+		return
+	} else if err == models.ErrPostcardExists {
+		w.WriteHeader(http.StatusConflict)
+		w.Write(errorToBytes(r, err))
+		return
+	} else if err == models.ErrInmateNotFound || err == models.ErrNoLobAddress || err == models.ErrMixedEnvironments ||
+		err == models.ErrReturnAddressNotFound || err == models.ErrPhotoNotFound {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write(errorToBytes(r, err))
+		return
+	} else if err != nil {
+		utils.PrintErr(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(messageToBytes(r, "api.createPostcardError", localizeError(r, err)))
+		return
+	}
+
+	bytes, err = json.Marshal(postcard)
+	if err != nil {
+		utils.PrintErr(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errorToBytes(r, err))
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	w.Write(bytes)
+}
+
+/*
+curl -X GET -H "Authorization: Bearer <token>" http://localhost:8080/history?username=jadk157
+*/
+func HistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write(messageToBytes(r, "api.onlyGet"))
+		return
+	}
+
+	username, ok := getAuthorizedUsername(w, r)
+	if !ok {
+		return
+	}
+
+	history, err := models.GetHistory(username)
+	if err != nil {
+		utils.PrintErr(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errorToBytes(r, err))
+		return
+	}
+
+	bytes, err := json.Marshal(history)
+	if err != nil {
+		utils.PrintErr(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errorToBytes(r, err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(bytes)
+}
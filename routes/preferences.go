@@ -0,0 +1,79 @@
+package routes
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/johnamadeo/intouchgo/models"
+	"github.com/johnamadeo/intouchgo/utils"
+)
+
+/*
+curl -X GET -H "Authorization: Bearer <token>" http://localhost:8080/user/preferences?username=jadk157
+curl -X POST -H "Content-Type: application/json" -H "Authorization: Bearer <token>" -d '{"locale": "es"}' http://localhost:8080/user/preferences?username=jadk157
+*/
+func PreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "" && r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write(messageToBytes(r, "api.onlyGetPost"))
+		return
+	}
+
+	username, ok := getAuthorizedUsername(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Method == "POST" {
+		bytes, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(messageToBytes(r, "api.malformedBody"))
+			return
+		}
+		defer r.Body.Close()
+
+		var preferences models.Preferences
+		err = json.Unmarshal(bytes, &preferences)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(messageToBytes(r, "api.bodyMustBePreferences"))
+			return
+		}
+
+		fieldErrors := models.ValidatePreferences(preferences)
+		if len(fieldErrors) > 0 {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Write(utils.ValidationErrorsToBytes(localize(r, "api.invalidPreferences"), localizeFieldErrors(r, fieldErrors)))
+			return
+		}
+
+		err = models.SavePreferences(username, preferences)
+		if err != nil {
+			utils.PrintErr(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write(errorToBytes(r, err))
+			return
+		}
+	}
+
+	preferences, err := models.GetPreferences(username)
+	if err != nil {
+		utils.PrintErr(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errorToBytes(r, err))
+		return
+	}
+
+	bytes, err := json.Marshal(preferences)
+	if err != nil {
+		utils.PrintErr(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errorToBytes(r, err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(bytes)
+}
@@ -0,0 +1,76 @@
+package routes
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/johnamadeo/intouchgo/models"
+	"github.com/johnamadeo/intouchgo/utils"
+)
+
+/*
+curl -X GET -H "Authorization: Bearer <token>" http://localhost:8080/user/recipient-defaults?username=jadk157
+curl -X POST -H "Content-Type: application/json" -H "Authorization: Bearer <token>" -d '{"inmateId": "asdf-123s-ddss", "largePrint": true}' http://localhost:8080/user/recipient-defaults?username=jadk157
+*/
+func RecipientDefaultsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "" && r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write(messageToBytes(r, "api.onlyGetPost"))
+		return
+	}
+
+	username, ok := getAuthorizedUsername(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Method == "POST" {
+		bytes, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(messageToBytes(r, "api.malformedBody"))
+			return
+		}
+		defer r.Body.Close()
+
+		var defaults models.RecipientDefaults
+		err = json.Unmarshal(bytes, &defaults)
+		if err != nil || defaults.InmateId == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(messageToBytes(r, "api.bodyMustBeRecipientDefaults"))
+			return
+		}
+
+		err = models.SaveRecipientDefaults(username, defaults)
+		if err == models.ErrInmateNotFound {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Write(errorToBytes(r, err))
+			return
+		} else if err != nil {
+			utils.PrintErr(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write(errorToBytes(r, err))
+			return
+		}
+	}
+
+	defaults, err := models.GetRecipientDefaults(username)
+	if err != nil {
+		utils.PrintErr(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errorToBytes(r, err))
+		return
+	}
+
+	bytes, err := json.Marshal(defaults)
+	if err != nil {
+		utils.PrintErr(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errorToBytes(r, err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(bytes)
+}
@@ -0,0 +1,44 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/johnamadeo/intouchgo/i18n"
+	"github.com/johnamadeo/intouchgo/render"
+	"github.com/johnamadeo/intouchgo/utils"
+)
+
+/*
+curl -X GET -H "Authorization: Bearer <token>" http://localhost:8080/themes
+*/
+func ThemesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write(messageToBytes(r, "api.onlyGet"))
+		return
+	}
+
+	// Themes without a translation keep the name from the registry
+	locale := getLocale(r)
+	themes := render.GetThemes()
+	for i, theme := range themes {
+		if name, ok := i18n.Lookup(locale, "theme."+theme.Id+".name"); ok {
+			themes[i].Name = name
+		}
+		if description, ok := i18n.Lookup(locale, "theme."+theme.Id+".description"); ok {
+			themes[i].Description = description
+		}
+	}
+
+	bytes, err := json.Marshal(themes)
+	if err != nil {
+		utils.PrintErr(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errorToBytes(r, err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(bytes)
+}
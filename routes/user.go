@@ -9,10 +9,14 @@ import (
 	"github.com/johnamadeo/intouchgo/utils"
 )
 
+type PasswordResetBody struct {
+	Email string `json:"email"`
+}
+
 func CreateUserHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		w.WriteHeader(http.StatusMethodNotAllowed)
-		w.Write(utils.MessageToBytes("Only POST requests are allowed at this route"))
+		w.Write(messageToBytes(r, "api.onlyPost"))
 		return
 	}
 
@@ -20,7 +24,7 @@ func CreateUserHandler(w http.ResponseWriter, r *http.Request) {
 	bytes, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
-		w.Write(utils.MessageToBytes("Malformed body."))
+		w.Write(messageToBytes(r, "api.malformedBody"))
 		return
 	}
 	defer r.Body.Close()
@@ -28,18 +32,104 @@ func CreateUserHandler(w http.ResponseWriter, r *http.Request) {
 	err = json.Unmarshal(bytes, &user)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
-		w.Write(utils.MessageToBytes("Request body must be a user."))
+		w.Write(messageToBytes(r, "api.bodyMustBeUser"))
+		return
+	}
+
+	fieldErrors := models.ValidateUser(user)
+	if len(fieldErrors) > 0 {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write(utils.ValidationErrorsToBytes(localize(r, "api.invalidUser"), localizeFieldErrors(r, fieldErrors)))
 		return
 	}
 
 	err = models.CreateUser(user)
-	if err != nil {
+	if err == models.ErrUserExists {
+		w.WriteHeader(http.StatusConflict)
+		w.Write(errorToBytes(r, err))
+		return
+	} else if err != nil {
 		utils.PrintErr(err)
 		w.WriteHeader(http.StatusInternalServerError)
-		w.Write(utils.MessageToBytes("Failed to create user: " + err.Error()))
+		w.Write(messageToBytes(r, "api.createUserFailed"))
 		return
 	}
 
 	w.WriteHeader(http.StatusCreated)
-	w.Write(utils.MessageToBytes("Successfully created user."))
+	w.Write(messageToBytes(r, "api.userCreated"))
+}
+
+/*
+curl -X POST -H "Authorization: Bearer <token>" http://localhost:8080/user/verification-email?username=jadk157
+*/
+func VerificationEmailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write(messageToBytes(r, "api.onlyPost"))
+		return
+	}
+
+	// Only the account holder can have their verification email resent
+	username, ok := getAuthorizedUsername(w, r)
+	if !ok {
+		return
+	}
+
+	err := models.SendVerificationEmail(username)
+	if err != nil {
+		utils.PrintErr(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(messageToBytes(r, "api.verificationEmailFailed"))
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(messageToBytes(r, "api.verificationEmailSent"))
+}
+
+/*
+curl -X POST -H "Content-Type: application/json" -d '{"email": "jane@example.com"}' http://localhost:8080/user/password-reset
+*/
+func PasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write(messageToBytes(r, "api.onlyPost"))
+		return
+	}
+
+	bytes, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(messageToBytes(r, "api.malformedBody"))
+		return
+	}
+	defer r.Body.Close()
+
+	var body PasswordResetBody
+	err = json.Unmarshal(bytes, &body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(messageToBytes(r, "api.bodyMustHaveEmail"))
+		return
+	}
+
+	fieldErrors := models.ValidateEmail(body.Email)
+	if len(fieldErrors) > 0 {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write(utils.ValidationErrorsToBytes(localize(r, "api.invalidEmail"), localizeFieldErrors(r, fieldErrors)))
+		return
+	}
+
+	err = models.SendPasswordReset(body.Email)
+	if err != nil {
+		utils.PrintErr(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(messageToBytes(r, "api.passwordResetFailed"))
+		return
+	}
+
+	// Always respond the same way so this route can't be used to find out
+	// which emails have accounts
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(messageToBytes(r, "api.passwordResetSent"))
 }
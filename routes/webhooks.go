@@ -0,0 +1,78 @@
+package routes
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/johnamadeo/intouchgo/lob"
+	"github.com/johnamadeo/intouchgo/models"
+	"github.com/johnamadeo/intouchgo/utils"
+)
+
+const (
+	MaxWebhookBodySize = 1 << 20
+)
+
+// LobWebhookHandler receives letter events from Lob. Test and live webhooks
+// are signed with different secrets, so a signature from either is accepted.
+func LobWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write(utils.MessageToBytes("Only POST requests are allowed at this route"))
+		return
+	}
+
+	bytes, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, MaxWebhookBodySize))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(utils.MessageToBytes("Malformed body."))
+		return
+	}
+	defer r.Body.Close()
+
+	timestamp := r.Header.Get(lob.LobSignatureTimestampHeader)
+	signature := r.Header.Get(lob.LobSignatureHeader)
+
+	err = lob.ErrInvalidSignature
+	for _, secretEnv := range []string{lob.LobTestWebhookSecret, lob.LobLiveWebhookSecret} {
+		secret, ok := os.LookupEnv(secretEnv)
+		if !ok {
+			continue
+		}
+
+		err = lob.VerifyWebhookSignature(secret, timestamp, signature, bytes, time.Now())
+		if err == nil {
+			break
+		}
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(utils.MessageToBytes(err.Error()))
+		return
+	}
+
+	var event lob.LobEvent
+	err = json.Unmarshal(bytes, &event)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(utils.MessageToBytes("Request body must be a Lob event"))
+		return
+	}
+
+	// A non-2xx response makes Lob redeliver the webhook later, which is what
+	// we want if our database is down
+	err = models.RecordLobEvent(event)
+	if err != nil {
+		utils.PrintErr(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(utils.MessageToBytes(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(utils.MessageToBytes("Event recorded."))
+}
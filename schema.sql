@@ -1,4 +1,16 @@
+DROP TABLE reconciliation_runs;
+DROP TABLE recipient_defaults;
+DROP TABLE user_plans;
+DROP TABLE user_preferences;
+DROP TABLE account_deletions;
+DROP TABLE sandbox_users;
+DROP TABLE letter_events;
+DROP TABLE letter_previews;
 DROP TABLE letters;
+DROP TABLE postcards;
+DROP TABLE photos;
+DROP TABLE return_addresses;
+DROP TABLE inmate_addresses;
 DROP TABLE inmates;
 DROP TABLE facilities;
 
@@ -10,8 +22,37 @@ CREATE TABLE facilities (
     city VARCHAR NOT NULL CHECK(length(city) > 0),
     state VARCHAR NOT NULL CHECK(length(state) > 0),
     zip VARCHAR NOT NULL CHECK(length(zip) > 0),
-    lobTestAddressId VARCHAR NOT NULL CHECK(length(lobTestAddressId) > 0),
-    lobLiveAddressId VARCHAR NOT NULL -- CHECK(length(lobLiveAddressId) > 0)
+    -- Set by the --provision-facilities command, which creates the Lob
+    -- address objects from the address above once USPS has verified it
+    lobTestAddressId VARCHAR NOT NULL DEFAULT '',
+    lobLiveAddressId VARCHAR NOT NULL DEFAULT '',
+    verifiedAddressLine VARCHAR,
+    verifiedCity VARCHAR,
+    verifiedState VARCHAR,
+    verifiedZip VARCHAR,
+    deliverability VARCHAR,
+    addressNeedsReview BOOLEAN NOT NULL DEFAULT false,
+    timeAddressVerified TIMESTAMP,
+    -- How the recipient line of the envelope is written for this facility,
+    -- as a Go text/template over the inmate's FirstName, LastName,
+    -- FirstInitial and InmateNumber
+    recipientFormat VARCHAR NOT NULL DEFAULT '{{.FirstName}} {{.LastName}} #{{.InmateNumber}}',
+    -- What the facility's mailroom accepts
+    allowsColor BOOLEAN NOT NULL DEFAULT true,
+    allowsDoubleSided BOOLEAN NOT NULL DEFAULT true,
+    allowsImages BOOLEAN NOT NULL DEFAULT true,
+    allowsPostcards BOOLEAN NOT NULL DEFAULT true,
+    -- Many mailrooms turn away cards with glitter, stickers or colored paper,
+    -- even when they are only printed to look that way
+    allowsGlitter BOOLEAN NOT NULL DEFAULT true,
+    allowsStickers BOOLEAN NOT NULL DEFAULT true,
+    allowsColoredPaper BOOLEAN NOT NULL DEFAULT true,
+    -- NULL if the facility accepts letters as long as fit in the envelope
+    maxLetterPages INTEGER CHECK (maxLetterPages > 0),
+    allowedExtraServices VARCHAR[] NOT NULL DEFAULT '{certified,certified_return_receipt,registered}',
+    -- Photos printed on their own pages after the letter
+    maxPhotos INTEGER NOT NULL DEFAULT 5 CHECK (maxPhotos >= 0),
+    allowedPhotoSizes VARCHAR[] NOT NULL DEFAULT '{wallet,4x6,5x7,full}'
 );
 
 CREATE TABLE inmates (
@@ -26,6 +67,55 @@ CREATE TABLE inmates (
     PRIMARY KEY(state, inmateNumber)
 );
 
+-- Lob address objects created for individual inmates. addressHash identifies
+-- the name and facility address the Lob address was created with, so a new one
+-- is created when the inmate moves or the facility's format changes.
+CREATE TABLE inmate_addresses (
+    inmateId VARCHAR NOT NULL REFERENCES inmates(id),
+    lobEnvironment VARCHAR NOT NULL CHECK (lobEnvironment IN ('test', 'live')),
+    lobAddressId VARCHAR NOT NULL CHECK (length(lobAddressId) > 0),
+    addressHash VARCHAR NOT NULL,
+    timeCreated TIMESTAMP NOT NULL DEFAULT now(),
+    PRIMARY KEY(inmateId, lobEnvironment)
+);
+
+-- Addresses users have registered for inmates to write back to. Lob
+-- addresses are created per environment as they are needed. Deleted addresses
+-- are kept while letters still refer to them.
+CREATE TABLE return_addresses (
+    id VARCHAR PRIMARY KEY,
+    username VARCHAR NOT NULL CHECK (length(username) > 0),
+    name VARCHAR NOT NULL CHECK (length(name) > 0),
+    addressLine1 VARCHAR NOT NULL CHECK (length(addressLine1) > 0),
+    addressLine2 VARCHAR NOT NULL DEFAULT '',
+    city VARCHAR NOT NULL,
+    state VARCHAR NOT NULL,
+    zip VARCHAR NOT NULL,
+    deliverability VARCHAR NOT NULL,
+    lobTestAddressId VARCHAR NOT NULL DEFAULT '',
+    lobLiveAddressId VARCHAR NOT NULL DEFAULT '',
+    timeCreated TIMESTAMP NOT NULL DEFAULT now(),
+    timeDeleted TIMESTAMP
+);
+
+CREATE INDEX return_addresses_username ON return_addresses (username) WHERE timeDeleted IS NULL;
+
+-- Photos uploaded to be printed in letters. The original, without its EXIF
+-- data, and the print cropped to size are kept in the blob store.
+CREATE TABLE photos (
+    id VARCHAR PRIMARY KEY,
+    username VARCHAR NOT NULL CHECK (length(username) > 0),
+    size VARCHAR NOT NULL CHECK (size IN ('wallet', '4x6', '5x7', 'full')),
+    grayscale BOOLEAN NOT NULL DEFAULT false,
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    originalKey VARCHAR NOT NULL,
+    printKey VARCHAR NOT NULL,
+    timeCreated TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX photos_username ON photos (username);
+
 CREATE TABLE letters (
     id VARCHAR PRIMARY KEY,
     author VARCHAR NOT NULL CHECK (length(author) > 0),
@@ -34,12 +124,166 @@ CREATE TABLE letters (
     text VARCHAR NOT NULL CHECK (length(text) > 0),
     timeSent DATE NOT NULL,
     timeLastEdited DATE NOT NULL,
-    timeDeliveredEstimate DATE NOT NULL,
+    timeDeliveredEstimate DATE,
     isDraft BOOLEAN NOT NULL,
-    lobLetterId VARCHAR NOT NULL CHECK (length(lobLetterId) > 0)
+    -- Letters are recorded as queued before they are sent to Lob, so the Lob
+    -- letter ID is only known once the dispatcher has sent them
+    lobLetterId VARCHAR UNIQUE CHECK (length(lobLetterId) > 0),
+    status VARCHAR NOT NULL DEFAULT 'queued' CHECK (status IN (
+        'queued', 'sending', 'sent', 'failed', 'rendered', 'mailed', 'in_transit', 'in_local_area',
+        'processed_for_delivery', 're-routed', 'delivered', 'returned_to_sender', 'cancelled'
+    )),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    lastError VARCHAR,
+    timeQueued TIMESTAMP NOT NULL DEFAULT now(),
+    timeNextAttempt TIMESTAMP NOT NULL DEFAULT now(),
+    timeDispatchStarted TIMESTAMP,
+    -- Set once every preview Lob rendered is stored in letter_previews
+    timePreviewsStored TIMESTAMP,
+    cost NUMERIC(10, 2),
+    lobEnvironment VARCHAR NOT NULL DEFAULT 'test' CHECK (lobEnvironment IN ('test', 'live')),
+    -- The sender's return address, or NULL to use InTouch's forwarding address
+    returnAddressId VARCHAR REFERENCES return_addresses(id),
+    -- Lob holds the letter until this time, and it can be cancelled until then
+    sendDate TIMESTAMP NOT NULL DEFAULT now(),
+    -- Whether Lob was given the send date; if it was too close, Lob prints
+    -- the letter straight away and it can't be cancelled
+    heldByLob BOOLEAN NOT NULL DEFAULT false,
+    timeCancelled TIMESTAMP,
+    mailType VARCHAR NOT NULL DEFAULT 'usps_standard' CHECK (mailType IN ('usps_standard', 'usps_first_class')),
+    color BOOLEAN NOT NULL DEFAULT false,
+    doubleSided BOOLEAN NOT NULL DEFAULT true,
+    extraService VARCHAR CHECK (extraService IN ('certified', 'certified_return_receipt', 'registered')),
+    addressPlacement VARCHAR NOT NULL DEFAULT 'top_first_page' CHECK (addressPlacement IN ('top_first_page', 'insert_blank_page')),
+    -- One of the themes listed in templates/themes.json
+    themeId VARCHAR NOT NULL DEFAULT 'plain',
+    format VARCHAR NOT NULL DEFAULT 'plain' CHECK (format IN ('plain', 'markdown')),
+    -- The language of the letter's header and footer, see locales/
+    locale VARCHAR NOT NULL DEFAULT 'en',
+    -- Bigger, more widely spaced black text for readers with poor eyesight
+    largePrint BOOLEAN NOT NULL DEFAULT false,
+    -- Printed in this order after the text, see photos
+    photoIds VARCHAR[] NOT NULL DEFAULT '{}',
+    -- One of the cards listed in templates/cards.json, or NULL for a letter
+    cardId VARCHAR
+);
+
+CREATE INDEX letters_dispatch ON letters (status, timeNextAttempt);
+
+-- Postcards are sent to Lob as soon as they're written, so unlike letters
+-- they're never queued
+CREATE TABLE postcards (
+    id VARCHAR PRIMARY KEY,
+    author VARCHAR NOT NULL CHECK (length(author) > 0),
+    recipient VARCHAR NOT NULL REFERENCES inmates(id),
+    message VARCHAR NOT NULL CHECK (length(message) > 0),
+    size VARCHAR NOT NULL DEFAULT '4x6' CHECK (size IN ('4x6', '6x9', '6x11')),
+    frontPhotoId VARCHAR REFERENCES photos(id),
+    returnAddressId VARCHAR REFERENCES return_addresses(id),
+    locale VARCHAR NOT NULL DEFAULT 'en',
+    lobPostcardId VARCHAR UNIQUE CHECK (length(lobPostcardId) > 0),
+    lobEnvironment VARCHAR NOT NULL DEFAULT 'test' CHECK (lobEnvironment IN ('test', 'live')),
+    status VARCHAR NOT NULL DEFAULT 'sending' CHECK (status IN (
+        'sending', 'sent', 'failed', 'rendered', 'mailed', 'in_transit', 'in_local_area',
+        'processed_for_delivery', 're-routed', 'delivered', 'returned_to_sender', 'cancelled'
+    )),
+    lastError VARCHAR,
+    timeSent TIMESTAMP NOT NULL DEFAULT now(),
+    timeDeliveredEstimate DATE
+);
+
+CREATE INDEX postcards_author ON postcards (author);
+
+-- The rendered PDF and page thumbnails of each letter, downloaded from Lob and
+-- kept in the blob store under blobKey
+CREATE TABLE letter_previews (
+    letterId VARCHAR NOT NULL REFERENCES letters(id),
+    name VARCHAR NOT NULL,
+    blobKey VARCHAR NOT NULL,
+    contentType VARCHAR NOT NULL,
+    byteSize INTEGER NOT NULL,
+    timeStored TIMESTAMP NOT NULL DEFAULT now(),
+    PRIMARY KEY(letterId, name)
 );
 
-INSERT INTO facilities VALUES
+-- One row per Lob webhook event, keyed by the Lob event ID so that redelivered
+-- webhooks are only recorded once
+CREATE TABLE letter_events (
+    id VARCHAR PRIMARY KEY,
+    letterId VARCHAR NOT NULL REFERENCES letters(id),
+    eventType VARCHAR NOT NULL,
+    status VARCHAR NOT NULL,
+    location VARCHAR,
+    timeOccurred TIMESTAMP NOT NULL,
+    timeReceived TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX letter_events_letter ON letter_events (letterId, timeOccurred);
+
+-- Every reconciliation run keeps the full list of discrepancies it found and
+-- what it changed, so that repairs can be audited afterwards
+CREATE TABLE reconciliation_runs (
+    id VARCHAR PRIMARY KEY,
+    environment VARCHAR NOT NULL,
+    repair BOOLEAN NOT NULL,
+    timeStarted TIMESTAMP NOT NULL,
+    timeFinished TIMESTAMP NOT NULL,
+    lobLettersChecked INTEGER NOT NULL,
+    dbLettersChecked INTEGER NOT NULL,
+    discrepancies INTEGER NOT NULL,
+    repaired INTEGER NOT NULL,
+    report JSONB NOT NULL
+);
+
+-- QA accounts whose letters always go to the Lob test environment, even on a
+-- deployment that mails live letters
+CREATE TABLE sandbox_users (
+    username VARCHAR PRIMARY KEY,
+    note VARCHAR,
+    timeAdded TIMESTAMP NOT NULL DEFAULT now()
+);
+
+-- Settings users choose in the app. Users without a row get the defaults.
+CREATE TABLE user_preferences (
+    username VARCHAR PRIMARY KEY,
+    locale VARCHAR NOT NULL,
+    timeUpdated TIMESTAMP NOT NULL DEFAULT now()
+);
+
+-- Users on a plan other than the free one, e.g. partner organizations
+CREATE TABLE user_plans (
+    username VARCHAR PRIMARY KEY,
+    plan VARCHAR NOT NULL CHECK (plan IN ('free', 'partner')),
+    timeUpdated TIMESTAMP NOT NULL DEFAULT now()
+);
+
+-- Letter options a user saved for everything they send to one recipient
+CREATE TABLE recipient_defaults (
+    username VARCHAR NOT NULL,
+    inmateId VARCHAR NOT NULL REFERENCES inmates (id),
+    largePrint BOOLEAN NOT NULL DEFAULT false,
+    timeUpdated TIMESTAMP NOT NULL DEFAULT now(),
+    PRIMARY KEY (username, inmateId)
+);
+
+-- Deletion requests are kept after they complete so that we have a record of
+-- when and how a user's data was removed
+CREATE TABLE account_deletions (
+    id VARCHAR PRIMARY KEY,
+    username VARCHAR NOT NULL CHECK (length(username) > 0),
+    auth0UserId VARCHAR NOT NULL CHECK (length(auth0UserId) > 0),
+    status VARCHAR NOT NULL CHECK (status IN ('pending', 'cancelled', 'completed')),
+    timeRequested TIMESTAMP NOT NULL,
+    timeScheduled TIMESTAMP NOT NULL,
+    timeCompleted TIMESTAMP,
+    lettersDeleted INTEGER NOT NULL DEFAULT 0,
+    lettersAnonymized INTEGER NOT NULL DEFAULT 0,
+    error VARCHAR
+);
+
+CREATE UNIQUE INDEX account_deletions_pending ON account_deletions (username) WHERE status = 'pending';
+
+INSERT INTO facilities (name, shortName, addressLine, city, state, zip, lobTestAddressId, lobLiveAddressId) VALUES
     ('Bridgeport Correctional Center',                                                      'Bridgeport CC',            '1106 North Avenue',                'Bridgeport',       'CT',   '06604', 'adr_8ee3fb884ac685d4', ''),
     ('Brooklyn Correctional Institution',                                                   'Brooklyn CI',              '59 Hartford Road',                 'Brooklyn',         'CT',   '06234', 'adr_55a1e5bb48f6a073', ''),
     ('Cheshire Correctional Institution',                                                   'Cheshire CI',              '900 Highland Avenue',              'Cheshire',         'CT',   '06410', 'adr_00f18daf8fde8d69', ''),
@@ -164,7 +164,7 @@ func ScrapeInmates() error {
 
 	inmates := []models.Inmate{}
 	for i := 65; i < 65+AlphabetSize; i++ {
-		letter := string(i)
+		letter := string(rune(i))
 		letterInmates := getInmatesByLastName(ctxt, chrome, letter, facilities)
 		printInmateBatchSize(letterInmates)
 		inmates = append(inmates, letterInmates...)
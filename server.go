@@ -9,12 +9,20 @@ import (
 	"time"
 
 	"github.com/johnamadeo/intouchgo/auth"
+	"github.com/johnamadeo/intouchgo/i18n"
+	"github.com/johnamadeo/intouchgo/lob"
+	"github.com/johnamadeo/intouchgo/mailer"
 	"github.com/johnamadeo/intouchgo/models"
+	"github.com/johnamadeo/intouchgo/render"
 	"github.com/johnamadeo/intouchgo/routes"
 	"github.com/johnamadeo/intouchgo/scraper"
 	"github.com/johnamadeo/intouchgo/utils"
 )
 
+const (
+	LetterDispatchInterval = 30 * time.Second
+)
+
 func main() {
 	if len(os.Args) >= 2 && os.Args[1] == "--scraper" {
 		// the Heroku scheduler can only schedule jobs on a daily or hourly basis
@@ -27,12 +35,109 @@ func main() {
 				log.Fatal(err)
 			}
 		}
+	} else if len(os.Args) >= 2 && os.Args[1] == "--process-deletions" {
+		// Run hourly by the Heroku scheduler to carry out account deletions
+		// whose grace period has passed
+		fmt.Println("Processing account deletions")
+		err := models.ProcessDueAccountDeletions()
+		if err != nil {
+			fmt.Println(err.Error())
+			log.Fatal(err)
+		}
+	} else if len(os.Args) >= 2 && os.Args[1] == "--reconcile" {
+		// Run daily by the Heroku scheduler as "--reconcile --repair"; without
+		// --repair discrepancies are only reported
+		repair := len(os.Args) >= 3 && os.Args[2] == "--repair"
+
+		for _, lobEnvironment := range lob.GetConfiguredEnvironments() {
+			fmt.Println("Reconciling " + lobEnvironment + " letters with Lob")
+			report, err := models.ReconcileLetters(lobEnvironment, repair)
+			if err != nil {
+				fmt.Println(err.Error())
+				log.Fatal(err)
+			}
+			models.PrintReconciliationReport(report)
+		}
+	} else if len(os.Args) >= 2 && os.Args[1] == "--provision-facilities" {
+		dryRun := len(os.Args) >= 3 && os.Args[2] == "--dry-run"
+
+		fmt.Println("Verifying facility addresses and provisioning Lob addresses")
+		results, err := models.ProvisionFacilityAddresses(dryRun)
+		if err != nil {
+			fmt.Println(err.Error())
+			log.Fatal(err)
+		}
+
+		for _, result := range results {
+			fmt.Printf("%s: %s, test=%s live=%s\n",
+				result.Facility,
+				result.Deliverability,
+				result.AddressIds[lob.LobTestEnvironment],
+				result.AddressIds[lob.LobLiveEnvironment],
+			)
+			for _, difference := range result.Differences {
+				fmt.Println("  NEEDS REVIEW " + difference)
+			}
+			if result.Error != "" {
+				fmt.Println("  ERROR " + result.Error)
+			}
+		}
+	} else if len(os.Args) >= 2 && os.Args[1] == "--smtp" {
+		// Local stand-in for a real SMTP provider, used when testing signup,
+		// verification and password reset emails
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "2525"
+		}
+
+		fmt.Println("Running local SMTP server on port " + port)
+		log.Fatal(mailer.NewServer(":" + port).ListenAndServe())
+	} else if len(os.Args) >= 2 && os.Args[1] == "--check-templates" {
+		// Run before deploying: fails if a message catalog is missing a key
+		// or a letter template doesn't render in every language
+		err := loadTemplates()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("Message catalogs and letter templates are valid")
 	} else {
+		err := loadTemplates()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		go models.RunLetterDispatcher(LetterDispatchInterval, nil)
+
 		serveMux := http.NewServeMux()
 		serveMux.Handle("/inmates", auth.GetAuthHandler(routes.InmatesHandler))
 		serveMux.Handle("/letter", auth.GetAuthHandler(routes.CreateLetterHandler))
 		serveMux.Handle("/letters", auth.GetAuthHandler(routes.LettersHandler))
+		serveMux.Handle("/letters/", auth.GetAuthHandler(routes.LetterHandler))
+		serveMux.Handle("/letters/preview", auth.GetAuthHandler(routes.PreviewLetterHandler))
+		serveMux.Handle("/letters/estimate", auth.GetAuthHandler(routes.EstimateLetterHandler))
+		serveMux.Handle("/themes", auth.GetAuthHandler(routes.ThemesHandler))
+		serveMux.Handle("/cards", auth.GetAuthHandler(routes.CardsHandler))
+		serveMux.Handle("/photos", auth.GetAuthHandler(routes.PhotosHandler))
+		serveMux.Handle("/postcards", auth.GetAuthHandler(routes.PostcardsHandler))
+		serveMux.Handle("/history", auth.GetAuthHandler(routes.HistoryHandler))
 		serveMux.Handle("/user", auth.GetAuthHandler(routes.CreateUserHandler))
+		serveMux.Handle("/user/verification-email", auth.GetAuthHandler(routes.VerificationEmailHandler))
+		serveMux.Handle("/user/export", auth.GetAuthHandler(routes.ExportHandler))
+		serveMux.Handle("/user/deletion", auth.GetAuthHandler(routes.AccountDeletionHandler))
+		serveMux.Handle("/user/addresses", auth.GetAuthHandler(routes.ReturnAddressesHandler))
+		serveMux.Handle("/user/preferences", auth.GetAuthHandler(routes.PreferencesHandler))
+		serveMux.Handle("/user/recipient-defaults", auth.GetAuthHandler(routes.RecipientDefaultsHandler))
+		// Users who forgot their password can't get an access token, so this
+		// route can't sit behind the JWT middleware
+		serveMux.HandleFunc("/user/password-reset", routes.PasswordResetHandler)
+		// Lob downloads the photos in letters through signed links, see
+		// models.GetPhotoPrint
+		serveMux.HandleFunc("/photos/", routes.PhotoPrintHandler)
+		// Lob signs its webhooks, see routes.LobWebhookHandler
+		serveMux.HandleFunc("/webhooks/lob", routes.LobWebhookHandler)
+		serveMux.Handle("/admin/status", auth.GetAdminHandler(routes.AdminStatusHandler))
+		serveMux.Handle("/admin/sandbox-users", auth.GetAdminHandler(routes.SandboxUsersHandler))
+		serveMux.Handle("/admin/facilities/provision", auth.GetAdminHandler(routes.ProvisionFacilitiesHandler))
 		serveMux.Handle("/", http.FileServer(http.Dir("./static")))
 
 		serveMux.Handle("/test/letters", auth.GetFakeAuthHandler(routes.LettersHandler))
@@ -61,6 +166,17 @@ func main() {
 		if port == "" {
 			port = "8080"
 		}
-		log.Fatal(http.ListenAndServe(":"+port, serveMux))
+		log.Fatal(http.ListenAndServe(":"+port, routes.LocaleHandler(serveMux)))
+	}
+}
+
+// loadTemplates loads the message catalogs and then the letter templates,
+// which are checked in every language
+func loadTemplates() error {
+	err := i18n.LoadCatalogs(i18n.LocalesDir)
+	if err != nil {
+		return err
 	}
+
+	return render.LoadTemplates(render.TemplatesDir)
 }
@@ -3,9 +3,6 @@ package utils
 import (
 	"encoding/json"
 	"fmt"
-	"reflect"
-	"strconv"
-	"strings"
 )
 
 type Message struct {
@@ -17,59 +14,26 @@ func MessageToBytes(message string) []byte {
 	return bytes
 }
 
-func PrintErr(err error) {
-	fmt.Println(err.Error())
+// FieldError describes why a single field of a request body was rejected.
+// Key and Args are the catalog key and arguments Message was built from, so
+// that routes can show it in the user's language.
+type FieldError struct {
+	Field   string
+	Message string
+	Key     string        `json:"-"`
+	Args    []interface{} `json:"-"`
+}
+
+type ValidationMessage struct {
+	Message string
+	Errors  []FieldError
 }
 
-// Use JSON tag information to create a form values map.
-func JSONToForm(v interface{}) map[string]string {
-	value := reflect.ValueOf(v)
-	t := value.Type()
-	params := make(map[string]string)
-	for i := 0; i < value.NumField(); i++ {
-		f := t.Field(i)
-		name := f.Tag.Get("json")
-		fv := value.Field(i).Interface()
-		if fv == nil {
-			continue
-		}
-		switch x := fv.(type) {
-		case *string:
-			if x != nil {
-				params[name] = *x
-			}
-		case string:
-			if x != "" {
-				params[name] = x
-			}
-		case int:
-			if x != 0 {
-				params[name] = strconv.Itoa(x)
-			}
-		case *bool:
-			if x != nil {
-				params[name] = fmt.Sprintf("%v", *x)
-			}
-		case bool:
-			params[name] = fmt.Sprintf("%v", x)
-		case int64:
-			if x != 0 {
-				params[name] = strconv.FormatInt(x, 10)
-			}
-		case float64:
-			params[name] = fmt.Sprintf("%.2f", x)
-		case []string:
-			if len(x) > 0 {
-				params[name] = strings.Join(x, " ")
-			}
-		case map[string]string:
-			for mapkey, mapvalue := range x {
-				params[name+"["+mapkey+"]"] = mapvalue
-			}
-		default:
-			// ignore
-			panic(fmt.Errorf("Unknown field type: " + value.Field(i).Type().String()))
-		}
-	}
-	return params
+func ValidationErrorsToBytes(message string, errors []FieldError) []byte {
+	bytes, _ := json.Marshal(ValidationMessage{message, errors})
+	return bytes
+}
+
+func PrintErr(err error) {
+	fmt.Println(err.Error())
 }